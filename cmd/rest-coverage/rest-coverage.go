@@ -1,33 +1,164 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/metrics"
+	"github.com/mfranczy/crd-rest-coverage/pkg/proxy"
 	"github.com/mfranczy/crd-rest-coverage/pkg/report"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
 )
 
 var (
 	BuildVersion string = ""
 )
 
+// followPollInterval is an interval between reads of the followed log
+const followPollInterval = time.Second
+
+// stringSlice is a repeatable string flag
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func main() {
 	var (
+		mode                  string
 		auditLogPath          string
+		inputPath             string
+		inputFormat           string
 		swaggerPath           string
 		outputJSONPath        string
 		detailed              bool
 		ignoreResourceVersion bool
+		excludeDiscovery      bool
 		version               bool
+		proxyAddress          string
+		proxyTarget           string
+		proxyCAFile           string
+		proxyCertFile         string
+		proxyKeyFile          string
+		proxyInsecure         bool
+		include               stringSlice
+		exclude               stringSlice
+		windowStart           string
+		windowEnd             string
+		windowStageTimestamp  bool
+		exclusionsPath        string
+		onlyUncovered         bool
+		maxDepth              int
+		color                 string
+		outputFormat          string
+		baselinePath          string
+		markdownMaxSize       int
+		tableMode             string
+		metricsAddress        string
+		metricsOptions        metrics.Options
+		workers               int
+		dedupe                bool
+		checkpointPath        string
+		checkpointInterval    time.Duration
+		follow                bool
+		refreshInterval       time.Duration
+		testAttribution       string
+		testsCovering         string
+		coveredByTest         string
+		analyzeTests          bool
+		scoring               string
+		excludeReadOnly       bool
 	)
 
-	// TODO: add filter param
+	flag.StringVar(&mode, "mode", "file", "coverage source: 'file' reads requests log, 'proxy' records requests passed through a reverse proxy")
 	flag.StringVar(&swaggerPath, "swagger-path", "", "path to swagger file")
-	flag.StringVar(&auditLogPath, "audit-log-path", "", "path to k8s audit log file")
-	flag.StringVar(&outputJSONPath, "output-path", "", "destination path for report file")
-	flag.BoolVar(&detailed, "detailed", false, "show report with coverage for each endpoint")
+	flag.StringVar(&auditLogPath, "audit-log-path", "", "path to k8s audit log file, the same as --input-path with --input-format=audit-log")
+	flag.StringVar(&inputPath, "input-path", "", "path to requests log file")
+	flag.StringVar(&inputFormat, "input-format", event.FormatAuditLog, "requests log format: 'audit-log', 'har' or 'http-log'")
+	flag.StringVar(&outputJSONPath, "output-path", "", "destination path for report file, report is printed to stdout if not set")
+	flag.StringVar(&outputFormat, "output-format", "", "report format: 'text', 'json', 'markdown', 'openmetrics', 'csv', 'tsv' or 'cobertura', "+
+		"defaults to 'json' if --output-path is set and 'text' otherwise")
+	flag.StringVar(&tableMode, "table-mode", report.TableEndpoints, "rows of CSV and TSV reports: 'endpoints' writes one row per path and method, "+
+		"'fields' writes one row per body and query field")
+	flag.StringVar(&baselinePath, "baseline-path", "", "path to JSON report used to show coverage changes in markdown summary")
+	flag.IntVar(&markdownMaxSize, "markdown-max-size", report.DefaultMarkdownMaxSize, "size limit of markdown summary in bytes, 0 does not limit anything")
+	flag.BoolVar(&detailed, "detailed", false, "show report with coverage for each endpoint and its body and query fields")
+	flag.BoolVar(&onlyUncovered, "only-uncovered", false, "show only not called endpoints and uncovered fields in detailed report")
+	flag.IntVar(&maxDepth, "max-depth", 0, "limit depth of fields in detailed report, 0 does not limit anything")
+	flag.StringVar(&color, "color", "auto", "color report: 'auto' enables colors when writing to terminal, 'always' or 'never'")
 	flag.BoolVar(&ignoreResourceVersion, "ignore-resource-version", false, "ignore resource version")
+	flag.Var(&include, "include", "include only endpoints and requests matching the rule, can be repeated; "+
+		"rule format is type:value where type is one of prefix, glob, regex, group, version, resource, verb "+
+		"or requester attributes user, user-group, impersonated-user, impersonated-group, user-agent, source-ip, namespace, "+
+		"a request has to match one rule of each type, prefix, glob and regex are a single type, "+
+		"as an example, --include=group:kubevirt.io --include=user:system:serviceaccount:e2e:*")
+	flag.Var(&exclude, "exclude", "exclude endpoints and requests matching the rule, can be repeated; rule format is the same as for --include")
+	flag.StringVar(&windowStart, "window-start", "", "include only requests received at or after the bound; "+
+		"bound is RFC 3339 time, duration relative to the first request like 5m or marker event like "+
+		"marker:configmaps/e2e-phase-start which matches creation of the object, format is marker:[namespace/]resource/name[@verb]")
+	flag.StringVar(&windowEnd, "window-end", "", "include only requests received before the bound, the format is the same as for --window-start")
+	flag.BoolVar(&windowStageTimestamp, "window-stage-timestamp", false, "use time of the response instead of time when the request was received")
+	flag.StringVar(&exclusionsPath, "exclusions-path", "", "path to JSON file with endpoints and fields which are intentionally not tested, "+
+		`as an example, {"exclusions": [{"path": "/apis/*/v1/namespaces/{namespace}/foos", "method": "delete", "reason": "not supported"}]}, `+
+		"excluded items are not included in total coverage")
+	flag.BoolVar(&excludeReadOnly, "exclude-read-only", false, "exclude read-only fields from total coverage, that includes status "+
+		"of k8s objects in bodies of endpoints other than the status subresource and fields described as read-only like metadata.uid")
+	flag.IntVar(&workers, "workers", 1, "number of goroutines decoding and matching requests in file mode, 1 processes requests sequentially")
+	flag.BoolVar(&dedupe, "dedupe", false, "count requests with the same audit ID once, audit logs may record a separate event for each request stage")
+	flag.StringVar(&checkpointPath, "checkpoint-path", "", "path to checkpoint file in file mode, state is saved periodically and processing "+
+		"is resumed from the saved position of the requests log if the file exists, the input format has to be line based")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", time.Minute, "interval between checkpoints")
+	flag.BoolVar(&follow, "follow", false, "follow the requests log like tail -F until the process gets SIGINT or SIGTERM, "+
+		"rotated and truncated logs are followed, the report is rewritten every --refresh-interval if --output-path is set, "+
+		"otherwise a short summary is printed, the input format has to be line based")
+	flag.DurationVar(&refreshInterval, "refresh-interval", 10*time.Second, "interval between report updates in follow mode")
+	flag.StringVar(&testAttribution, "test-attribution", "", "attribute requests to tests: 'user-agent[:separator]' takes a test name "+
+		"from the user agent suffix, ' -- ' by default as k8s e2e framework, 'annotation:key' takes it from the audit annotation, "+
+		"'marker:[namespace/]resource' attributes requests to the test named by the last created object of the resource until it is deleted")
+	flag.StringVar(&testsCovering, "tests-covering", "", "print tests which cover endpoints or fields instead of the report, "+
+		"query format is key=value[,key=value] with keys path, method, field and location as in exclusions, "+
+		"as an example, path=/apis/kubevirt.io/**,field=spec.domain.devices")
+	flag.StringVar(&coveredByTest, "covered-by-test", "", "print endpoints and fields covered by the test instead of the report")
+	flag.BoolVar(&analyzeTests, "analyze-tests", false, "add contribution of each test to the report with tests which do not cover "+
+		"anything unique and a minimal subset of tests which preserves the coverage, requests have to be attributed to tests")
+	flag.StringVar(&scoring, "scoring", "", "scoring model of the coverage: 'weighted' scores a method call and each field by weights, "+
+		"'endpoints' scores only method calls, 'fields' scores only fields; weights are set as key=value[,key=value] with keys metric, "+
+		"method, body, query, required, optional and decay, a field weight is multiplied by the decay for each level of nesting, "+
+		"as an example, metric=weighted,method=10,required=2,decay=0.5, every weight is 1 by default")
+	flag.BoolVar(&excludeDiscovery, "exclude-discovery", false, "exclude API discovery endpoints like /apis or /version from total coverage")
+	flag.StringVar(&metricsAddress, "metrics-address", "", "address of /metrics endpoint exposing coverage in OpenMetrics format in proxy mode, empty disables it")
+	flag.BoolVar(&metricsOptions.Fields, "metrics-fields", false, "expose hits per body and query field, it may produce a lot of series")
+	flag.IntVar(&metricsOptions.MaxFieldDepth, "metrics-max-field-depth", 0, "limit depth of field metrics, hits of deeper fields are summed by their ancestor, 0 does not limit anything")
+	flag.IntVar(&metricsOptions.MaxFieldSeries, "metrics-max-field-series", 0, "limit number of field series, 0 does not limit anything")
+	flag.StringVar(&proxyAddress, "proxy-address", ":8080", "address the proxy listens on")
+	flag.StringVar(&proxyTarget, "proxy-target", "", "API server URL the proxy forwards requests to")
+	flag.StringVar(&proxyCAFile, "proxy-ca-file", "", "path to CA certificate used to verify the API server")
+	flag.StringVar(&proxyCertFile, "proxy-cert-file", "", "path to client certificate used to authenticate to the API server")
+	flag.StringVar(&proxyKeyFile, "proxy-key-file", "", "path to client key used to authenticate to the API server")
+	flag.BoolVar(&proxyInsecure, "proxy-insecure-skip-tls-verify", false, "do not verify the API server certificate")
 	flag.BoolVar(&version, "version", false, "build version")
 	flag.Parse()
 
@@ -36,19 +167,437 @@ func main() {
 		return
 	}
 
-	// TODO: improve glog format
-	if swaggerPath == "" || auditLogPath == "" {
-		glog.Exitf("params --swagger-path and --audit-log-path are required")
+	// output settings are checked before the report is generated, so a typo does not waste a long run
+	if outputFormat == "" {
+		outputFormat = "text"
+		if outputJSONPath != "" {
+			outputFormat = "json"
+		}
+	}
+	switch outputFormat {
+	case "text", "json", "markdown", "openmetrics", "csv", "tsv", "cobertura":
+	default:
+		glog.Exitf("invalid --output-format '%s', expected 'text', 'json', 'markdown', 'openmetrics', 'csv', 'tsv' or 'cobertura'",
+			outputFormat)
+	}
+	if (testsCovering != "" || coveredByTest != "") && outputFormat != "text" && outputFormat != "json" {
+		glog.Exitf("invalid --output-format '%s' for test queries, expected 'text' or 'json'", outputFormat)
+	}
+	if color != "auto" && color != "always" && color != "never" {
+		glog.Exitf("invalid --color '%s', expected 'auto', 'always' or 'never'", color)
+	}
+
+	var (
+		coverage *stats.Coverage
+		err      error
+	)
+	requestFilter, err := filter.New(include, exclude)
+	if err != nil {
+		glog.Exit(err)
+	}
+	window, err := filter.NewWindow(windowStart, windowEnd, windowStageTimestamp)
+	if err != nil {
+		glog.Exit(err)
+	}
+	var exclusions *exclusion.List
+	if exclusionsPath != "" {
+		if exclusions, err = exclusion.Load(exclusionsPath); err != nil {
+			glog.Exit(err)
+		}
+	}
+	attribution, err := filter.NewAttribution(testAttribution)
+	if err != nil {
+		glog.Exit(err)
+	}
+	var testQuery *report.TestQuery
+	if testsCovering != "" {
+		if testQuery, err = report.ParseTestQuery(testsCovering); err != nil {
+			glog.Exit(err)
+		}
+	}
+	var scoringModel *stats.Scoring
+	if scoring != "" {
+		if scoringModel, err = report.ParseScoring(scoring); err != nil {
+			glog.Exit(err)
+		}
+	}
+	config := report.Config{
+		SwaggerPath:           swaggerPath,
+		Filter:                requestFilter,
+		IgnoreResourceVersion: ignoreResourceVersion,
+		ExcludeDiscovery:      excludeDiscovery,
+		Dedupe:                dedupe,
+		Attribution:           attribution,
+		AnalyzeTests:          analyzeTests,
+		Scoring:               scoringModel,
+		Workers:               workers,
+		Window:                window,
+		Exclusions:            exclusions,
+		ExcludeReadOnly:       excludeReadOnly,
+	}
+
+	var baseline *stats.Coverage
+	if baselinePath != "" {
+		if baseline, err = report.Load(baselinePath); err != nil {
+			glog.Exit(err)
+		}
+	}
+
+	print := func(coverage *stats.Coverage) func(out *os.File) error {
+		return func(out *os.File) error {
+			switch outputFormat {
+			case "text":
+				return report.Fprint(out, coverage, report.PrintOptions{
+					Detailed:      detailed,
+					OnlyUncovered: onlyUncovered,
+					MaxDepth:      maxDepth,
+					Color:         color == "always" || (color == "auto" && report.IsTerminal(out)),
+				})
+			case "markdown":
+				return report.FprintMarkdown(out, coverage, report.MarkdownOptions{Baseline: baseline, MaxSize: markdownMaxSize})
+			case "openmetrics":
+				return metrics.Encode(out, coverage, metricsOptions)
+			case "csv":
+				return report.WriteTable(out, coverage, report.TableOptions{Separator: ',', Mode: tableMode})
+			case "tsv":
+				return report.WriteTable(out, coverage, report.TableOptions{Separator: '\t', Mode: tableMode})
+			case "cobertura":
+				return report.FprintCobertura(out, coverage, time.Now())
+			default:
+				return fmt.Errorf("invalid --output-format '%s', expected 'text', 'json', 'markdown', 'openmetrics', 'csv', 'tsv' or 'cobertura'",
+					outputFormat)
+			}
+		}
 	}
 
-	coverage, err := report.Generate(auditLogPath, swaggerPath, "", ignoreResourceVersion)
+	// TODO: improve glog format
+	switch mode {
+	case "file":
+		if inputPath == "" && inputFormat == event.FormatAuditLog {
+			inputPath = auditLogPath
+		}
+		if swaggerPath == "" || inputPath == "" {
+			glog.Exitf("params --swagger-path and --input-path (or --audit-log-path) are required")
+		}
+		if follow {
+			refresh := func(coverage *stats.Coverage, progress report.Progress) error {
+				if outputJSONPath == "" {
+					return report.FprintProgress(os.Stdout, coverage, progress, time.Now())
+				}
+				// the report is replaced at once, so it can be read while it is refreshed
+				tmpPath := outputJSONPath + ".tmp"
+				if err := writeReport(coverage, outputFormat, tmpPath, print(coverage)); err != nil {
+					return err
+				}
+				return os.Rename(tmpPath, outputJSONPath)
+			}
+			coverage, err = followCoverage(inputPath, inputFormat, checkpointPath, checkpointInterval, refreshInterval, config, refresh)
+		} else if checkpointPath != "" {
+			coverage, err = generateWithCheckpoints(inputPath, inputFormat, checkpointPath, checkpointInterval, config)
+		} else {
+			coverage, err = report.GenerateFromFile(inputPath, inputFormat, config)
+		}
+	case "proxy":
+		if swaggerPath == "" || proxyTarget == "" {
+			glog.Exitf("params --swagger-path and --proxy-target are required")
+		}
+		coverage, err = recordCoverage(proxyAddress, proxyTarget, proxyCAFile, proxyCertFile, proxyKeyFile, proxyInsecure,
+			metricsAddress, config, metricsOptions)
+	default:
+		glog.Exitf("invalid --mode '%s', expected 'file' or 'proxy'", mode)
+	}
 	if err != nil {
 		glog.Exit(err)
 	}
 
-	if outputJSONPath != "" {
-		report.Dump(outputJSONPath, coverage)
+	if testQuery != nil || coveredByTest != "" {
+		var items []*report.CoveredItem
+		if testQuery != nil {
+			items = report.TestsCovering(coverage, testQuery)
+		} else {
+			items = report.CoveredByTest(coverage, coveredByTest)
+		}
+		err = writeItems(items, outputFormat, outputJSONPath)
 	} else {
-		report.Print(coverage, detailed)
+		err = writeReport(coverage, outputFormat, outputJSONPath, print(coverage))
+	}
+	if err != nil {
+		glog.Exit(err)
+	}
+}
+
+// writeItems saves results of test queries in JSON or text format into the output file or prints them to stdout if path is empty
+func writeItems(items []*report.CoveredItem, format string, path string) error {
+	return writeOutput(path, func(out *os.File) error {
+		switch format {
+		case "json":
+			return json.NewEncoder(out).Encode(items)
+		case "text":
+			return report.FprintCoveredItems(out, items)
+		default:
+			return fmt.Errorf("invalid --output-format '%s' for test queries, expected 'text' or 'json'", format)
+		}
+	})
+}
+
+// writeReport saves report into the output file or prints it to stdout if path is empty,
+// JSON report is saved by report.Dump, other formats are written by print function
+func writeReport(coverage *stats.Coverage, format string, path string, print func(out *os.File) error) error {
+	if format == "json" {
+		if path != "" {
+			return report.Dump(path, coverage)
+		}
+		data, err := json.Marshal(coverage)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(data))
+		return err
+	}
+
+	return writeOutput(path, print)
+}
+
+// writeOutput writes into the output file or to stdout if path is empty, an error of closing the file is returned
+// as the output may not be fully written
+func writeOutput(path string, write func(out *os.File) error) (err error) {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return write(f)
+}
+
+// generateWithCheckpoints matches requests of the log file and saves state to the checkpoint file every interval,
+// once the file is read and when the process gets SIGINT or SIGTERM, an existing checkpoint is restored first,
+// so the file is read from the saved position
+func generateWithCheckpoints(inputPath string, format string, checkpointPath string, interval time.Duration,
+	config report.Config) (*stats.Coverage, error) {
+	collector, err := newCollector(config, checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				cancel()
+				return
+			case <-ticker.C:
+				if err := report.SaveCheckpoint(checkpointPath, collector.Checkpoint()); err != nil {
+					glog.Errorf("Could not save checkpoint '%s': %s", checkpointPath, err)
+				}
+			}
+		}
+	}()
+
+	_, err = collector.ReadFile(ctx, path, format, false)
+	interrupted := ctx.Err() != nil
+	// periodic checkpoints are stopped first, so they do not overwrite the last one
+	cancel()
+	<-saved
+	if saveErr := report.SaveCheckpoint(checkpointPath, collector.Checkpoint()); saveErr != nil {
+		return nil, saveErr
+	}
+	if interrupted {
+		return nil, fmt.Errorf("Interrupted, state is saved in checkpoint '%s'", checkpointPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return collector.Coverage(), nil
+}
+
+// newCollector creates a collector and restores the checkpoint if it exists, checkpoint path can be empty
+func newCollector(config report.Config, checkpointPath string) (*report.Collector, error) {
+	collector, err := report.NewCollector(config.Options())
+	if err != nil || checkpointPath == "" {
+		return collector, err
+	}
+	checkpoint, err := report.LoadCheckpoint(checkpointPath)
+	if os.IsNotExist(err) {
+		return collector, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := collector.Restore(checkpoint); err != nil {
+		return nil, err
+	}
+	glog.Infof("Resuming from checkpoint '%s' saved at %s", checkpointPath, checkpoint.Time.Format(time.RFC3339))
+	return collector, nil
+}
+
+// followCoverage follows the log file until the process gets SIGINT or SIGTERM, refresh is called every refreshInterval
+// if new requests have been read, checkpoints are saved every checkpointInterval if checkpoint path is set
+func followCoverage(inputPath string, format string, checkpointPath string, checkpointInterval time.Duration,
+	refreshInterval time.Duration, config report.Config, refresh func(*stats.Coverage, report.Progress) error) (*stats.Coverage, error) {
+	collector, err := newCollector(config, checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	saveCheckpoint := func() {
+		if checkpointPath == "" {
+			return
+		}
+		if err := report.SaveCheckpoint(checkpointPath, collector.Checkpoint()); err != nil {
+			glog.Errorf("Could not save checkpoint '%s': %s", checkpointPath, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		refreshTicker := time.NewTicker(refreshInterval)
+		defer refreshTicker.Stop()
+		checkpointTicker := time.NewTicker(checkpointInterval)
+		defer checkpointTicker.Stop()
+		var refreshed report.Progress
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				cancel()
+				return
+			case <-refreshTicker.C:
+				if progress := collector.Progress(); progress != refreshed {
+					if err := refresh(collector.Coverage(), progress); err != nil {
+						glog.Errorf("Could not refresh report: %s", err)
+					}
+					refreshed = progress
+				}
+			case <-checkpointTicker.C:
+				saveCheckpoint()
+			}
+		}
+	}()
+
+	glog.Infof("Following '%s'", path)
+	err = collector.FollowFile(ctx, path, format, followPollInterval, nil)
+	// refreshing and periodic checkpoints are stopped first, so they do not overwrite the final report
+	cancel()
+	<-done
+	saveCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	return collector.Coverage(), nil
+}
+
+// recordCoverage runs the proxy and matches requests passed to the API server until the process gets SIGINT or SIGTERM,
+// if metricsAddress is set then coverage of requests recorded so far is exposed on /metrics endpoint
+func recordCoverage(address string, target string, caFile string, certFile string, keyFile string, insecure bool,
+	metricsAddress string, config report.Config, metricsOptions metrics.Options) (*stats.Coverage, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := proxyTransport(caFile, certFile, keyFile, insecure)
+	if err != nil {
+		return nil, err
+	}
+	collector, err := report.NewCollector(config.Options())
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []*http.Server{{
+		Addr: address,
+		Handler: proxy.New(targetURL, transport, func(e *event.Event) {
+			if err := collector.Observe(e); err != nil {
+				glog.Errorf("Could not match request '%s %s': %s", e.Method, e.RequestURI, err)
+			}
+		}),
+	}}
+	if metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(func() (*stats.Coverage, error) {
+			return collector.Coverage(), nil
+		}, metricsOptions))
+		servers = append(servers, &http.Server{Addr: metricsAddress, Handler: mux})
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	errs := make(chan error, len(servers))
+	glog.Infof("Proxy listens on '%s' and forwards requests to '%s'", address, target)
+	if metricsAddress != "" {
+		glog.Infof("Coverage metrics are served on '%s/metrics'", metricsAddress)
+	}
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.ListenAndServe()
+		}(server)
+	}
+
+	select {
+	case err := <-errs:
+		return nil, err
+	case <-stop:
+	}
+	for _, server := range servers {
+		if err := server.Shutdown(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return collector.Coverage(), nil
+}
+
+// proxyTransport builds a transport used by the proxy to connect to the API server
+func proxyTransport(caFile string, certFile string, keyFile string, insecure bool) (http.RoundTripper, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("Invalid CA certificate '%s'", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
 }
@@ -11,6 +11,7 @@ import (
 
 var petStoreSwaggerPath string
 var auditLogPath string
+var kubernetesSwaggerPath string
 
 func TestAnalysis(t *testing.T) {
 	_, p, _, ok := runtime.Caller(0)
@@ -19,6 +20,7 @@ func TestAnalysis(t *testing.T) {
 	}
 	fixturesPath := path.Join(path.Dir(p), "../../fixtures")
 	petStoreSwaggerPath = path.Join(fixturesPath, "test_petstore.json")
+	kubernetesSwaggerPath = path.Join(fixturesPath, "test_kubernetes.json")
 
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Analysis Suite")
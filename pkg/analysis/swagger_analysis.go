@@ -8,14 +8,22 @@ import (
 	"github.com/go-openapi/loads"
 	"github.com/go-openapi/spec"
 
+	reqfilter "github.com/mfranczy/crd-rest-coverage/pkg/filter"
 	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
 )
 
-// AnalyzeSwagger initializes a stats structure based on swagger definition with total params number for each available endpoint
+// AnalyzeSwagger initializes a stats structure based on swagger definition with total params number for each available endpoint,
+// only paths with the given prefix are included, empty filter does not limit anything
 func AnalyzeSwagger(document *loads.Document, filter string, ignoreResourceVersion bool) (*stats.Coverage, error) {
+	return AnalyzeSwaggerWithFilter(document, reqfilter.Prefix(filter), ignoreResourceVersion)
+}
+
+// AnalyzeSwaggerWithFilter initializes a stats structure based on swagger definition, only endpoints accepted by filter are included
+func AnalyzeSwaggerWithFilter(document *loads.Document, filter *reqfilter.Filter, ignoreResourceVersion bool) (*stats.Coverage, error) {
 	coverage := stats.Coverage{
 		Endpoints: make(map[string]map[string]*stats.Endpoint),
 	}
+	kubernetes := IsKubernetes(document)
 
 	for _, mp := range document.Analyzer.OperationMethodPaths() {
 		v := strings.Split(mp, " ")
@@ -24,6 +32,7 @@ func AnalyzeSwagger(document *loads.Document, filter string, ignoreResourceVersi
 		}
 		method, path := strings.ToLower(v[0]), strings.ToLower(v[1])
 		params := document.Analyzer.ParamsFor(method, path)
+		operation, _ := document.Analyzer.OperationFor(method, v[1])
 
 		// adjust name and namespace to simple format instead of copying regex from the swagger
 		re := regexp.MustCompile(`{(namespace|name):\[a-z0-9\]\[a-z0-9\\-\]\*}`)
@@ -37,8 +46,8 @@ func AnalyzeSwagger(document *loads.Document, filter string, ignoreResourceVersi
 			path = strings.Join(s, "/")
 		}
 
-		// filter requests uri
-		if !strings.HasPrefix(path, filter) {
+		action := getAction(operation)
+		if !filter.Match(reqfilter.Request{SwaggerPath: path, Method: method, Verb: reqfilter.ActionVerb(action)}) {
 			continue
 		}
 
@@ -47,6 +56,7 @@ func AnalyzeSwagger(document *loads.Document, filter string, ignoreResourceVersi
 		}
 
 		if _, ok := coverage.Endpoints[path][method]; !ok {
+			res, _ := reqfilter.ParseResourcePath(path)
 			coverage.Endpoints[path][method] = &stats.Endpoint{
 				Params: stats.Params{
 					Query: stats.NewTrie(),
@@ -54,6 +64,13 @@ func AnalyzeSwagger(document *loads.Document, filter string, ignoreResourceVersi
 				},
 				Path:               path,
 				Method:             method,
+				Action:             action,
+				Discovery:          kubernetes && IsDiscoveryPath(path),
+				Group:              res.Group,
+				Version:            res.Version,
+				Resource:           res.Resource,
+				Subresource:        res.Subresource,
+				Kind:               getKind(operation),
 				ExpectedUniqueHits: 1, // count endpoint calls
 			}
 			coverage.ExpectedUniqueHits++
@@ -74,6 +91,68 @@ func AnalyzeSwagger(document *loads.Document, filter string, ignoreResourceVersi
 	return &coverage, nil
 }
 
+// IsKubernetes checks if swagger defines k8s API, k8s operations define x-kubernetes-action extension,
+// discovery and non-resource URLs are recognized only for k8s API
+func IsKubernetes(document *loads.Document) bool {
+	for _, operations := range document.Analyzer.Operations() {
+		for _, operation := range operations {
+			if getAction(operation) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsDiscoveryPath checks if path is used by k8s API discovery, that includes API groups and versions listing,
+// /version and /openapi endpoints
+func IsDiscoveryPath(path string) bool {
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	switch s[0] {
+	case "api":
+		return len(s) <= 2
+	case "apis":
+		return len(s) <= 3
+	case "version":
+		return len(s) == 1
+	case "openapi":
+		return true
+	default:
+		return false
+	}
+}
+
+// getAction returns k8s action defined by x-kubernetes-action extension, empty if operation does not define it
+func getAction(operation *spec.Operation) string {
+	if operation == nil {
+		return ""
+	}
+	action, _ := operation.Extensions.GetString("x-kubernetes-action")
+	return action
+}
+
+// getKind returns kind defined by x-kubernetes-group-version-kind extension in format group/version/kind,
+// core API group is named core, empty if operation does not define it
+func getKind(operation *spec.Operation) string {
+	if operation == nil {
+		return ""
+	}
+	gvk, ok := operation.Extensions[strings.ToLower("x-kubernetes-group-version-kind")].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	group, _ := gvk["group"].(string)
+	version, _ := gvk["version"].(string)
+	kind, _ := gvk["kind"].(string)
+	if kind == "" {
+		return ""
+	}
+	if group == "" {
+		group = "core"
+	}
+	return group + "/" + version + "/" + kind
+}
+
 // addSwaggerParams adds parameters from swagger definition into coverage structure
 func addSwaggerParams(endpoint *stats.Endpoint, params map[string]spec.Parameter, definitions spec.Definitions) {
 	for _, param := range params {
@@ -81,11 +160,12 @@ func addSwaggerParams(endpoint *stats.Endpoint, params map[string]spec.Parameter
 		case "body":
 			if param.Schema != nil {
 				extractBodyParams(param.Schema, definitions, endpoint.Body, endpoint.Body.Root)
+				markStatusReadOnly(endpoint)
 			} else {
-				endpoint.Params.Body.Add(param.Name, endpoint.Body.Root, true)
+				describeParam(endpoint.Params.Body.Add(param.Name, endpoint.Body.Root, true), param)
 			}
 		case "query":
-			endpoint.Params.Query.Add(param.Name, endpoint.Query.Root, true)
+			describeParam(endpoint.Params.Query.Add(param.Name, endpoint.Query.Root, true), param)
 		default:
 			continue
 		}
@@ -116,17 +196,20 @@ func extractBodyParams(schema *spec.Schema, definitions spec.Definitions, body *
 
 	if len(def.Properties) > 0 {
 		for k, s := range def.Properties {
+			var n *stats.Node
 			if r := s.Ref.GetPointer(); r != nil && len(r.DecodedTokens()) > 0 {
-				n := body.Add(k, node, false)
+				n = body.Add(k, node, false)
 				extractBodyParams(&s, definitions, body, n)
 			} else if s.Items != nil && s.Items.Schema != nil {
 				// type array can have its own reference
 				// !multiple Schemas are not supported so far!
-				n := body.Add(k, node, false)
+				n = body.Add(k, node, false)
 				extractBodyParams(s.Items.Schema, definitions, body, n)
 			} else {
-				body.Add(k, node, true)
+				n = body.Add(k, node, true)
 			}
+			n.Required = isRequired(def.Required, k)
+			describeField(n, &s, definitions)
 		}
 	} else {
 		// reference exists but definition is an empty object{}
@@ -134,3 +217,70 @@ func extractBodyParams(schema *spec.Schema, definitions spec.Definitions, body *
 		body.ExpectedUniqueHits++
 	}
 }
+
+// isRequired checks if the property is listed in required properties of the definition
+func isRequired(required []string, property string) bool {
+	for _, r := range required {
+		if r == property {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// deprecated matches sentences of descriptions which start with a deprecation notice, for instance
+	// "Deprecated: use ports instead", a description which only mentions deprecated things does not match
+	deprecated = regexp.MustCompile(`(^|\.\s+)Deprecated:`)
+	// readOnly matches read-only sentences of k8s descriptions, for instance "Populated by the system. Read-only.",
+	// a description like "Mounted read-only if true" of a field which sets read-only mode does not match
+	readOnly = regexp.MustCompile(`(^|\.\s+)Read-only\.`)
+)
+
+// describeField sets type, deprecation and read-only metadata of the field from its schema, types of references
+// are taken from their definitions, definitions with properties are objects
+func describeField(node *stats.Node, schema *spec.Schema, definitions spec.Definitions) {
+	node.Deprecated = isDeprecated(schema.Description, schema.Extensions)
+	node.ReadOnly = schema.ReadOnly || readOnly.MatchString(schema.Description)
+	if len(schema.Type) > 0 {
+		node.Type = schema.Type[0]
+		return
+	}
+	if tokens := schema.Ref.GetPointer().DecodedTokens(); len(tokens) == 2 && tokens[0] == "definitions" {
+		if def, ok := definitions[tokens[1]]; ok {
+			switch {
+			case len(def.Type) > 0:
+				node.Type = def.Type[0]
+			case len(def.Properties) > 0:
+				node.Type = "object"
+			}
+		}
+	}
+}
+
+// describeParam sets metadata of a query param or a body param without schema
+func describeParam(node *stats.Node, param spec.Parameter) {
+	node.Required = param.Required
+	node.Deprecated = isDeprecated(param.Description, param.Extensions)
+	node.Type = param.Type
+}
+
+// isDeprecated checks deprecation hints of the description and x-kubernetes-deprecated extension
+func isDeprecated(description string, extensions spec.Extensions) bool {
+	if d, ok := extensions.GetBool("x-kubernetes-deprecated"); ok && d {
+		return true
+	}
+	return deprecated.MatchString(description)
+}
+
+// markStatusReadOnly marks status of a k8s object body as read-only, status is set by controllers
+// and it is ignored by all endpoints except the status subresource, a body is a k8s object if it has metadata
+func markStatusReadOnly(endpoint *stats.Endpoint) {
+	root := endpoint.Body.Root
+	if endpoint.Subresource == "status" || root.GetChild("metadata") == nil {
+		return
+	}
+	if status := root.GetChild("status"); status != nil {
+		status.ReadOnly = true
+	}
+}
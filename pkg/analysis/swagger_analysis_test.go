@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/go-openapi/loads"
+	"github.com/go-openapi/spec"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -13,6 +15,8 @@ import (
 	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
 )
 
+const fooCollectionPath = "/apis/example.io/v1/namespaces/{namespace}/foos"
+
 var _ = Describe("Swagger analysis", func() {
 
 	Context("With swagger petstore", func() {
@@ -27,7 +31,7 @@ var _ = Describe("Swagger analysis", func() {
 			document, err := loads.JSONSpec(petStoreSwaggerPath)
 			Expect(err).NotTo(HaveOccurred())
 
-			coverage, err := AnalyzeSwagger(document, filter)
+			coverage, err := AnalyzeSwagger(document, filter, false)
 			Expect(err).NotTo(HaveOccurred(), "coverage structure should be initialized")
 
 			Expect(coverage.Percent).To(Equal(expectedCoverage.Percent), "percent should be equal to 0")
@@ -68,4 +72,104 @@ var _ = Describe("Swagger analysis", func() {
 		)
 
 	})
+
+	Context("With kubernetes swagger", func() {
+
+		It("Should read k8s actions", func() {
+			document, err := loads.JSONSpec(kubernetesSwaggerPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			coverage, err := AnalyzeSwagger(document, "", false)
+			Expect(err).NotTo(HaveOccurred(), "coverage structure should be initialized")
+
+			collection := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]
+			Expect(collection["get"].Action).To(Equal("list"))
+			Expect(collection["post"].Action).To(Equal("post"))
+			Expect(collection["delete"].Action).To(Equal("deletecollection"))
+			Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}"]["delete"].Action).To(Equal("delete"))
+			Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}/console"]["get"].Action).To(Equal("connect"))
+		})
+
+		DescribeTable("Should read k8s resource and kind", func(path string, method string, expected stats.Endpoint) {
+			document, err := loads.JSONSpec(kubernetesSwaggerPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			coverage, err := AnalyzeSwagger(document, "", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := coverage.Endpoints[path][method]
+			Expect(endpoint.Group).To(Equal(expected.Group))
+			Expect(endpoint.Version).To(Equal(expected.Version))
+			Expect(endpoint.Resource).To(Equal(expected.Resource))
+			Expect(endpoint.Subresource).To(Equal(expected.Subresource))
+			Expect(endpoint.Kind).To(Equal(expected.Kind))
+		},
+			Entry("With namespaced collection", "/apis/example.io/v1/namespaces/{namespace}/foos", "post",
+				stats.Endpoint{Group: "example.io", Version: "v1", Resource: "foos", Kind: "example.io/v1/Foo"}),
+			Entry("With cluster scoped collection", "/apis/example.io/v1/foos", "get",
+				stats.Endpoint{Group: "example.io", Version: "v1", Resource: "foos", Kind: "example.io/v1/Foo"}),
+			Entry("With subresource", "/apis/example.io/v1/namespaces/{namespace}/foos/{name}/status", "put",
+				stats.Endpoint{Group: "example.io", Version: "v1", Resource: "foos", Subresource: "status", Kind: "example.io/v1/Foo"}),
+			Entry("With watch path", "/apis/example.io/v1/watch/namespaces/{namespace}/foos", "get",
+				stats.Endpoint{Group: "example.io", Version: "v1", Resource: "foos", Kind: "example.io/v1/Foo"}),
+			Entry("With discovery path", "/apis/example.io/v1/", "get", stats.Endpoint{}),
+		)
+
+		DescribeTable("Should read field metadata", func(path string, method string, query bool, field string, expected stats.Node) {
+			document, err := loads.JSONSpec(kubernetesSwaggerPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			coverage, err := AnalyzeSwagger(document, "", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := coverage.Endpoints[path][method]
+			node := endpoint.Body.Root
+			if query {
+				node = endpoint.Query.Root
+			}
+			for _, k := range strings.Split(field, ".") {
+				Expect(node.Children).To(HaveKey(k))
+				node = node.Children[k]
+			}
+			Expect(node.Required).To(Equal(expected.Required), "required")
+			Expect(node.Deprecated).To(Equal(expected.Deprecated), "deprecated")
+			Expect(node.ReadOnly).To(Equal(expected.ReadOnly), "read-only")
+			Expect(node.Type).To(Equal(expected.Type), "type")
+		},
+			Entry("With required reference", fooCollectionPath, "post", false, "spec", stats.Node{Required: true, Type: "object"}),
+			Entry("With required nested field", fooCollectionPath, "post", false, "spec.image", stats.Node{Required: true, Type: "string"}),
+			Entry("With optional field", fooCollectionPath, "post", false, "spec.replicas", stats.Node{Type: "integer"}),
+			Entry("With array", fooCollectionPath, "post", false, "spec.ports", stats.Node{Type: "array"}),
+			Entry("With required array item field", fooCollectionPath, "post", false, "spec.ports.port", stats.Node{Required: true, Type: "integer"}),
+			Entry("With deprecated field", fooCollectionPath, "post", false, "spec.legacyMode", stats.Node{Deprecated: true, Type: "boolean"}),
+			Entry("With read-only field", fooCollectionPath, "post", false, "metadata.uid", stats.Node{ReadOnly: true, Type: "string"}),
+			Entry("With status", fooCollectionPath, "post", false, "status", stats.Node{ReadOnly: true, Type: "object"}),
+			Entry("With status of status subresource", fooCollectionPath+"/{name}/status", "put", false, "status", stats.Node{Type: "object"}),
+			Entry("With query param", fooCollectionPath, "post", true, "dryRun", stats.Node{Type: "string"}),
+		)
+
+		DescribeTable("Should read deprecation and read-only hints of descriptions", func(schema spec.Schema, expected stats.Node) {
+			node := &stats.Node{}
+			describeField(node, &schema, nil)
+			Expect(node.Deprecated).To(Equal(expected.Deprecated), "deprecated")
+			Expect(node.ReadOnly).To(Equal(expected.ReadOnly), "read-only")
+		},
+			Entry("With read-only sentence", describedSchema("UID is unique. Populated by the system. Read-only."), stats.Node{ReadOnly: true}),
+			Entry("With leading read-only sentence", describedSchema("Read-only. Set by the server."), stats.Node{ReadOnly: true}),
+			Entry("With read-only schema", spec.Schema{SwaggerSchemaProps: spec.SwaggerSchemaProps{ReadOnly: true}}, stats.Node{ReadOnly: true}),
+			Entry("With read-only mode", describedSchema("Mounted read-only if true, read-write otherwise (false or unspecified). Defaults to false."), stats.Node{}),
+			Entry("With read-only in a sentence", describedSchema("ReadOnly here will force the ReadOnly setting in VolumeMounts. Read-only volumes are supported."), stats.Node{}),
+			Entry("With deprecation notice", describedSchema("Deprecated: use serviceAccountName instead."), stats.Node{Deprecated: true}),
+			Entry("With deprecation notice after a sentence", describedSchema("Alias of serviceAccountName. Deprecated: use serviceAccountName instead."), stats.Node{Deprecated: true}),
+			Entry("With deprecated extension", spec.Schema{VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{"x-kubernetes-deprecated": true}}},
+				stats.Node{Deprecated: true}),
+			Entry("With deprecated mentioned", describedSchema("Whether deprecated API versions are served, deprecated versions are removed in v2."), stats.Node{}),
+			Entry("With lower case deprecation", describedSchema("Number of replicas. deprecated: is not a notice."), stats.Node{}),
+		)
+	})
 })
+
+// describedSchema returns a schema of a string field with the description
+func describedSchema(description string) spec.Schema {
+	return *spec.StringProperty().WithDescription(description)
+}
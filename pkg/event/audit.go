@@ -0,0 +1,345 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/internal/jsonscan"
+)
+
+// AuditPredicate checks request attributes of a k8s audit event, they are decoded before other fields,
+// so events which can not be counted are skipped without decoding bodies or requester attributes
+type AuditPredicate func(verb string, requestURI string, objectRef *auditv1.ObjectReference) bool
+
+// auditLine represents fields of a k8s audit event which are used by events
+type auditLine struct {
+	AuditID                  string
+	Verb                     string
+	RequestURI               string
+	ObjectRef                *auditv1.ObjectReference
+	User                     auditUser
+	ImpersonatedUser         *auditUser
+	SourceIPs                []string
+	UserAgent                string
+	ResponseCode             int
+	RequestObject            []byte
+	Annotations              map[string]string
+	RequestReceivedTimestamp time.Time
+	StageTimestamp           time.Time
+
+	// data is the decoded line, decoded values do not refer to it, so the line can be reused by a reader
+	data []byte
+}
+
+type auditUser struct {
+	Username string
+	Groups   []string
+}
+
+// DecodeAuditLine decodes a single k8s audit event in JSON format, the result is the same as decoding
+// auditv1.Event and converting it by FromAudit, but only fields used by events are decoded,
+// other fields like responseObject are skipped by a tokenizer, unlike encoding/json keys are matched case sensitively
+func DecodeAuditLine(b []byte) (*Event, error) {
+	return decodeAuditLine(b, nil)
+}
+
+// AuditLineDecoder returns a decoder of k8s audit events which skips events not accepted by the predicate,
+// verb, requestURI and objectRef are decoded first, other fields are decoded only for accepted events,
+// a nil event is returned for skipped events
+func AuditLineDecoder(accept AuditPredicate) LineDecoder {
+	return func(b []byte) (*Event, error) {
+		return decodeAuditLine(b, accept)
+	}
+}
+
+func decodeAuditLine(b []byte, accept AuditPredicate) (*Event, error) {
+	line := auditLine{data: b}
+	if ok, err := line.decode(accept); !ok || err != nil {
+		return nil, err
+	}
+	e := &Event{
+		Verb:         line.Verb,
+		AuditID:      line.AuditID,
+		RequestURI:   line.RequestURI,
+		ObjectRef:    line.ObjectRef,
+		User:         line.User.Username,
+		Groups:       line.User.Groups,
+		UserAgent:    line.UserAgent,
+		SourceIPs:    line.SourceIPs,
+		RequestBody:  line.RequestObject,
+		ResponseCode: line.ResponseCode,
+		Annotations:  line.Annotations,
+
+		RequestReceivedTimestamp: line.RequestReceivedTimestamp,
+		StageTimestamp:           line.StageTimestamp,
+	}
+	if line.ImpersonatedUser != nil {
+		e.ImpersonatedUser = line.ImpersonatedUser.Username
+		e.ImpersonatedGroups = line.ImpersonatedUser.Groups
+	}
+	return e, nil
+}
+
+// decode walks fields of the audit event, values of used fields are decoded one by one, if accept is set
+// request attributes are decoded and checked first, it returns false if the event is not accepted
+func (l *auditLine) decode(accept AuditPredicate) (bool, error) {
+	// the tokenizer expects a valid JSON, validation does not allocate
+	if !json.Valid(l.data) {
+		var auditEvent auditv1.Event
+		return false, json.Unmarshal(l.data, &auditEvent)
+	}
+	if accept != nil {
+		f := jsonscan.FieldsOf(l.data)
+		for f.Next() {
+			if isRequestField(f.Key) {
+				if err := l.decodeField(f.Key, f.Val); err != nil {
+					return false, err
+				}
+			}
+		}
+		if f.Err != nil {
+			return false, f.Err
+		}
+		if !accept(l.Verb, l.RequestURI, l.ObjectRef) {
+			return false, nil
+		}
+	}
+	f := jsonscan.FieldsOf(l.data)
+	for f.Next() {
+		if accept != nil && isRequestField(f.Key) {
+			continue
+		}
+		if err := l.decodeField(f.Key, f.Val); err != nil {
+			return false, err
+		}
+	}
+	return f.Err == nil, f.Err
+}
+
+// isRequestField checks if the field is a request attribute checked by a predicate
+func isRequestField(key []byte) bool {
+	switch string(key) {
+	case "verb", "requestURI", "objectRef":
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *auditLine) decodeField(key []byte, val []byte) error {
+	switch string(key) {
+	case "auditID":
+		return l.decodeString(val, &l.AuditID)
+	case "verb":
+		return l.decodeString(val, &l.Verb)
+	case "requestURI":
+		return l.decodeString(val, &l.RequestURI)
+	case "userAgent":
+		return l.decodeString(val, &l.UserAgent)
+	case "sourceIPs":
+		return l.decodeStrings(val, &l.SourceIPs)
+	case "objectRef":
+		l.ObjectRef = nil
+		if !isNull(val) {
+			l.ObjectRef = &auditv1.ObjectReference{}
+			return l.decodeObjectRef(val, l.ObjectRef)
+		}
+	case "user":
+		return l.decodeUser(val, &l.User)
+	case "impersonatedUser":
+		l.ImpersonatedUser = nil
+		if !isNull(val) {
+			l.ImpersonatedUser = &auditUser{}
+			return l.decodeUser(val, l.ImpersonatedUser)
+		}
+	case "responseStatus":
+		l.ResponseCode = 0
+		return l.decodeResponseStatus(val)
+	case "requestObject":
+		l.RequestObject = nil
+		if !isNull(val) {
+			l.RequestObject = append([]byte(nil), val...)
+		}
+	case "annotations":
+		return l.decodeAnnotations(val, &l.Annotations)
+	case "requestReceivedTimestamp":
+		return l.decodeTime(val, &l.RequestReceivedTimestamp)
+	case "stageTimestamp":
+		return l.decodeTime(val, &l.StageTimestamp)
+	}
+	return nil
+}
+
+func (l *auditLine) decodeResponseStatus(b []byte) error {
+	f := jsonscan.FieldsOf(b)
+	for f.Next() {
+		if string(f.Key) != "code" || isNull(f.Val) {
+			continue
+		}
+		code, err := strconv.ParseInt(string(f.Val), 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid response code '%s'", f.Val)
+		}
+		l.ResponseCode = int(code)
+	}
+	return f.Err
+}
+
+func (l *auditLine) decodeUser(b []byte, u *auditUser) error {
+	f := jsonscan.FieldsOf(b)
+	for f.Next() {
+		var err error
+		switch string(f.Key) {
+		case "username":
+			err = l.decodeString(f.Val, &u.Username)
+		case "groups":
+			err = l.decodeStrings(f.Val, &u.Groups)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.Err
+}
+
+func (l *auditLine) decodeObjectRef(b []byte, ref *auditv1.ObjectReference) error {
+	f := jsonscan.FieldsOf(b)
+	for f.Next() {
+		var err error
+		switch string(f.Key) {
+		case "resource":
+			err = l.decodeString(f.Val, &ref.Resource)
+		case "namespace":
+			err = l.decodeString(f.Val, &ref.Namespace)
+		case "name":
+			err = l.decodeString(f.Val, &ref.Name)
+		case "uid":
+			err = l.decodeString(f.Val, (*string)(&ref.UID))
+		case "apiGroup":
+			err = l.decodeString(f.Val, &ref.APIGroup)
+		case "apiVersion":
+			err = l.decodeString(f.Val, &ref.APIVersion)
+		case "resourceVersion":
+			err = l.decodeString(f.Val, &ref.ResourceVersion)
+		case "subresource":
+			err = l.decodeString(f.Val, &ref.Subresource)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.Err
+}
+
+func isNull(b []byte) bool {
+	return string(b) == "null"
+}
+
+// knownValues holds frequent values of audit events, decoded strings equal to them share the value instead of allocating
+var knownValues = map[string]string{}
+
+func init() {
+	for _, v := range []string{
+		"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection", "proxy",
+		"system:authenticated", "system:unauthenticated", "system:masters", "system:nodes", "system:serviceaccounts",
+		"authorization.k8s.io/decision", "authorization.k8s.io/reason", "allow", "forbid",
+	} {
+		knownValues[v] = v
+	}
+}
+
+// decodeString decodes a JSON string, strings without escape sequences are not decoded by encoding/json,
+// decoded strings do not refer to b
+func (l *auditLine) decodeString(b []byte, str *string) error {
+	if len(b) >= 2 && b[0] == '"' && bytes.IndexByte(b, '\\') < 0 {
+		// lookup by a converted byte slice does not allocate
+		if v, ok := knownValues[string(b[1:len(b)-1])]; ok {
+			*str = v
+		} else {
+			*str = string(b[1 : len(b)-1])
+		}
+		return nil
+	}
+	return json.Unmarshal(b, str)
+}
+
+// decodeStrings decodes a JSON array of strings
+func (l *auditLine) decodeStrings(b []byte, strs *[]string) error {
+	if isNull(b) {
+		*strs = nil
+		return nil
+	}
+	if b[0] != '[' {
+		return json.Unmarshal(b, strs)
+	}
+	// elements are counted first, so the slice is allocated once
+	n := 0
+	s := &jsonscan.Scanner{Data: b, Pos: 1}
+	for s.Space(); s.Peek() != ']'; s.Space() {
+		s.Value()
+		s.Space()
+		if s.Peek() == ',' {
+			s.Pos++
+		}
+		n++
+	}
+	*strs = make([]string, n)
+	s.Pos = 1
+	for i := range *strs {
+		s.Space()
+		if err := l.decodeString(s.Value(), &(*strs)[i]); err != nil {
+			return err
+		}
+		s.Space()
+		if s.Peek() == ',' {
+			s.Pos++
+		}
+	}
+	return nil
+}
+
+// decodeAnnotations decodes a JSON object with string values
+func (l *auditLine) decodeAnnotations(b []byte, annotations *map[string]string) error {
+	*annotations = nil
+	f := jsonscan.FieldsOf(b)
+	if isNull(b) || f.Err != nil {
+		return f.Err
+	}
+	*annotations = make(map[string]string)
+	for f.Next() {
+		var value string
+		if err := l.decodeString(f.Val, &value); err != nil {
+			return err
+		}
+		key, ok := knownValues[string(f.Key)]
+		if !ok {
+			key = string(f.Key)
+		}
+		(*annotations)[key] = value
+	}
+	return f.Err
+}
+
+// decodeTime decodes a timestamp the same way as metav1.MicroTime
+func (l *auditLine) decodeTime(b []byte, t *time.Time) error {
+	if isNull(b) {
+		*t = time.Time{}
+		return nil
+	}
+	var str string
+	if err := l.decodeString(b, &str); err != nil {
+		return err
+	}
+	pt, err := time.Parse(metav1.RFC3339Micro, str)
+	if err != nil {
+		return err
+	}
+	*t = pt.Local()
+	return nil
+}
@@ -0,0 +1,113 @@
+package event
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// Event represents a single REST API request which is used to calculate coverage,
+// it can be built from an audit log entry or recorded directly from HTTP traffic
+type Event struct {
+	// Verb is a k8s verb, it is set only for events coming from audit logs
+	Verb string
+	// Method is a real HTTP method, empty if unknown
+	Method       string
+	RequestURI   string
+	ObjectRef    *auditv1.ObjectReference
+	RequestBody  []byte
+	ContentType  string
+	ResponseCode int
+	// Test is a name of the test which sent the request, empty if unknown
+	Test string
+	// AuditID identifies the request in audit logs, events of all stages of a request have the same ID,
+	// empty if unknown
+	AuditID string
+	// Annotations are audit annotations of the request, for instance request headers recorded by an audit webhook
+	Annotations map[string]string
+
+	// requester attributes, they are available only if the source provides them
+	User               string
+	Groups             []string
+	ImpersonatedUser   string
+	ImpersonatedGroups []string
+	UserAgent          string
+	SourceIPs          []string
+
+	// RequestReceivedTimestamp is a time when the request reached the API server, zero if unknown
+	RequestReceivedTimestamp time.Time
+	// StageTimestamp is a time when the response was sent, zero if unknown
+	StageTimestamp time.Time
+}
+
+// Name returns name of the requested object, it is taken from object reference if available,
+// otherwise from metadata of the request body which is required for create requests
+func (e *Event) Name() string {
+	if e.ObjectRef != nil && e.ObjectRef.Name != "" {
+		return e.ObjectRef.Name
+	}
+	if len(e.RequestBody) == 0 {
+		return ""
+	}
+	var obj struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(e.RequestBody, &obj); err != nil {
+		return ""
+	}
+	return obj.Metadata.Name
+}
+
+// Namespace returns namespace of the requested object, it is taken from object reference if available,
+// otherwise from the request path
+func (e *Event) Namespace() string {
+	if e.ObjectRef != nil {
+		return e.ObjectRef.Namespace
+	}
+	path := e.RequestURI
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(s); i++ {
+		// the same as k8s, namespace object /api/v1/namespaces/{name} has its name as namespace
+		if s[i] == "namespaces" && (s[0] == "api" || s[0] == "apis") {
+			return s[i+1]
+		}
+	}
+	return ""
+}
+
+// FromAudit translates k8s audit event into Event
+func FromAudit(auditEvent *auditv1.Event) *Event {
+	e := &Event{
+		Verb:       auditEvent.Verb,
+		AuditID:    string(auditEvent.AuditID),
+		RequestURI: auditEvent.RequestURI,
+		ObjectRef:  auditEvent.ObjectRef,
+		User:       auditEvent.User.Username,
+		Groups:     auditEvent.User.Groups,
+		UserAgent:  auditEvent.UserAgent,
+		SourceIPs:  auditEvent.SourceIPs,
+
+		Annotations: auditEvent.Annotations,
+
+		RequestReceivedTimestamp: auditEvent.RequestReceivedTimestamp.Time,
+		StageTimestamp:           auditEvent.StageTimestamp.Time,
+	}
+	if auditEvent.ImpersonatedUser != nil {
+		e.ImpersonatedUser = auditEvent.ImpersonatedUser.Username
+		e.ImpersonatedGroups = auditEvent.ImpersonatedUser.Groups
+	}
+	if auditEvent.RequestObject != nil {
+		e.RequestBody = auditEvent.RequestObject.Raw
+	}
+	if auditEvent.ResponseStatus != nil {
+		e.ResponseCode = int(auditEvent.ResponseStatus.Code)
+	}
+	return e
+}
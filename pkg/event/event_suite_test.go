@@ -0,0 +1,23 @@
+package event
+
+import (
+	"path"
+	"runtime"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var fixturesPath string
+
+func TestEvent(t *testing.T) {
+	_, p, _, ok := runtime.Caller(0)
+	if !ok {
+		panic("Not possible to get test file path")
+	}
+	fixturesPath = path.Join(path.Dir(p), "../../fixtures")
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Event Suite")
+}
@@ -0,0 +1,297 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// supported input formats
+const (
+	FormatAuditLog = "audit-log"
+	FormatHAR      = "har"
+	FormatHTTPLog  = "http-log"
+)
+
+// Reader provides events one by one, Next returns io.EOF if there are no more events
+type Reader interface {
+	Next() (*Event, error)
+}
+
+// OpenFile opens a file in the given format, returned closer has to be closed by a caller
+func OpenFile(path string, format string) (Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reader Reader
+	switch format {
+	case FormatAuditLog:
+		reader = NewAuditReader(f)
+	case FormatHTTPLog:
+		reader = NewHTTPLogReader(f)
+	case FormatHAR:
+		reader, err = NewHARReader(f)
+	default:
+		err = fmt.Errorf("Invalid input format '%s'", format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return reader, f, nil
+}
+
+// LineDecoder decodes a single line of a line based format into an event, a nil event without an error
+// means that the line is skipped by the decoder
+type LineDecoder func(line []byte) (*Event, error)
+
+// LineReader provides raw lines of line based formats, Next returns io.EOF if there are no more lines
+type LineReader interface {
+	Next() ([]byte, error)
+}
+
+// OpenLines opens a file in a line based format, lines can be decoded concurrently by the returned decoder,
+// returned closer has to be closed by a caller
+func OpenLines(path string, format string) (LineReader, LineDecoder, io.Closer, error) {
+	decode := Decoder(format)
+	if decode == nil {
+		return nil, nil, nil, fmt.Errorf("Input format '%s' is not line based", format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return NewLineReader(f), decode, f, nil
+}
+
+// Decoder returns a line decoder of the format, nil if the format is not line based
+func Decoder(format string) LineDecoder {
+	switch format {
+	case FormatAuditLog:
+		return DecodeAuditLine
+	case FormatHTTPLog:
+		return DecodeHTTPLogLine
+	default:
+		return nil
+	}
+}
+
+// NewLineReader initializes a reader of non empty lines, returned lines are not reused by the reader
+func NewLineReader(r io.Reader) LineReader {
+	return &lineReader{bufio.NewReader(r)}
+}
+
+// lineReader reads non empty lines, the last line does not have to end with a new line character
+type lineReader struct {
+	reader *bufio.Reader
+}
+
+func (r *lineReader) Next() ([]byte, error) {
+	return r.next()
+}
+
+func (r *lineReader) next() ([]byte, error) {
+	for {
+		b, err := r.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line := bytes.TrimSpace(b); len(line) > 0 {
+			return line, nil
+		}
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+	}
+}
+
+// auditReader reads k8s audit log in JSON lines format
+type auditReader struct {
+	lineReader
+}
+
+// NewAuditReader initializes a reader for k8s audit log, each line is a single audit event
+func NewAuditReader(r io.Reader) Reader {
+	return &auditReader{lineReader{bufio.NewReader(r)}}
+}
+
+func (r *auditReader) Next() (*Event, error) {
+	b, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAuditLine(b)
+}
+
+// httpLogEntry represents a single line of a generic HTTP request log
+type httpLogEntry struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	ContentType string          `json:"contentType,omitempty"`
+	Status      int             `json:"status,omitempty"`
+	User        string          `json:"user,omitempty"`
+	UserAgent   string          `json:"userAgent,omitempty"`
+	SourceIP    string          `json:"sourceIP,omitempty"`
+	Timestamp   time.Time       `json:"timestamp,omitempty"`
+}
+
+// httpLogReader reads a generic HTTP request log in JSON lines format
+type httpLogReader struct {
+	lineReader
+}
+
+// NewHTTPLogReader initializes a reader for a generic HTTP request log, each line is a JSON object, as an example,
+// {"method": "POST", "url": "/api/pets", "body": {"name": "bite"}, "contentType": "application/json", "status": 200}
+// only method and url are required, body can be a JSON value or a string with a raw request body,
+// optional user, userAgent, sourceIP and timestamp (RFC 3339) fields can be used by filters
+func NewHTTPLogReader(r io.Reader) Reader {
+	return &httpLogReader{lineReader{bufio.NewReader(r)}}
+}
+
+func (r *httpLogReader) Next() (*Event, error) {
+	b, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeHTTPLogLine(b)
+}
+
+// DecodeHTTPLogLine decodes a single entry of a generic HTTP request log, see NewHTTPLogReader for the format
+func DecodeHTTPLogLine(b []byte) (*Event, error) {
+	var entry httpLogEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Method == "" || entry.URL == "" {
+		return nil, fmt.Errorf("Invalid HTTP log entry '%s', method and url are required", b)
+	}
+
+	uri, err := requestURI(entry.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte(entry.Body)
+	if len(body) > 0 && body[0] == '"' {
+		var raw string
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+		body = []byte(raw)
+	} else if string(body) == "null" {
+		body = nil
+	}
+
+	e := &Event{
+		Method:       entry.Method,
+		RequestURI:   uri,
+		RequestBody:  body,
+		ContentType:  entry.ContentType,
+		ResponseCode: entry.Status,
+		User:         entry.User,
+		UserAgent:    entry.UserAgent,
+
+		RequestReceivedTimestamp: entry.Timestamp,
+		StageTimestamp:           entry.Timestamp,
+	}
+	if entry.SourceIP != "" {
+		e.SourceIPs = []string{entry.SourceIP}
+	}
+	return e, nil
+}
+
+// har represents a subset of HTTP Archive format which is required to calculate coverage
+type har struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			// Time is a total elapsed time of the request in milliseconds
+			Time    float64 `json:"time"`
+			Request struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// harReader provides events from HAR entries
+type harReader struct {
+	events []*Event
+}
+
+// NewHARReader initializes a reader for HTTP Archive (HAR) file, the whole file is decoded at once
+func NewHARReader(r io.Reader) (Reader, error) {
+	var archive har
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, err
+	}
+
+	reader := &harReader{}
+	for _, entry := range archive.Log.Entries {
+		uri, err := requestURI(entry.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+		e := &Event{
+			Method:       entry.Request.Method,
+			RequestURI:   uri,
+			ResponseCode: entry.Response.Status,
+
+			RequestReceivedTimestamp: entry.StartedDateTime,
+			StageTimestamp:           entry.StartedDateTime.Add(time.Duration(entry.Time * float64(time.Millisecond))),
+		}
+		if postData := entry.Request.PostData; postData != nil {
+			e.RequestBody = []byte(postData.Text)
+			e.ContentType = postData.MimeType
+		}
+		for _, h := range entry.Request.Headers {
+			switch {
+			case strings.EqualFold(h.Name, "Content-Type") && e.ContentType == "":
+				e.ContentType = h.Value
+			case strings.EqualFold(h.Name, "User-Agent"):
+				e.UserAgent = h.Value
+			}
+		}
+		reader.events = append(reader.events, e)
+	}
+	return reader, nil
+}
+
+func (r *harReader) Next() (*Event, error) {
+	if len(r.events) == 0 {
+		return nil, io.EOF
+	}
+	e := r.events[0]
+	r.events = r.events[1:]
+	return e, nil
+}
+
+// requestURI returns path and query of absolute or relative URL
+func requestURI(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return u.RequestURI(), nil
+}
@@ -0,0 +1,256 @@
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func readAll(reader Reader) []*Event {
+	var events []*Event
+	for {
+		e, err := reader.Next()
+		if err == io.EOF {
+			return events
+		}
+		Expect(err).NotTo(HaveOccurred())
+		events = append(events, e)
+	}
+}
+
+var _ = Describe("Event readers", func() {
+
+	table.DescribeTable("Should read all events from a file", func(file string, format string, method string, uri string) {
+		reader, closer, err := OpenFile(path.Join(fixturesPath, file), format)
+		Expect(err).NotTo(HaveOccurred())
+		defer closer.Close()
+
+		events := readAll(reader)
+		Expect(events).To(HaveLen(7))
+		Expect(events[0].RequestURI).To(Equal(uri))
+		Expect(events[0].Method).To(Equal(method))
+		Expect(events[1].RequestBody).NotTo(BeEmpty())
+	},
+		table.Entry("With audit log", "test_audit.log", FormatAuditLog, "", "/pets?limit=100"),
+		table.Entry("With HAR file", "test_petstore.har", FormatHAR, "GET", "/api/pets?limit=100"),
+		table.Entry("With HTTP log", "test_http.log", FormatHTTPLog, "GET", "/api/pets?limit=100"),
+	)
+
+	table.DescribeTable("Should decode lines of line based formats", func(file string, format string) {
+		reader, closer, err := OpenFile(path.Join(fixturesPath, file), format)
+		Expect(err).NotTo(HaveOccurred())
+		defer closer.Close()
+		expected := readAll(reader)
+
+		lines, decode, linesCloser, err := OpenLines(path.Join(fixturesPath, file), format)
+		Expect(err).NotTo(HaveOccurred())
+		defer linesCloser.Close()
+		var events []*Event
+		for line, err := lines.Next(); err != io.EOF; line, err = lines.Next() {
+			Expect(err).NotTo(HaveOccurred())
+			e, err := decode(line)
+			Expect(err).NotTo(HaveOccurred())
+			events = append(events, e)
+		}
+		Expect(events).To(Equal(expected))
+	},
+		table.Entry("With audit log", "test_audit.log", FormatAuditLog),
+		table.Entry("With HTTP log", "test_http.log", FormatHTTPLog),
+	)
+
+	table.DescribeTable("Should decode audit lines the same way as full audit events", func(line string) {
+		var auditEvent auditv1.Event
+		Expect(json.Unmarshal([]byte(line), &auditEvent)).To(Succeed())
+		e, err := DecodeAuditLine([]byte(line))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(e).To(Equal(FromAudit(&auditEvent)))
+	},
+		table.Entry("With a request object", `{"kind":"Event","auditID":"6b1f4e44-2a1e-4c4b-9d1c-4a6e3e0c7f00","verb":"create","requestURI":"/api/v1/namespaces/default/pods",`+
+			`"objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"user":{"username":"admin","groups":["system:masters"]},`+
+			`"sourceIPs":["10.0.0.1"],"userAgent":"e2e.test/v1.14 -- [sig-apps] Deployment","requestObject":{"metadata":{"name":"a"}},`+
+			`"responseObject":{"kind":"Pod"},"responseStatus":{"metadata":{},"code":201},"annotations":{"a":"b"},`+
+			`"requestReceivedTimestamp":"2019-06-03T12:00:20.000000Z","stageTimestamp":"2019-06-03T12:00:20.005123+02:00"}`),
+		table.Entry("With null and missing fields", `{"verb":"get","requestURI":"/version","requestObject":null,`+
+			`"objectRef":null,"responseStatus":null,"requestReceivedTimestamp":null}`),
+		table.Entry("With impersonated user and escaped strings", `{"verb":"list","requestURI":"/api/v1/pods?labelSelector=a%3Db",`+
+			`"user":{"username":"a\"b"},"impersonatedUser":{"username":"bob","groups":["x"]},`+
+			`"stageTimestamp":"2019-06-03T12:00:20.000000\u005a"}`),
+		table.Entry("With annotations", `{"verb":"get","requestURI":"/version","annotations":{"e2e.io/test":"[sig-apps] \"quoted\"",`+
+			`"authorization.k8s.io/decision":"allow"}}`),
+		table.Entry("With null annotations", `{"verb":"get","requestURI":"/version","annotations":null}`),
+	)
+
+	It("Should decode audit fixtures the same way as full audit events", func() {
+		for _, file := range []string{"test_audit.log", "test_kubernetes_audit.log"} {
+			lines, decode, closer, err := OpenLines(path.Join(fixturesPath, file), FormatAuditLog)
+			Expect(err).NotTo(HaveOccurred())
+			for line, err := lines.Next(); err != io.EOF; line, err = lines.Next() {
+				Expect(err).NotTo(HaveOccurred())
+				var auditEvent auditv1.Event
+				Expect(json.Unmarshal(line, &auditEvent)).To(Succeed())
+				e, err := decode(line)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(e).To(Equal(FromAudit(&auditEvent)))
+			}
+			closer.Close()
+		}
+	})
+
+	It("Should decode audit lines with less memory than full audit events", func() {
+		full := testing.Benchmark(BenchmarkDecodeAuditEvent)
+		line := testing.Benchmark(BenchmarkDecodeAuditLine)
+		Expect(line.AllocedBytesPerOp()).To(BeNumerically("<", full.AllocedBytesPerOp()))
+	})
+
+	It("Should not keep references to decoded audit lines", func() {
+		line := []byte(`{"auditID":"id-1","verb":"get","requestURI":"/version","userAgent":"e2e.test",` +
+			`"annotations":{"e2e.io/test":"TestA"},"requestObject":{"a":"b"}}`)
+		e := mustDecodeAuditLine(line)
+		for i := range line {
+			line[i] = ' '
+		}
+		Expect(e.AuditID).To(Equal("id-1"))
+		Expect(e.RequestURI).To(Equal("/version"))
+		Expect(e.UserAgent).To(Equal("e2e.test"))
+		Expect(e.Annotations).To(Equal(map[string]string{"e2e.io/test": "TestA"}))
+		Expect(string(e.RequestBody)).To(Equal(`{"a":"b"}`))
+	})
+
+	It("Should skip audit lines not accepted by the predicate", func() {
+		line := []byte(`{"verb":"create","requestURI":"/api/v1/namespaces/default/pods","objectRef":{"resource":"pods","namespace":"default"},` +
+			`"user":{"username":"admin"},"requestObject":{"metadata":{"name":"a"}}}`)
+		var checked []string
+		decode := AuditLineDecoder(func(verb string, requestURI string, objectRef *auditv1.ObjectReference) bool {
+			checked = append(checked, verb, requestURI, objectRef.Resource)
+			return objectRef.Resource == "services"
+		})
+		e, err := decode(line)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(e).To(BeNil())
+		Expect(checked).To(Equal([]string{"create", "/api/v1/namespaces/default/pods", "pods"}))
+
+		decode = AuditLineDecoder(func(verb string, requestURI string, objectRef *auditv1.ObjectReference) bool {
+			return true
+		})
+		e, err = decode(line)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(e).To(Equal(mustDecodeAuditLine(line)))
+
+		_, err = decode([]byte(`{"verb":1}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	table.DescribeTable("Should fail for invalid audit lines", func(line string) {
+		_, err := DecodeAuditLine([]byte(line))
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With invalid timestamp", `{"verb":"get","requestURI":"/version","stageTimestamp":"yesterday"}`),
+		table.Entry("With invalid JSON", `{"verb":"get","requestURI":"/version"`),
+		table.Entry("With invalid type", `{"verb":1,"requestURI":"/version"}`),
+		table.Entry("With invalid response code", `{"verb":"get","responseStatus":{"code":"200"}}`),
+		table.Entry("With an array", `[{"verb":"get"}]`),
+		table.Entry("With invalid annotation", `{"verb":"get","annotations":{"a":1}}`),
+	)
+
+	It("Should not open HAR file as lines", func() {
+		Expect(Decoder(FormatHAR)).To(BeNil())
+		_, _, _, err := OpenLines(path.Join(fixturesPath, "test_petstore.har"), FormatHAR)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should fail for unknown format", func() {
+		_, _, err := OpenFile(path.Join(fixturesPath, "test_audit.log"), "unknown")
+		Expect(err).To(HaveOccurred())
+	})
+
+	table.DescribeTable("Should read namespace", func(e *Event, namespace string) {
+		Expect(e.Namespace()).To(Equal(namespace))
+	},
+		table.Entry("With object reference", &Event{
+			RequestURI: "/apis/kubevirt.io/v1alpha3/namespaces/default/virtualmachineinstances",
+			ObjectRef:  &auditv1.ObjectReference{Namespace: "kubevirt"},
+		}, "kubevirt"),
+		table.Entry("With namespaced path", &Event{RequestURI: "/apis/kubevirt.io/v1alpha3/namespaces/default/virtualmachineinstances?limit=1"}, "default"),
+		table.Entry("With namespace path", &Event{RequestURI: "/api/v1/namespaces/default"}, "default"),
+		table.Entry("With cluster scoped path", &Event{RequestURI: "/apis/kubevirt.io/v1alpha3/virtualmachineinstances"}, ""),
+		table.Entry("With non k8s path", &Event{RequestURI: "/pets/namespaces/bite"}, ""),
+	)
+
+	Context("With HTTP log", func() {
+
+		It("Should read a raw string body and the last line without new line character", func() {
+			reader := NewHTTPLogReader(strings.NewReader(
+				`{"method":"PUT","url":"http://localhost/api/pets/bite?dryRun=All","body":"{\"name\":\"bite\"}"}` + "\n\n" +
+					`{"method":"DELETE","url":"/api/pets/bite","body":null}`,
+			))
+
+			events := readAll(reader)
+			Expect(events).To(HaveLen(2))
+			Expect(events[0].Method).To(Equal("PUT"))
+			Expect(events[0].RequestURI).To(Equal("/api/pets/bite?dryRun=All"))
+			Expect(string(events[0].RequestBody)).To(Equal(`{"name":"bite"}`))
+			Expect(events[1].RequestBody).To(BeNil())
+		})
+
+		It("Should fail without method", func() {
+			reader := NewHTTPLogReader(strings.NewReader(`{"url":"/api/pets"}`))
+			_, err := reader.Next()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+func benchmarkAuditDecode(b *testing.B, decode LineDecoder) {
+	data, err := ioutil.ReadFile("../../fixtures/test_kubernetes_audit.log")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var lines [][]byte
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		lines = append(lines, []byte(line))
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			if _, err := decode(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeAuditEvent(b *testing.B) {
+	benchmarkAuditDecode(b, func(line []byte) (*Event, error) {
+		var auditEvent auditv1.Event
+		if err := json.Unmarshal(line, &auditEvent); err != nil {
+			return nil, err
+		}
+		return FromAudit(&auditEvent), nil
+	})
+}
+
+func BenchmarkDecodeAuditLine(b *testing.B) {
+	benchmarkAuditDecode(b, DecodeAuditLine)
+}
+
+func BenchmarkDecodeAuditLineSkipped(b *testing.B) {
+	benchmarkAuditDecode(b, AuditLineDecoder(func(verb string, requestURI string, objectRef *auditv1.ObjectReference) bool {
+		return false
+	}))
+}
+
+func mustDecodeAuditLine(line []byte) *Event {
+	e, err := DecodeAuditLine(line)
+	Expect(err).NotTo(HaveOccurred())
+	return e
+}
@@ -0,0 +1,247 @@
+package exclusion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// supported field locations
+const (
+	LocationBody  = "body"
+	LocationQuery = "query"
+)
+
+// Rule excludes endpoints or fields which are intentionally not tested, as an example,
+// {"path": "/apis/kubevirt.io/*/namespaces/{namespace}/virtualmachineinstances", "method": "delete", "reason": "..."}
+// excludes deletecollection of VMIs and {"path": "/apis/kubevirt.io/**", "field": "spec.domain.legacy*", "reason": "..."}
+// excludes deprecated body fields of all kubevirt.io endpoints
+type Rule struct {
+	// Path is a glob of swagger path, '*' matches a single path segment and '**' matches any number of segments
+	Path string `json:"path"`
+	// Method is a HTTP method, empty or '*' matches all methods
+	Method string `json:"method,omitempty"`
+	// Field is a glob of dotted field path, '*' matches a single field and '**' matches any number of fields,
+	// empty excludes the whole endpoint
+	Field string `json:"field,omitempty"`
+	// Location is a location of the field, body (default) or query
+	Location string `json:"location,omitempty"`
+	// Reason explains why the endpoint or the field is not tested, it is required
+	Reason string `json:"reason"`
+
+	path  *regexp.Regexp
+	field *regexp.Regexp
+}
+
+// List represents exclusions file
+type List struct {
+	Exclusions []*Rule `json:"exclusions"`
+}
+
+// Load reads exclusions file in JSON format
+func Load(path string) (*List, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid exclusions file '%s': %s", path, err)
+	}
+	return list, nil
+}
+
+// Parse parses and validates exclusions
+func Parse(data []byte) (*List, error) {
+	list := &List{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	for i, r := range list.Exclusions {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("exclusion %d: %s", i, err)
+		}
+	}
+	return list, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if strings.TrimSpace(r.Reason) == "" {
+		return fmt.Errorf("reason is required for '%s'", r.Path)
+	}
+	r.Method = strings.ToLower(r.Method)
+	switch r.Location {
+	case "":
+		r.Location = LocationBody
+	case LocationBody, LocationQuery:
+	default:
+		return fmt.Errorf("invalid location '%s' for '%s', expected body or query", r.Location, r.Path)
+	}
+	if r.Field == "" && r.Location == LocationQuery {
+		return fmt.Errorf("field is required for query location of '%s'", r.Path)
+	}
+
+	var err error
+	if r.path, err = filter.CompileGlob(r.Path, '/'); err != nil {
+		return err
+	}
+	if r.Field != "" {
+		if r.field, err = filter.CompileGlob(r.Field, '.'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchEndpoint checks if rule matches endpoint path and method
+func (r *Rule) matchEndpoint(e *stats.Endpoint) bool {
+	return (r.Method == "" || r.Method == "*" || r.Method == e.Method) && r.path.MatchString(e.Path)
+}
+
+// fields returns all fields of the trie matching the rule, children of matching fields are not returned
+func (r *Rule) fields(e *stats.Endpoint) []*stats.Node {
+	trie := e.Body
+	if r.Location == LocationQuery {
+		trie = e.Query
+	}
+	var nodes []*stats.Node
+	var walk func(node *stats.Node, path string)
+	walk = func(node *stats.Node, path string) {
+		for key, child := range node.Children {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			if r.field.MatchString(p) {
+				nodes = append(nodes, child)
+			} else {
+				walk(child, p)
+			}
+		}
+	}
+	walk(trie.Root, "")
+	return nodes
+}
+
+// Apply excludes endpoints and fields from the expected unique hits, it has to be called before requests are matched,
+// rules which do not match any endpoint or field are returned, nil List does not exclude anything
+func (l *List) Apply(coverage *stats.Coverage) []*Rule {
+	if l == nil {
+		return nil
+	}
+	var unmatched []*Rule
+	for _, r := range l.Exclusions {
+		matched := false
+		for _, methods := range coverage.Endpoints {
+			for _, e := range methods {
+				if !r.matchEndpoint(e) {
+					continue
+				}
+				if r.field == nil {
+					e.Excluded = true
+					matched = true
+					continue
+				}
+				trie := e.Body
+				if r.Location == LocationQuery {
+					trie = e.Query
+				}
+				for _, node := range r.fields(e) {
+					e.ExpectedUniqueHits -= trie.Exclude(node)
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, r)
+		}
+	}
+	return unmatched
+}
+
+// Summarize provides excluded endpoints and fields with number of hits, it is called after requests are matched,
+// exclusions are returned in the order they are defined
+func (l *List) Summarize(coverage *stats.Coverage) []*stats.Exclusion {
+	if l == nil {
+		return nil
+	}
+	var summary []*stats.Exclusion
+	for _, r := range l.Exclusions {
+		s := &stats.Exclusion{
+			Path:     r.Path,
+			Method:   r.Method,
+			Field:    r.Field,
+			Location: r.Location,
+			Reason:   r.Reason,
+		}
+		if r.field == nil {
+			s.Location = ""
+		}
+		for _, methods := range coverage.Endpoints {
+			for _, e := range methods {
+				if !r.matchEndpoint(e) {
+					continue
+				}
+				if r.field == nil {
+					s.Endpoints++
+					if e.MethodCalled {
+						s.Hits++
+					}
+					continue
+				}
+				for _, node := range r.fields(e) {
+					s.Fields++
+					s.Hits += node.Hits
+				}
+			}
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+func (r *Rule) String() string {
+	s := r.Path
+	if r.Method != "" {
+		s = strings.ToUpper(r.Method) + " " + s
+	}
+	if r.Field != "" {
+		s += " " + r.Location + ":" + r.Field
+	}
+	return s
+}
+
+// ExcludeReadOnly excludes read-only fields from the expected unique hits, for instance status of k8s objects
+// or metadata.uid, it has to be called before requests are matched, it returns a number of excluded leaf fields
+func ExcludeReadOnly(coverage *stats.Coverage) int {
+	excluded := 0
+	for _, methods := range coverage.Endpoints {
+		for _, e := range methods {
+			for _, trie := range []*stats.Trie{e.Body, e.Query} {
+				var walk func(node *stats.Node)
+				walk = func(node *stats.Node) {
+					for _, child := range node.Children {
+						if !child.ReadOnly {
+							walk(child)
+							continue
+						}
+						n := trie.Exclude(child)
+						e.ExpectedUniqueHits -= n
+						excluded += n
+					}
+				}
+				walk(trie.Root)
+			}
+		}
+	}
+	coverage.ReadOnlyExcluded = true
+	return excluded
+}
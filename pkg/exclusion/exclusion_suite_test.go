@@ -0,0 +1,26 @@
+package exclusion
+
+import (
+	"path"
+	"runtime"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var kubernetesSwaggerPath string
+var exclusionsPath string
+
+func TestExclusion(t *testing.T) {
+	_, p, _, ok := runtime.Caller(0)
+	if !ok {
+		panic("Not possible to get test file path")
+	}
+	fixturesPath := path.Join(path.Dir(p), "../../fixtures")
+	kubernetesSwaggerPath = path.Join(fixturesPath, "test_kubernetes.json")
+	exclusionsPath = path.Join(fixturesPath, "test_kubernetes_exclusions.json")
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Exclusion Suite")
+}
@@ -0,0 +1,141 @@
+package exclusion
+
+import (
+	"github.com/go-openapi/loads"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/analysis"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Exclusions", func() {
+
+	table.DescribeTable("Should not parse invalid exclusions", func(data string) {
+		_, err := Parse([]byte(data))
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With invalid JSON", `{"exclusions": [`),
+		table.Entry("Without path", `{"exclusions": [{"method": "get", "reason": "not tested"}]}`),
+		table.Entry("Without reason", `{"exclusions": [{"path": "/apis/**"}]}`),
+		table.Entry("With blank reason", `{"exclusions": [{"path": "/apis/**", "reason": " "}]}`),
+		table.Entry("With invalid location", `{"exclusions": [{"path": "/apis/**", "field": "spec", "location": "header", "reason": "not tested"}]}`),
+		table.Entry("With query location without field", `{"exclusions": [{"path": "/apis/**", "location": "query", "reason": "not tested"}]}`),
+	)
+
+	Context("With kubernetes swagger", func() {
+
+		var (
+			coverage *stats.Coverage
+			list     *List
+		)
+
+		BeforeEach(func() {
+			document, err := loads.JSONSpec(kubernetesSwaggerPath)
+			Expect(err).NotTo(HaveOccurred())
+			coverage, err = analysis.AnalyzeSwagger(document, "", false)
+			Expect(err).NotTo(HaveOccurred())
+			list, err = Load(exclusionsPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should exclude endpoints", func() {
+			Expect(list.Apply(coverage)).To(BeEmpty())
+			Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["delete"].Excluded).To(BeTrue())
+			Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"].Excluded).To(BeFalse())
+			Expect(coverage.Endpoints["/apis/example.io/v1/watch/foos"]["get"].Excluded).To(BeTrue())
+		})
+
+		table.DescribeTable("Should exclude fields from expected unique hits", func(path string, method string, query bool, fields ...string) {
+			endpoint := coverage.Endpoints[path][method]
+			expected, body, params := endpoint.ExpectedUniqueHits, endpoint.Body.ExpectedUniqueHits, endpoint.Query.ExpectedUniqueHits
+
+			list.Apply(coverage)
+			trie := endpoint.Body
+			if query {
+				trie = endpoint.Query
+				Expect(endpoint.Body.ExpectedUniqueHits).To(Equal(body))
+				Expect(endpoint.Query.ExpectedUniqueHits).To(Equal(params - 1))
+			} else {
+				Expect(endpoint.Body.ExpectedUniqueHits).To(Equal(body - 1))
+				Expect(endpoint.Query.ExpectedUniqueHits).To(Equal(params))
+			}
+			Expect(endpoint.ExpectedUniqueHits).To(Equal(expected - 1))
+
+			node := trie.Root
+			for _, f := range fields {
+				node = node.GetChild(f)
+				Expect(node).NotTo(BeNil())
+			}
+			Expect(node.Excluded).To(BeTrue())
+		},
+			table.Entry("With body field", "/apis/example.io/v1/namespaces/{namespace}/foos", "post", false, "spec", "legacyMode"),
+			table.Entry("With body field of subresource", "/apis/example.io/v1/namespaces/{namespace}/foos/{name}/status", "put", false, "spec", "legacyMode"),
+			table.Entry("With query param", "/apis/example.io/v1/namespaces/{namespace}/foos", "get", true, "timeoutSeconds"),
+		)
+
+		It("Should not count hits of excluded fields", func() {
+			list.Apply(coverage)
+			endpoint := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"]
+			legacyMode := endpoint.Body.Root.GetChild("spec").GetChild("legacyMode")
+			endpoint.Body.IncreaseHits(legacyMode)
+			Expect(legacyMode.Hits).To(Equal(1))
+			Expect(endpoint.Body.UniqueHits).To(Equal(0))
+		})
+
+		It("Should summarize exclusions with hits", func() {
+			list.Apply(coverage)
+			coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["delete"].MethodCalled = true
+			endpoint := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}"]["put"]
+			endpoint.Body.IncreaseHits(endpoint.Body.Root.GetChild("spec").GetChild("legacyMode"))
+
+			summary := list.Summarize(coverage)
+			Expect(summary).To(HaveLen(4))
+			Expect(summary[0]).To(Equal(&stats.Exclusion{
+				Path:      "/apis/example.io/*/namespaces/{namespace}/foos",
+				Method:    "delete",
+				Reason:    "deletecollection is served by the generic registry",
+				Endpoints: 1,
+				Hits:      1,
+			}))
+			Expect(summary[1].Fields).To(Equal(3))
+			Expect(summary[1].Location).To(Equal(LocationBody))
+			Expect(summary[1].Hits).To(Equal(1))
+			Expect(summary[2].Endpoints).To(Equal(1))
+			Expect(summary[2].Hits).To(Equal(0))
+			Expect(summary[3].Fields).To(Equal(1))
+		})
+
+		It("Should return rules which do not match anything", func() {
+			unmatched, err := Parse([]byte(`{"exclusions": [{"path": "/apis/other.io/**", "reason": "not served"}]}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unmatched.Apply(coverage)).To(Equal(unmatched.Exclusions))
+		})
+
+		It("Should exclude read-only fields", func() {
+			create := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"]
+			status := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}/status"]["put"]
+			expected, statusExpected := create.ExpectedUniqueHits, status.ExpectedUniqueHits
+
+			Expect(ExcludeReadOnly(coverage)).To(BeNumerically(">", 4))
+			Expect(coverage.ReadOnlyExcluded).To(BeTrue())
+			// metadata.uid, metadata.resourceVersion, status.phase and status.readyReplicas
+			Expect(create.ExpectedUniqueHits).To(Equal(expected - 4))
+			Expect(create.Body.Root.Children["status"].Excluded).To(BeTrue())
+			Expect(create.Body.Root.Children["metadata"].Children["uid"].Excluded).To(BeTrue())
+			Expect(create.Body.Root.Children["metadata"].Children["name"].Excluded).To(BeFalse())
+			// status is updated by the status subresource
+			Expect(status.ExpectedUniqueHits).To(Equal(statusExpected - 2))
+			Expect(status.Body.Root.Children["status"].Excluded).To(BeFalse())
+		})
+
+		It("Should not exclude anything with nil list", func() {
+			expected := coverage.ExpectedUniqueHits
+			var nilList *List
+			nilList.Apply(coverage)
+			Expect(coverage.ExpectedUniqueHits).To(Equal(expected))
+			Expect(nilList.Summarize(coverage)).To(BeNil())
+		})
+	})
+})
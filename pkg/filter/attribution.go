@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+// supported attribution types
+const (
+	AttributionUserAgent  = "user-agent"
+	AttributionAnnotation = "annotation"
+	AttributionMarker     = "marker"
+)
+
+// DefaultUserAgentSeparator separates a test name from the user agent, k8s e2e framework appends test names
+// to the user agent this way, for instance e2e.test/v1.18.0 (linux/amd64) kubernetes/abc -- [sig-apps] Deployment test
+const DefaultUserAgentSeparator = " -- "
+
+// Attribution attributes events to tests which sent them, a test set by the event source, for instance by
+// instrumented clients, takes precedence. Marker attribution has to see all events in the order they were logged.
+type Attribution struct {
+	// Type is one of user-agent, annotation or marker
+	Type string
+	// Value is a separator of the user agent suffix, a key of the audit annotation
+	// or a marker resource in format [namespace/]resource
+	Value string
+
+	// marker attribution matches creation and deletion of objects of the resource
+	namespace string
+	resource  string
+	// test is a name of the currently running test started by a marker
+	test string
+}
+
+// NewAttribution parses attribution in format type[:value], the value is one of:
+// user-agent[:separator] takes a test name from the user agent suffix after the separator, DefaultUserAgentSeparator by default,
+// annotation:key takes a test name from the audit annotation, for instance a request header recorded by an audit webhook,
+// marker:[namespace/]resource attributes events to the test named by the last created object of the resource
+// until the object is deleted, for instance, marker:e2e/configmaps. Empty value returns nil which keeps tests set by the event source.
+func NewAttribution(value string) (*Attribution, error) {
+	if value == "" {
+		return nil, nil
+	}
+	a := &Attribution{Type: value}
+	if i := strings.Index(value, ":"); i >= 0 {
+		a.Type, a.Value = value[:i], value[i+1:]
+	}
+	switch a.Type {
+	case AttributionUserAgent:
+		if a.Value == "" {
+			a.Value = DefaultUserAgentSeparator
+		}
+	case AttributionAnnotation:
+		if a.Value == "" {
+			return nil, fmt.Errorf("Invalid attribution '%s', annotation key is required", value)
+		}
+	case AttributionMarker:
+		s := strings.Split(a.Value, "/")
+		switch len(s) {
+		case 1:
+			a.resource = s[0]
+		case 2:
+			a.namespace, a.resource = s[0], s[1]
+		}
+		if a.resource == "" {
+			return nil, fmt.Errorf("Invalid attribution '%s', expected marker:[namespace/]resource", value)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid attribution '%s', expected user-agent[:separator], annotation:key or marker:[namespace/]resource", value)
+	}
+	return a, nil
+}
+
+// Clone returns an attribution of the same type which has not seen any event yet, it returns nil for nil attribution
+func (a *Attribution) Clone() *Attribution {
+	if a == nil {
+		return nil
+	}
+	return &Attribution{Type: a.Type, Value: a.Value, namespace: a.namespace, resource: a.resource}
+}
+
+// Test returns a name of the test which sent the event, empty if unknown, events have to be passed in order,
+// nil attribution returns the test set by the event source. Marker events are not attributed to any test.
+func (a *Attribution) Test(e *event.Event) string {
+	if a == nil || e.Test != "" {
+		return e.Test
+	}
+	switch a.Type {
+	case AttributionUserAgent:
+		if i := strings.Index(e.UserAgent, a.Value); i >= 0 {
+			return strings.TrimSpace(e.UserAgent[i+len(a.Value):])
+		}
+	case AttributionAnnotation:
+		return e.Annotations[a.Value]
+	case AttributionMarker:
+		return a.marker(e)
+	}
+	return ""
+}
+
+// marker switches the current test on creation and deletion of marker objects,
+// events without object reference are matched by the request path
+func (a *Attribution) marker(e *event.Event) string {
+	o, ok := objectOf(e)
+	if !ok || o.resource != a.resource || o.subresource != "" || (a.namespace != "" && o.namespace != a.namespace) {
+		return a.test
+	}
+	verb := e.Verb
+	if verb == "" {
+		verb = ActionVerb(strings.ToLower(e.Method))
+	}
+	switch verb {
+	case "create":
+		a.test = o.name
+	case "delete":
+		if o.name == a.test {
+			a.test = ""
+		}
+	default:
+		return a.test
+	}
+	return ""
+}
+
+// State returns a name of the currently running test started by a marker, it is used to resume attribution from a checkpoint
+func (a *Attribution) State() string {
+	return a.test
+}
+
+// Restore sets the currently running test
+func (a *Attribution) Restore(test string) {
+	a.test = test
+}
+
+func (a *Attribution) String() string {
+	return a.Type + ":" + a.Value
+}
@@ -0,0 +1,99 @@
+package filter
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+var _ = Describe("Test attribution", func() {
+
+	newMarker := func(verb string, namespace string, name string) *event.Event {
+		return &event.Event{
+			Verb:       verb,
+			RequestURI: "/api/v1/namespaces/" + namespace + "/configmaps/" + name,
+			ObjectRef:  &auditv1.ObjectReference{Resource: "configmaps", Namespace: namespace, Name: name},
+		}
+	}
+	request := &event.Event{Verb: "get", RequestURI: "/api/v1/namespaces/default/pods/test"}
+
+	table.DescribeTable("Should parse attribution", func(value string, expected *Attribution) {
+		a, err := NewAttribution(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(a).To(Equal(expected))
+	},
+		table.Entry("With empty value", "", nil),
+		table.Entry("With default user agent separator", "user-agent", &Attribution{Type: "user-agent", Value: " -- "}),
+		table.Entry("With user agent separator", "user-agent:#", &Attribution{Type: "user-agent", Value: "#"}),
+		table.Entry("With annotation", "annotation:e2e.io/test", &Attribution{Type: "annotation", Value: "e2e.io/test"}),
+		table.Entry("With marker", "marker:configmaps", &Attribution{Type: "marker", Value: "configmaps", resource: "configmaps"}),
+		table.Entry("With namespaced marker", "marker:e2e/configmaps",
+			&Attribution{Type: "marker", Value: "e2e/configmaps", namespace: "e2e", resource: "configmaps"}),
+	)
+
+	table.DescribeTable("Should not parse invalid attribution", func(value string) {
+		_, err := NewAttribution(value)
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With unknown type", "header:X-Test"),
+		table.Entry("With annotation without key", "annotation"),
+		table.Entry("With marker without resource", "marker:"),
+		table.Entry("With marker with name", "marker:e2e/configmaps/test"),
+	)
+
+	table.DescribeTable("Should attribute events to tests", func(value string, e *event.Event, expected string) {
+		a, err := NewAttribution(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(a.Test(e)).To(Equal(expected))
+	},
+		table.Entry("With user agent suffix", "user-agent",
+			&event.Event{UserAgent: "e2e.test/v1.18.0 (linux/amd64) kubernetes/abc -- [sig-apps] Deployment test"}, "[sig-apps] Deployment test"),
+		table.Entry("With user agent without suffix", "user-agent", &event.Event{UserAgent: "kubectl/v1.18.0"}, ""),
+		table.Entry("With annotation", "annotation:e2e.io/test",
+			&event.Event{Annotations: map[string]string{"e2e.io/test": "TestCreate"}}, "TestCreate"),
+		table.Entry("With missing annotation", "annotation:e2e.io/test", &event.Event{}, ""),
+		table.Entry("With test set by the source", "annotation:e2e.io/test",
+			&event.Event{Test: "TestSource", Annotations: map[string]string{"e2e.io/test": "TestCreate"}}, "TestSource"),
+		table.Entry("With nil attribution", "", &event.Event{Test: "TestSource"}, "TestSource"),
+	)
+
+	It("Should attribute events between marker events", func() {
+		a, err := NewAttribution("marker:e2e/configmaps")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(a.Test(request)).To(BeEmpty())
+		Expect(a.Test(newMarker("create", "e2e", "test-a"))).To(BeEmpty())
+		Expect(a.Test(request)).To(Equal("test-a"))
+		// markers of other namespaces and other verbs do not change the test
+		Expect(a.Test(newMarker("create", "default", "test-b"))).To(Equal("test-a"))
+		Expect(a.Test(newMarker("get", "e2e", "test-a"))).To(Equal("test-a"))
+		Expect(a.Test(newMarker("delete", "e2e", "test-b"))).To(BeEmpty())
+		Expect(a.Test(request)).To(Equal("test-a"))
+
+		Expect(a.Clone().Test(request)).To(BeEmpty())
+		restored := a.Clone()
+		restored.Restore(a.State())
+		Expect(restored.Test(request)).To(Equal("test-a"))
+
+		Expect(a.Test(newMarker("create", "e2e", "test-c"))).To(BeEmpty())
+		Expect(a.Test(request)).To(Equal("test-c"))
+		Expect(a.Test(newMarker("delete", "e2e", "test-c"))).To(BeEmpty())
+		Expect(a.Test(request)).To(BeEmpty())
+	})
+
+	It("Should attribute events between marker events without object reference", func() {
+		a, err := NewAttribution("marker:e2e/configmaps")
+		Expect(err).NotTo(HaveOccurred())
+		proxied := &event.Event{Method: "GET", RequestURI: "/api/v1/namespaces/default/pods/test"}
+		Expect(a.Test(&event.Event{
+			Method: "POST", RequestURI: "/api/v1/namespaces/e2e/configmaps", RequestBody: []byte(`{"metadata":{"name":"test-a"}}`),
+		})).To(BeEmpty())
+		Expect(a.Test(proxied)).To(Equal("test-a"))
+		Expect(a.Test(&event.Event{Method: "PUT", RequestURI: "/api/v1/namespaces/e2e/configmaps/test-a/status"})).To(Equal("test-a"))
+		Expect(a.Test(&event.Event{Method: "DELETE", RequestURI: "/api/v1/namespaces/default/configmaps/test-a"})).To(Equal("test-a"))
+		Expect(a.Test(&event.Event{Method: "DELETE", RequestURI: "/api/v1/namespaces/e2e/configmaps/test-a?gracePeriodSeconds=0"})).To(BeEmpty())
+		Expect(a.Test(proxied)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,377 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+// supported rule types
+const (
+	RulePrefix   = "prefix"
+	RuleGlob     = "glob"
+	RuleRegex    = "regex"
+	RuleGroup    = "group"
+	RuleVersion  = "version"
+	RuleResource = "resource"
+	RuleVerb     = "verb"
+
+	// event rules are applied only to requests, swagger endpoints do not have these attributes
+	RuleUser              = "user"
+	RuleUserGroup         = "user-group"
+	RuleImpersonatedUser  = "impersonated-user"
+	RuleImpersonatedGroup = "impersonated-group"
+	RuleUserAgent         = "user-agent"
+	RuleSourceIP          = "source-ip"
+	RuleNamespace         = "namespace"
+)
+
+// Request represents attributes of a request or a swagger endpoint which are checked by filter rules
+type Request struct {
+	// Path is a request path, for instance /apis/kubevirt.io/v1alpha3/namespaces/default/virtualmachineinstances
+	Path string
+	// SwaggerPath is a generic swagger path, for instance /apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances
+	SwaggerPath string
+	// Method is a HTTP method
+	Method string
+	// Verb is a k8s verb, for instance list or create
+	Verb string
+	// Event provides requester attributes, it is nil for swagger endpoints
+	Event *event.Event
+}
+
+// Rule represents a single filter rule
+type Rule struct {
+	Type  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// ParseRule parses rule in format type:value, as an example,
+// prefix:/apis/kubevirt.io/, glob:/apis/*/v1/**, regex:^/apis/.*/status$,
+// group:kubevirt.io, version:v1alpha3, resource:virtualmachineinstances/status or verb:deletecollection,
+// value without type is treated as a prefix. Event rules match requester attributes:
+// user, user-group, impersonated-user, impersonated-group, user-agent, source-ip and namespace,
+// their values are globs where '*' matches any characters or regular expressions enclosed in slashes, as an example,
+// user:system:serviceaccount:e2e:* or user-agent:/^e2e\.test/
+func ParseRule(rule string) (Rule, error) {
+	t, value := RulePrefix, rule
+	if i := strings.Index(rule, ":"); i > 0 && !strings.HasPrefix(rule, "/") {
+		t, value = rule[:i], rule[i+1:]
+	}
+
+	r := Rule{Type: t, Value: value}
+	var err error
+	switch t {
+	case RuleRegex:
+		r.re, err = regexp.Compile(value)
+	case RuleGlob:
+		r.re, err = CompileGlob(value, '/')
+	case RulePrefix, RuleGroup, RuleVersion, RuleResource:
+	case RuleUser, RuleUserGroup, RuleImpersonatedUser, RuleImpersonatedGroup, RuleUserAgent, RuleSourceIP, RuleNamespace:
+		r.re, err = compileValue(value)
+	case RuleVerb:
+		r.Value = strings.ToLower(value)
+	default:
+		err = fmt.Errorf("Invalid filter rule type '%s' in '%s'", t, rule)
+	}
+	if err != nil {
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+// CompileGlob translates glob into regular expression, '*' matches any characters except separator,
+// '**' matches any characters and '?' matches a single character except separator
+func CompileGlob(glob string, separator byte) (*regexp.Regexp, error) {
+	notSeparator := "[^" + regexp.QuoteMeta(string(separator)) + "]"
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString(notSeparator + "*")
+			}
+		case '?':
+			expr.WriteString(notSeparator)
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// compileValue translates event rule value into regular expression, value enclosed in slashes is a regular expression,
+// otherwise it is a glob where '*' matches any characters and '?' matches a single character
+func compileValue(value string) (*regexp.Regexp, error) {
+	if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		return regexp.Compile(value[1 : len(value)-1])
+	}
+	expr := regexp.QuoteMeta(value)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+// category groups rules, request has to match at least one include rule of each category
+func (r Rule) category() string {
+	switch r.Type {
+	case RulePrefix, RuleGlob, RuleRegex:
+		return "path"
+	default:
+		return r.Type
+	}
+}
+
+// applicable checks if rule can be evaluated for the request, event rules are not evaluated for swagger endpoints
+func (r Rule) applicable(req Request) bool {
+	switch r.Type {
+	case RuleUser, RuleUserGroup, RuleImpersonatedUser, RuleImpersonatedGroup, RuleUserAgent, RuleSourceIP, RuleNamespace:
+		return req.Event != nil
+	default:
+		return true
+	}
+}
+
+// Match checks if rule matches a request, path rules match if either request path or swagger path matches
+func (r Rule) Match(req Request) bool {
+	if e := req.Event; e != nil {
+		switch r.Type {
+		case RuleUser:
+			return r.re.MatchString(e.User)
+		case RuleUserGroup:
+			return matchAny(r.re, e.Groups)
+		case RuleImpersonatedUser:
+			return e.ImpersonatedUser != "" && r.re.MatchString(e.ImpersonatedUser)
+		case RuleImpersonatedGroup:
+			return matchAny(r.re, e.ImpersonatedGroups)
+		case RuleUserAgent:
+			return r.re.MatchString(e.UserAgent)
+		case RuleSourceIP:
+			return matchAny(r.re, e.SourceIPs)
+		case RuleNamespace:
+			return r.re.MatchString(e.Namespace())
+		}
+	}
+
+	switch r.Type {
+	case RulePrefix:
+		return matchPaths(req, func(p string) bool { return strings.HasPrefix(p, r.Value) })
+	case RuleGlob, RuleRegex:
+		return matchPaths(req, r.re.MatchString)
+	case RuleVerb:
+		return r.Value == req.Verb || r.Value == strings.ToLower(req.Method)
+	}
+
+	path := req.SwaggerPath
+	if path == "" {
+		path = req.Path
+	}
+	res, ok := ParseResourcePath(path)
+	if !ok {
+		return false
+	}
+	switch r.Type {
+	case RuleGroup:
+		return r.Value == res.Group || (r.Value == "core" && res.Group == "")
+	case RuleVersion:
+		return r.Value == res.Version || res.Version == "*"
+	case RuleResource:
+		if res.Subresource != "" {
+			return r.Value == res.Resource || r.Value == res.Resource+"/"+res.Subresource
+		}
+		return r.Value == res.Resource
+	}
+	return false
+}
+
+func (r Rule) String() string {
+	return r.Type + ":" + r.Value
+}
+
+func matchAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPaths(req Request, match func(string) bool) bool {
+	return (req.Path != "" && match(req.Path)) || (req.SwaggerPath != "" && match(req.SwaggerPath))
+}
+
+// Filter limits requests and swagger endpoints, request is accepted if it matches at least one include rule
+// of each rule category and it does not match any exclude rule, nil Filter accepts everything.
+// Path rules (prefix, glob and regex) are a single category, as an example,
+// include rules group:kubevirt.io, group:cdi.kubevirt.io and user:system:serviceaccount:e2e:*
+// accept requests for both API groups sent by e2e service accounts
+type Filter struct {
+	Include []Rule
+	Exclude []Rule
+}
+
+// New parses include and exclude rules and initializes Filter
+func New(include []string, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, i := range include {
+		r, err := ParseRule(i)
+		if err != nil {
+			return nil, err
+		}
+		f.Include = append(f.Include, r)
+	}
+	for _, e := range exclude {
+		r, err := ParseRule(e)
+		if err != nil {
+			return nil, err
+		}
+		f.Exclude = append(f.Exclude, r)
+	}
+	return f, nil
+}
+
+// Prefix initializes Filter with a single prefix include rule, empty prefix does not limit anything
+func Prefix(prefix string) *Filter {
+	if prefix == "" {
+		return &Filter{}
+	}
+	return &Filter{Include: []Rule{{Type: RulePrefix, Value: prefix}}}
+}
+
+// Empty checks if filter does not have any rules
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.Include) == 0 && len(f.Exclude) == 0)
+}
+
+// Match checks if request is accepted by filter
+func (f *Filter) Match(req Request) bool {
+	if f == nil {
+		return true
+	}
+	for _, r := range f.Exclude {
+		if r.applicable(req) && r.Match(req) {
+			return false
+		}
+	}
+
+	matched := make(map[string]bool)
+	for _, r := range f.Include {
+		if !r.applicable(req) {
+			continue
+		}
+		c := r.category()
+		matched[c] = matched[c] || r.Match(req)
+	}
+	for _, m := range matched {
+		if !m {
+			return false
+		}
+	}
+	return true
+}
+
+// ActionVerb translates x-kubernetes-action into k8s verb used by audit log
+func ActionVerb(action string) string {
+	switch action {
+	case "post":
+		return "create"
+	case "put":
+		return "update"
+	case "watchlist":
+		return "watch"
+	default:
+		return action
+	}
+}
+
+// ResourcePath represents k8s resource attributes encoded in path
+type ResourcePath struct {
+	Group       string
+	Version     string
+	Namespaced  bool
+	Resource    string
+	Subresource string
+}
+
+// ParseResourcePath reads k8s group, version and resource from request or swagger path, as an example,
+// /apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances/{name}/status provides
+// group kubevirt.io, version v1alpha3, resource virtualmachineinstances and subresource status,
+// it returns false if path does not point to k8s resource
+func ParseResourcePath(path string) (ResourcePath, bool) {
+	res, _, ok := splitResourcePath(path)
+	return res, ok
+}
+
+// splitResourcePath parses the resource path, it returns segments of the path from the resource
+func splitResourcePath(path string) (ResourcePath, []string, bool) {
+	var res ResourcePath
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case s[0] == "api" && len(s) > 2:
+		res.Version, s = s[1], s[2:]
+	case s[0] == "apis" && len(s) > 3:
+		res.Group, res.Version, s = s[1], s[2], s[3:]
+	default:
+		return res, nil, false
+	}
+
+	if s[0] == "watch" && len(s) > 1 {
+		s = s[1:]
+	}
+	// namespace subresources are not namespaced resources, for instance /api/v1/namespaces/{name}/status
+	if s[0] == "namespaces" && len(s) > 2 && s[2] != "status" && s[2] != "finalize" {
+		res.Namespaced = true
+		s = s[2:]
+	}
+	res.Resource = s[0]
+	if len(s) > 2 {
+		res.Subresource = s[2]
+	}
+	return res, s, true
+}
+
+// requestedObject represents k8s object of a request
+type requestedObject struct {
+	resource    string
+	subresource string
+	name        string
+	namespace   string
+}
+
+// objectOf returns the requested object, it is taken from the object reference of audit events, other events
+// like proxy, HAR or instrumented client requests provide it by the request path, a name of a created object
+// is taken from the request body, it returns false if the request does not point to k8s resource
+func objectOf(e *event.Event) (requestedObject, bool) {
+	if e.ObjectRef != nil {
+		return requestedObject{
+			resource:    e.ObjectRef.Resource,
+			subresource: e.ObjectRef.Subresource,
+			name:        e.Name(),
+			namespace:   e.ObjectRef.Namespace,
+		}, true
+	}
+	path := e.RequestURI
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	res, s, ok := splitResourcePath(path)
+	if !ok {
+		return requestedObject{}, false
+	}
+	o := requestedObject{resource: res.Resource, subresource: res.Subresource, namespace: e.Namespace()}
+	if len(s) > 1 {
+		o.name = s[1]
+	} else {
+		o.name = e.Name()
+	}
+	return o, true
+}
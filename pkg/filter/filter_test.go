@@ -0,0 +1,164 @@
+package filter
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+var _ = Describe("Request filter", func() {
+
+	vmiRequest := Request{
+		Path:        "/apis/kubevirt.io/v1alpha3/namespaces/default/virtualmachineinstances/testvmi",
+		SwaggerPath: "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances/{name}",
+		Method:      "get",
+		Verb:        "get",
+	}
+	statusEndpoint := Request{
+		SwaggerPath: "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances/{name}/status",
+		Method:      "put",
+		Verb:        "update",
+	}
+	podsRequest := Request{
+		Path:   "/api/v1/namespaces/kube-system/pods",
+		Method: "delete",
+		Verb:   "deletecollection",
+	}
+
+	table.DescribeTable("Should match a single rule", func(rule string, req Request, match bool) {
+		r, err := ParseRule(rule)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Match(req)).To(Equal(match))
+	},
+		table.Entry("With prefix", "prefix:/apis/kubevirt.io/", vmiRequest, true),
+		table.Entry("With prefix without type", "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}", vmiRequest, true),
+		table.Entry("With not matching prefix", "prefix:/api/v1", vmiRequest, false),
+		table.Entry("With glob", "glob:/apis/*/v1alpha3/**", vmiRequest, true),
+		table.Entry("With glob matching a single segment", "glob:/apis/*/v1alpha3/*", vmiRequest, false),
+		table.Entry("With glob matching request namespace", "glob:/api/v1/namespaces/kube-system/**", podsRequest, true),
+		table.Entry("With regex", "regex:/status$", statusEndpoint, true),
+		table.Entry("With not matching regex", "regex:/status$", vmiRequest, false),
+		table.Entry("With group", "group:kubevirt.io", vmiRequest, true),
+		table.Entry("With core group", "group:core", podsRequest, true),
+		table.Entry("With version", "version:v1alpha3", vmiRequest, true),
+		table.Entry("With not matching version", "version:v1", vmiRequest, false),
+		table.Entry("With resource", "resource:virtualmachineinstances", vmiRequest, true),
+		table.Entry("With resource matching subresource", "resource:virtualmachineinstances", statusEndpoint, true),
+		table.Entry("With subresource", "resource:virtualmachineinstances/status", statusEndpoint, true),
+		table.Entry("With subresource not matching resource", "resource:virtualmachineinstances/status", vmiRequest, false),
+		table.Entry("With verb", "verb:deletecollection", podsRequest, true),
+		table.Entry("With HTTP method as verb", "verb:DELETE", podsRequest, true),
+		table.Entry("With not matching verb", "verb:update", vmiRequest, false),
+	)
+
+	table.DescribeTable("Should reject invalid rules", func(rule string) {
+		_, err := ParseRule(rule)
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With unknown type", "kind:VirtualMachine"),
+		table.Entry("With invalid regex", "regex:(("),
+	)
+
+	It("Should accept everything without rules", func() {
+		var f *Filter
+		Expect(f.Match(vmiRequest)).To(BeTrue())
+		Expect(f.Empty()).To(BeTrue())
+		Expect(Prefix("").Match(podsRequest)).To(BeTrue())
+	})
+
+	It("Should combine include and exclude rules", func() {
+		f, err := New(
+			[]string{"group:kubevirt.io", "group:core"},
+			[]string{"resource:virtualmachineinstances/status", "glob:/api/v1/namespaces/kube-system/**"},
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Match(vmiRequest)).To(BeTrue())
+		Expect(f.Match(statusEndpoint)).To(BeFalse())
+		Expect(f.Match(podsRequest)).To(BeFalse())
+		Expect(f.Match(Request{Path: "/apis/cdi.kubevirt.io/v1alpha1/datavolumes"})).To(BeFalse())
+	})
+
+	It("Should require a match of each rule category", func() {
+		f, err := New([]string{"group:kubevirt.io", "group:core", "verb:create", "prefix:/apis/"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		create := Request{
+			Path:        "/apis/kubevirt.io/v1alpha3/namespaces/default/virtualmachineinstances",
+			SwaggerPath: "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances",
+			Method:      "post",
+			Verb:        "create",
+		}
+		Expect(f.Match(create)).To(BeTrue(), "group, verb and path rules should match")
+		Expect(f.Match(vmiRequest)).To(BeFalse(), "verb rule does not match")
+		Expect(f.Match(podsRequest)).To(BeFalse(), "core group matches, but path and verb rules do not")
+	})
+
+	Context("With event rules", func() {
+
+		e2eRequest := Request{
+			Path:        "/apis/kubevirt.io/v1alpha3/namespaces/kubevirt-test-default/virtualmachineinstances",
+			SwaggerPath: "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances",
+			Method:      "post",
+			Verb:        "create",
+			Event: &event.Event{
+				RequestURI:       "/apis/kubevirt.io/v1alpha3/namespaces/kubevirt-test-default/virtualmachineinstances",
+				User:             "system:serviceaccount:kubevirt-test-default:kubevirt-e2e",
+				Groups:           []string{"system:serviceaccounts", "system:authenticated"},
+				ImpersonatedUser: "alice",
+				UserAgent:        "tests.test/v0.0.0 (linux/amd64) kubernetes/$Format",
+				SourceIPs:        []string{"10.0.0.1", "192.168.1.10"},
+			},
+		}
+
+		table.DescribeTable("Should match a single rule", func(rule string, match bool) {
+			r, err := ParseRule(rule)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Match(e2eRequest)).To(Equal(match))
+		},
+			table.Entry("With user glob", "user:system:serviceaccount:kubevirt-test-*:*", true),
+			table.Entry("With not matching user", "user:system:serviceaccount:kubevirt:*", false),
+			table.Entry("With user group", "user-group:system:serviceaccounts", true),
+			table.Entry("With impersonated user", "impersonated-user:alice", true),
+			table.Entry("With impersonated group", "impersonated-group:*", false),
+			table.Entry("With user agent regex", "user-agent:/^tests\\.test//", true),
+			table.Entry("With not matching user agent", "user-agent:virt-controller*", false),
+			table.Entry("With source IP", "source-ip:192.168.1.*", true),
+			table.Entry("With namespace from path", "namespace:kubevirt-test-*", true),
+			table.Entry("With not matching namespace", "namespace:kube-system", false),
+		)
+
+		It("Should not apply event rules to swagger endpoints", func() {
+			f, err := New([]string{"group:kubevirt.io", "user:system:serviceaccount:*"}, []string{"namespace:kube-system"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(f.Match(Request{SwaggerPath: e2eRequest.SwaggerPath, Method: "post", Verb: "create"})).To(BeTrue())
+			Expect(f.Match(e2eRequest)).To(BeTrue())
+
+			controllerRequest := e2eRequest
+			controllerRequest.Event = &event.Event{User: "system:serviceaccount:kubevirt:kubevirt-controller", RequestURI: "/apis/kubevirt.io/v1alpha3/namespaces/kube-system/virtualmachineinstances"}
+			Expect(f.Match(controllerRequest)).To(BeFalse())
+
+			humanRequest := e2eRequest
+			humanRequest.Event = &event.Event{User: "kubernetes-admin"}
+			Expect(f.Match(humanRequest)).To(BeFalse())
+		})
+	})
+
+	table.DescribeTable("Should parse k8s resource path", func(path string, expected ResourcePath, ok bool) {
+		res, parsed := ParseResourcePath(path)
+		Expect(parsed).To(Equal(ok))
+		Expect(res).To(Equal(expected))
+	},
+		table.Entry("With namespaced resource", "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances/{name}",
+			ResourcePath{Group: "kubevirt.io", Version: "v1alpha3", Namespaced: true, Resource: "virtualmachineinstances"}, true),
+		table.Entry("With watch path", "/apis/kubevirt.io/v1alpha3/watch/virtualmachineinstances",
+			ResourcePath{Group: "kubevirt.io", Version: "v1alpha3", Resource: "virtualmachineinstances"}, true),
+		table.Entry("With core subresource", "/api/v1/namespaces/default/pods/test/log",
+			ResourcePath{Version: "v1", Namespaced: true, Resource: "pods", Subresource: "log"}, true),
+		table.Entry("With namespace subresource", "/api/v1/namespaces/{name}/status",
+			ResourcePath{Version: "v1", Resource: "namespaces", Subresource: "status"}, true),
+		table.Entry("With discovery path", "/apis/kubevirt.io/v1alpha3/", ResourcePath{}, false),
+		table.Entry("With non k8s path", "/pets/{name}", ResourcePath{}, false),
+	)
+})
@@ -0,0 +1,292 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+// markerPrefix marks a window bound defined by an event
+const markerPrefix = "marker:"
+
+// Marker represents an event which opens or closes a time window, by default it is a creation of an object,
+// for instance, a test suite can create ConfigMap e2e-phase-start to mark the beginning of a test phase
+type Marker struct {
+	// Resource is a k8s resource, for instance configmaps
+	Resource string
+	// Name is a name of the object
+	Name string
+	// Namespace limits the marker to a single namespace, empty matches all namespaces
+	Namespace string
+	// Verb is a k8s verb of the marker request
+	Verb string
+}
+
+// Match checks if event is the marker, events without object reference are matched by the request path
+func (m *Marker) Match(e *event.Event) bool {
+	o, ok := objectOf(e)
+	if !ok || o.resource != m.Resource || o.subresource != "" {
+		return false
+	}
+	verb := e.Verb
+	if verb == "" {
+		verb = ActionVerb(strings.ToLower(e.Method))
+	}
+	if verb != m.Verb {
+		return false
+	}
+	if m.Namespace != "" && o.namespace != m.Namespace {
+		return false
+	}
+	return o.name == m.Name
+}
+
+func (m *Marker) String() string {
+	s := m.Resource + "/" + m.Name
+	if m.Namespace != "" {
+		s = m.Namespace + "/" + s
+	}
+	return s
+}
+
+// Bound represents a start or an end of a time window, only one of its fields is set
+type Bound struct {
+	// Time is an absolute time
+	Time time.Time
+	// Offset is a duration relative to the first event, it is used if Relative is set
+	Offset   time.Duration
+	Relative bool
+	// Marker is an event which opens or closes the window
+	Marker *Marker
+}
+
+// ParseBound parses window bound, the value is one of:
+// an absolute time in RFC 3339 format, for instance 2019-06-03T12:00:00Z,
+// a duration relative to the first event, for instance 5m or +1h30m,
+// a marker event in format marker:[namespace/]resource/name[@verb], for instance marker:configmaps/e2e-phase-start,
+// the marker verb is create by default
+func ParseBound(value string) (*Bound, error) {
+	if strings.HasPrefix(value, markerPrefix) {
+		marker, err := parseMarker(strings.TrimPrefix(value, markerPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return &Bound{Marker: marker}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return &Bound{Time: t}, nil
+	}
+	offset, err := time.ParseDuration(strings.TrimPrefix(value, "+"))
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("Invalid window bound '%s', expected RFC 3339 time, duration or marker:[namespace/]resource/name", value)
+	}
+	return &Bound{Offset: offset, Relative: true}, nil
+}
+
+func parseMarker(value string) (*Marker, error) {
+	m := &Marker{Verb: "create"}
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		value, m.Verb = value[:i], strings.ToLower(value[i+1:])
+	}
+	s := strings.Split(value, "/")
+	switch {
+	case len(s) == 2:
+		m.Resource, m.Name = s[0], s[1]
+	case len(s) == 3:
+		m.Namespace, m.Resource, m.Name = s[0], s[1], s[2]
+	}
+	if m.Resource == "" || m.Name == "" || m.Verb == "" {
+		return nil, fmt.Errorf("Invalid marker '%s', expected [namespace/]resource/name[@verb]", value)
+	}
+	return m, nil
+}
+
+// time returns absolute time of the bound, first is a timestamp of the first event
+func (b *Bound) time(first time.Time) time.Time {
+	if b.Relative {
+		return first.Add(b.Offset)
+	}
+	return b.Time
+}
+
+func (b *Bound) String() string {
+	switch {
+	case b.Marker != nil:
+		return markerPrefix + b.Marker.String()
+	case b.Relative:
+		return "+" + b.Offset.String()
+	default:
+		return b.Time.Format(time.RFC3339Nano)
+	}
+}
+
+// Window limits requests to a time window, it has to see all events in the order they were logged,
+// therefore it is checked before any other filter. Marker events themselves are not included in the window.
+// Events without timestamp are rejected if a time bound is set.
+type Window struct {
+	// Start opens the window, nil opens it with the first event
+	Start *Bound
+	// End closes the window, nil keeps it open until the last event
+	End *Bound
+	// StageTimestamp uses time of the response instead of time when the request was received
+	StageTimestamp bool
+
+	first    time.Time
+	opened   time.Time
+	closed   time.Time
+	started  bool
+	ended    bool
+	accepted int
+	// timestamps of the first and the last accepted event
+	firstAccepted time.Time
+	lastAccepted  time.Time
+}
+
+// NewWindow initializes Window from start and end bounds in format accepted by ParseBound,
+// empty bound is not set, nil is returned if none of bounds is set
+func NewWindow(start string, end string, stageTimestamp bool) (*Window, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+	w := &Window{StageTimestamp: stageTimestamp}
+	var err error
+	if start != "" {
+		if w.Start, err = ParseBound(start); err != nil {
+			return nil, err
+		}
+	}
+	if end != "" {
+		if w.End, err = ParseBound(end); err != nil {
+			return nil, err
+		}
+	}
+	if w.Start != nil && w.End != nil && w.Start.Marker == nil && w.End.Marker == nil &&
+		w.Start.Relative == w.End.Relative && !w.Start.time(time.Time{}).Before(w.End.time(time.Time{})) {
+		return nil, fmt.Errorf("Invalid window, start '%s' is not before end '%s'", start, end)
+	}
+	return w, nil
+}
+
+// Clone returns a window with the same bounds which has not seen any event yet, it returns nil for nil window
+func (w *Window) Clone() *Window {
+	if w == nil {
+		return nil
+	}
+	return &Window{Start: w.Start, End: w.End, StageTimestamp: w.StageTimestamp}
+}
+
+// Accept checks if event belongs to the window, events have to be passed in order, nil window accepts everything
+func (w *Window) Accept(e *event.Event) bool {
+	if w == nil {
+		return true
+	}
+	t := e.RequestReceivedTimestamp
+	if w.StageTimestamp {
+		t = e.StageTimestamp
+	}
+	// relative bounds are based on the first event with timestamp
+	if w.first.IsZero() && !t.IsZero() {
+		w.first = t
+	}
+
+	if w.Start != nil && w.Start.Marker != nil && !w.started {
+		if w.Start.Marker.Match(e) {
+			w.started, w.opened = true, t
+		}
+		return false
+	}
+	if w.ended {
+		return false
+	}
+	if w.End != nil {
+		if w.End.Marker != nil {
+			if w.End.Marker.Match(e) {
+				w.ended, w.closed = true, t
+				return false
+			}
+		} else if t.IsZero() || !t.Before(w.End.time(w.first)) {
+			return false
+		}
+	}
+	// time bounds are checked for each event, logs are not strictly ordered
+	if w.Start != nil && w.Start.Marker == nil && (t.IsZero() || t.Before(w.Start.time(w.first))) {
+		return false
+	}
+
+	w.accepted++
+	if t.IsZero() {
+		return true
+	}
+	if w.firstAccepted.IsZero() || t.Before(w.firstAccepted) {
+		w.firstAccepted = t
+	}
+	if t.After(w.lastAccepted) {
+		w.lastAccepted = t
+	}
+	return true
+}
+
+// Effective returns the time window which has been applied, bounds which are not set or markers which have not been seen
+// are replaced with timestamps of the first and the last accepted event
+func (w *Window) Effective() (start time.Time, end time.Time) {
+	start, end = w.firstAccepted, w.lastAccepted
+	switch {
+	case w.Start == nil:
+	case w.Start.Marker != nil:
+		if w.started && !w.opened.IsZero() {
+			start = w.opened
+		}
+	case !w.first.IsZero() || !w.Start.Relative:
+		start = w.Start.time(w.first)
+	}
+	switch {
+	case w.End == nil:
+	case w.End.Marker != nil:
+		if w.ended && !w.closed.IsZero() {
+			end = w.closed
+		}
+	case !w.first.IsZero() || !w.End.Relative:
+		end = w.End.time(w.first)
+	}
+	return start, end
+}
+
+// Accepted returns number of events accepted by the window
+func (w *Window) Accepted() int {
+	return w.accepted
+}
+
+// WindowState represents events seen by a window, it is used to resume matching of events from a checkpoint
+type WindowState struct {
+	First         time.Time `json:"first"`
+	Opened        time.Time `json:"opened"`
+	Closed        time.Time `json:"closed"`
+	Started       bool      `json:"started"`
+	Ended         bool      `json:"ended"`
+	Accepted      int       `json:"accepted"`
+	FirstAccepted time.Time `json:"firstAccepted"`
+	LastAccepted  time.Time `json:"lastAccepted"`
+}
+
+// State returns events seen by the window so far
+func (w *Window) State() WindowState {
+	return WindowState{
+		First:         w.first,
+		Opened:        w.opened,
+		Closed:        w.closed,
+		Started:       w.started,
+		Ended:         w.ended,
+		Accepted:      w.accepted,
+		FirstAccepted: w.firstAccepted,
+		LastAccepted:  w.lastAccepted,
+	}
+}
+
+// Restore sets events seen by the window, following events are checked as if the window has seen events of the state
+func (w *Window) Restore(state WindowState) {
+	w.first, w.opened, w.closed = state.First, state.Opened, state.Closed
+	w.started, w.ended, w.accepted = state.Started, state.Ended, state.Accepted
+	w.firstAccepted, w.lastAccepted = state.FirstAccepted, state.LastAccepted
+}
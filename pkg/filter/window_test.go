@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+var _ = Describe("Time window", func() {
+
+	first := time.Date(2019, 6, 3, 12, 0, 0, 0, time.UTC)
+	newEvent := func(offset time.Duration) *event.Event {
+		return &event.Event{
+			Verb:                     "get",
+			RequestURI:               "/api/v1/namespaces/default/pods/test",
+			RequestReceivedTimestamp: first.Add(offset),
+			StageTimestamp:           first.Add(offset + time.Second),
+		}
+	}
+	newMarker := func(offset time.Duration, name string) *event.Event {
+		e := newEvent(offset)
+		e.Verb = "create"
+		e.RequestURI = "/api/v1/namespaces/e2e/configmaps"
+		e.ObjectRef = &auditv1.ObjectReference{Resource: "configmaps", Namespace: "e2e"}
+		e.RequestBody = []byte(`{"metadata":{"name":"` + name + `"}}`)
+		return e
+	}
+
+	table.DescribeTable("Should parse bound", func(value string, expected *Bound) {
+		b, err := ParseBound(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b).To(Equal(expected))
+	},
+		table.Entry("With absolute time", "2019-06-03T12:00:00Z", &Bound{Time: first}),
+		table.Entry("With duration", "5m", &Bound{Offset: 5 * time.Minute, Relative: true}),
+		table.Entry("With signed duration", "+1h30m", &Bound{Offset: 90 * time.Minute, Relative: true}),
+		table.Entry("With marker", "marker:configmaps/e2e-phase-start",
+			&Bound{Marker: &Marker{Resource: "configmaps", Name: "e2e-phase-start", Verb: "create"}}),
+		table.Entry("With namespaced marker and verb", "marker:e2e/configmaps/e2e-phase-end@Delete",
+			&Bound{Marker: &Marker{Namespace: "e2e", Resource: "configmaps", Name: "e2e-phase-end", Verb: "delete"}}),
+	)
+
+	table.DescribeTable("Should not parse invalid bound", func(value string) {
+		_, err := ParseBound(value)
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With unknown format", "yesterday"),
+		table.Entry("With negative duration", "-5m"),
+		table.Entry("With marker without name", "marker:configmaps"),
+		table.Entry("With marker without verb", "marker:configmaps/e2e-phase-start@"),
+	)
+
+	It("Should not create window without bounds", func() {
+		w, err := NewWindow("", "", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w).To(BeNil())
+		Expect(w.Accept(newEvent(0))).To(BeTrue(), "nil window should accept everything")
+	})
+
+	It("Should not create window with start after end", func() {
+		_, err := NewWindow("10m", "5m", false)
+		Expect(err).To(HaveOccurred())
+	})
+
+	table.DescribeTable("Should accept events within window", func(start string, end string, stageTimestamp bool, accepted []bool) {
+		w, err := NewWindow(start, end, stageTimestamp)
+		Expect(err).NotTo(HaveOccurred())
+		events := []*event.Event{
+			newEvent(0),
+			newEvent(time.Minute),
+			newMarker(2*time.Minute, "e2e-phase-start"),
+			newEvent(3 * time.Minute),
+			newMarker(4*time.Minute, "e2e-phase-end"),
+			newEvent(5 * time.Minute),
+		}
+		for i, e := range events {
+			Expect(w.Accept(e)).To(Equal(accepted[i]), "event %d", i)
+		}
+	},
+		table.Entry("With absolute start", "2019-06-03T12:01:00Z", "", false, []bool{false, true, true, true, true, true}),
+		table.Entry("With absolute end", "", "2019-06-03T12:03:00Z", false, []bool{true, true, true, false, false, false}),
+		table.Entry("With relative bounds", "1m", "+4m", false, []bool{false, true, true, true, false, false}),
+		table.Entry("With stage timestamp", "", "+3m", true, []bool{true, true, true, false, false, false}),
+		table.Entry("With marker bounds", "marker:configmaps/e2e-phase-start", "marker:e2e/configmaps/e2e-phase-end",
+			false, []bool{false, false, false, true, false, false}),
+		table.Entry("With marker start only", "marker:configmaps/e2e-phase-start", "", false, []bool{false, false, false, true, true, true}),
+		table.Entry("With marker from another namespace", "marker:default/configmaps/e2e-phase-start", "",
+			false, []bool{false, false, false, false, false, false}),
+	)
+
+	table.DescribeTable("Should match markers of events without object reference", func(bound string, e *event.Event, expected bool) {
+		b, err := ParseBound(bound)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Marker.Match(e)).To(Equal(expected))
+	},
+		table.Entry("With created object", "marker:e2e/configmaps/e2e-phase-start", &event.Event{
+			Method: "POST", RequestURI: "/api/v1/namespaces/e2e/configmaps?dryRun=All", RequestBody: []byte(`{"metadata":{"name":"e2e-phase-start"}}`),
+		}, true),
+		table.Entry("With deleted object", "marker:e2e/configmaps/e2e-phase-end@delete", &event.Event{
+			Method: "DELETE", RequestURI: "/api/v1/namespaces/e2e/configmaps/e2e-phase-end",
+		}, true),
+		table.Entry("With another name", "marker:configmaps/e2e-phase-end@delete", &event.Event{
+			Method: "DELETE", RequestURI: "/api/v1/namespaces/e2e/configmaps/e2e-phase-start",
+		}, false),
+		table.Entry("With another namespace", "marker:default/configmaps/e2e-phase-start", &event.Event{
+			Method: "POST", RequestURI: "/api/v1/namespaces/e2e/configmaps", RequestBody: []byte(`{"metadata":{"name":"e2e-phase-start"}}`),
+		}, false),
+		table.Entry("With subresource", "marker:configmaps/e2e-phase-start@update", &event.Event{
+			Method: "PUT", RequestURI: "/api/v1/namespaces/e2e/configmaps/e2e-phase-start/status",
+		}, false),
+		table.Entry("With non k8s path", "marker:configmaps/e2e-phase-start", &event.Event{
+			Method: "POST", RequestURI: "/pets", RequestBody: []byte(`{"metadata":{"name":"e2e-phase-start"}}`),
+		}, false),
+	)
+
+	It("Should provide effective window", func() {
+		w, err := NewWindow("marker:configmaps/e2e-phase-start", "", false)
+		Expect(err).NotTo(HaveOccurred())
+		for _, e := range []*event.Event{newEvent(0), newMarker(time.Minute, "e2e-phase-start"), newEvent(2 * time.Minute), newEvent(3 * time.Minute)} {
+			w.Accept(e)
+		}
+		start, end := w.Effective()
+		Expect(start).To(Equal(first.Add(time.Minute)))
+		Expect(end).To(Equal(first.Add(3 * time.Minute)))
+		Expect(w.Accepted()).To(Equal(2))
+	})
+
+	It("Should ignore events without timestamp", func() {
+		w, err := NewWindow("marker:configmaps/e2e-phase-start", "+3m", false)
+		Expect(err).NotTo(HaveOccurred())
+		untimed := func() *event.Event {
+			e := newEvent(0)
+			e.RequestReceivedTimestamp = time.Time{}
+			return e
+		}
+		Expect(w.Accept(untimed())).To(BeFalse())
+		Expect(w.Accept(newEvent(time.Minute))).To(BeFalse())
+		Expect(w.Accept(newMarker(2*time.Minute, "e2e-phase-start"))).To(BeFalse())
+		Expect(w.Accept(newEvent(3*time.Minute))).To(BeTrue(), "relative end should be based on the first event with timestamp")
+		Expect(w.Accept(untimed())).To(BeFalse(), "events without timestamp are rejected by time bounds")
+		Expect(w.Accept(newEvent(4 * time.Minute))).To(BeFalse())
+		start, end := w.Effective()
+		Expect(start).To(Equal(first.Add(2 * time.Minute)))
+		Expect(end).To(Equal(first.Add(4 * time.Minute)))
+
+		w, err = NewWindow("marker:configmaps/e2e-phase-start", "", false)
+		Expect(err).NotTo(HaveOccurred())
+		for _, e := range []*event.Event{untimed(), newMarker(time.Minute, "e2e-phase-start"), newEvent(2 * time.Minute), untimed(), newEvent(3 * time.Minute)} {
+			w.Accept(e)
+		}
+		start, end = w.Effective()
+		Expect(start).To(Equal(first.Add(time.Minute)))
+		Expect(end).To(Equal(first.Add(3 * time.Minute)))
+		Expect(w.Accepted()).To(Equal(3))
+		Expect(w.State().FirstAccepted).To(Equal(first.Add(2*time.Minute)), "events without timestamp should not be the first accepted event")
+	})
+
+	It("Should resume from a restored state", func() {
+		w, err := NewWindow("marker:configmaps/e2e-phase-start", "+10m", false)
+		Expect(err).NotTo(HaveOccurred())
+		for _, e := range []*event.Event{newEvent(0), newMarker(time.Minute, "e2e-phase-start"), newEvent(2 * time.Minute)} {
+			w.Accept(e)
+		}
+
+		resumed := w.Clone()
+		resumed.Restore(w.State())
+		Expect(resumed.State()).To(Equal(w.State()))
+		Expect(resumed.Accept(newEvent(3 * time.Minute))).To(BeTrue())
+		Expect(resumed.Accept(newEvent(11*time.Minute))).To(BeFalse(), "relative end should be based on the first event before resume")
+		start, _ := resumed.Effective()
+		Expect(start).To(Equal(first.Add(time.Minute)))
+		Expect(resumed.Accepted()).To(Equal(2))
+	})
+})
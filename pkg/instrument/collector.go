@@ -0,0 +1,176 @@
+package instrument
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/report"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+type testKey struct{}
+
+// WithTest returns a context which attributes requests to the given test,
+// it takes precedence over the test set by Collector.StartTest
+func WithTest(ctx context.Context, test string) context.Context {
+	return context.WithValue(ctx, testKey{}, test)
+}
+
+// Collector matches requests sent by instrumented clients as they are recorded, it is safe for concurrent use
+// so a single collector can be shared by all clients used in tests. Requests are not kept, so the collector
+// can instrument long test suites
+type Collector struct {
+	collector *report.Collector
+	lock      sync.Mutex
+	test      string
+	tests     map[string]bool
+}
+
+// NewCollector loads swagger definition and initializes Collector, requests are attributed to tests
+// by the collector, so options.Attribution should be nil, options.Sources are not used
+func NewCollector(options report.Options) (*Collector, error) {
+	collector, err := report.NewCollector(options)
+	if err != nil {
+		return nil, err
+	}
+	return &Collector{collector: collector, tests: make(map[string]bool)}, nil
+}
+
+// WrapTransport wraps rt so that every request sent through it is recorded by the collector,
+// if rt is nil then http.DefaultTransport is used. It has the same signature as client-go transport.WrapperFunc,
+// therefore it can be used to instrument rest.Config:
+//
+//	config.Wrap(collector.WrapTransport)
+//
+// or with older client-go versions:
+//
+//	config.WrapTransport = collector.WrapTransport
+func (c *Collector) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &roundTripper{
+		collector: c,
+		delegate:  rt,
+	}
+}
+
+// StartTest attributes all following requests to the given test, returned func stops the attribution,
+// for instance:
+//
+//	defer collector.StartTest(t.Name())()
+func (c *Collector) StartTest(test string) func() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.test = test
+
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.test == test {
+			c.test = ""
+		}
+	}
+}
+
+// Record matches an event, event without a test is attributed to the currently running test
+func (c *Collector) Record(e *event.Event) error {
+	c.lock.Lock()
+	if e.Test == "" {
+		e.Test = c.test
+	}
+	if e.Test != "" {
+		c.tests[e.Test] = true
+	}
+	c.lock.Unlock()
+	return c.collector.Observe(e)
+}
+
+// Tests returns sorted names of tests which sent at least one request
+func (c *Collector) Tests() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tests := make([]string, 0, len(c.tests))
+	for test := range c.tests {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// Coverage returns a snapshot of REST API coverage of all recorded requests
+func (c *Collector) Coverage() *stats.Coverage {
+	return c.collector.Coverage()
+}
+
+// TestCoverage returns a snapshot of REST API coverage of requests attributed to the given test,
+// see report.CoverageOfTest
+func (c *Collector) TestCoverage(test string) *stats.Coverage {
+	return report.CoverageOfTest(c.collector.Coverage(), test)
+}
+
+// roundTripper records requests and passes them to the delegate
+type roundTripper struct {
+	collector *Collector
+	delegate  http.RoundTripper
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if req.GetBody != nil {
+			// read a copy, the original body is consumed by the delegate
+			var b io.ReadCloser
+			if b, err = req.GetBody(); err == nil {
+				body, err = ioutil.ReadAll(b)
+				b.Close()
+			}
+		} else {
+			body, err = ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			req = req.Clone(req.Context())
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sent := time.Now()
+	resp, err := rt.delegate.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	test, _ := req.Context().Value(testKey{}).(string)
+	err = rt.collector.Record(&event.Event{
+		Method:       req.Method,
+		RequestURI:   req.URL.RequestURI(),
+		RequestBody:  body,
+		ContentType:  req.Header.Get("Content-Type"),
+		ResponseCode: resp.StatusCode,
+		Test:         test,
+		UserAgent:    req.UserAgent(),
+		// client knows only impersonation headers, the authenticated user is resolved by the API server
+		ImpersonatedUser:   req.Header.Get("Impersonate-User"),
+		ImpersonatedGroups: req.Header["Impersonate-Group"],
+
+		RequestReceivedTimestamp: sent,
+		StageTimestamp:           time.Now(),
+	})
+	if err != nil {
+		// the request has been sent, coverage is not a reason to fail it
+		glog.Errorf("Could not record request '%s %s': %s", req.Method, req.URL.RequestURI(), err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,115 @@
+package instrument
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/report"
+)
+
+var _ = Describe("Client instrumentation", func() {
+
+	var (
+		server    *httptest.Server
+		client    *http.Client
+		collector *Collector
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+		}))
+		var err error
+		collector, err = NewCollector(report.Options{Spec: report.SpecFile(petStoreSwaggerPath), Logger: report.NopLogger()})
+		Expect(err).NotTo(HaveOccurred())
+		client = &http.Client{Transport: collector.WrapTransport(nil)}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	doRequest := func(ctx context.Context, method string, uri string, body string) string {
+		req, err := http.NewRequest(method, server.URL+uri, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(respBody)
+	}
+
+	It("Should record a request and pass an unchanged body", func() {
+		respBody := doRequest(context.Background(), http.MethodPost, "/pets?limit=1", `{"name":"bite"}`)
+		Expect(respBody).To(Equal(`{"name":"bite"}`))
+
+		post := collector.Coverage().Endpoints["/pets"]["post"]
+		Expect(post.MethodCalled).To(BeTrue())
+		Expect(post.Hits).To(Equal(1))
+		Expect(post.Body.Root.GetChild("name").Hits).To(Equal(1))
+	})
+
+	It("Should attribute requests to the running test", func() {
+		stop := collector.StartTest("test-a")
+		doRequest(context.Background(), http.MethodGet, "/pets", "")
+		doRequest(WithTest(context.Background(), "test-b"), http.MethodGet, "/pets/bite", "")
+		stop()
+		doRequest(context.Background(), http.MethodDelete, "/pets/bite", "")
+
+		coverage := collector.Coverage()
+		Expect(coverage.Endpoints["/pets"]["get"].Tests).To(Equal([]string{"test-a"}))
+		Expect(coverage.Endpoints["/pets/{name}"]["get"].Tests).To(Equal([]string{"test-b"}))
+		Expect(coverage.Endpoints["/pets/{name}"]["delete"].MethodCalled).To(BeTrue())
+		Expect(coverage.Endpoints["/pets/{name}"]["delete"].Tests).To(BeEmpty())
+		Expect(collector.Tests()).To(Equal([]string{"test-a", "test-b"}))
+	})
+
+	It("Should record concurrent requests", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				doRequest(context.Background(), http.MethodGet, "/pets", "")
+			}()
+		}
+		wg.Wait()
+		Expect(collector.Coverage().Endpoints["/pets"]["get"].Hits).To(Equal(20))
+	})
+
+	It("Should calculate coverage for all requests and for a single test", func() {
+		stop := collector.StartTest("create")
+		doRequest(context.Background(), http.MethodPost, "/pets", `{"name":"bite","tag":"dog"}`)
+		stop()
+		doRequest(context.Background(), http.MethodGet, "/pets/bite", "")
+		doRequest(WithTest(context.Background(), "update"), http.MethodPost, "/pets", `{"name":"bite"}`)
+
+		coverage := collector.Coverage()
+		Expect(coverage.Endpoints["/pets"]["post"].MethodCalled).To(BeTrue())
+		Expect(coverage.Endpoints["/pets/{name}"]["get"].MethodCalled).To(BeTrue())
+
+		create := collector.TestCoverage("create")
+		Expect(create.Endpoints["/pets"]["post"].MethodCalled).To(BeTrue())
+		Expect(create.Endpoints["/pets"]["post"].Body.UniqueHits).To(Equal(2))
+		Expect(create.Endpoints["/pets/{name}"]["get"].MethodCalled).To(BeFalse())
+		Expect(create.UniqueHits).To(Equal(3))
+		Expect(create.Percent).To(BeNumerically("<", coverage.Percent))
+
+		update := collector.TestCoverage("update")
+		Expect(update.Endpoints["/pets"]["post"].Body.UniqueHits).To(Equal(1))
+		Expect(update.Endpoints["/pets"]["post"].Body.Root.GetChild("tag").Hits).To(BeZero())
+		Expect(update.UniqueHits).To(Equal(2))
+	})
+})
@@ -0,0 +1,23 @@
+package instrument
+
+import (
+	"path"
+	"runtime"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var petStoreSwaggerPath string
+
+func TestInstrument(t *testing.T) {
+	_, p, _, ok := runtime.Caller(0)
+	if !ok {
+		panic("Not possible to get test file path")
+	}
+	petStoreSwaggerPath = path.Join(path.Dir(p), "../../fixtures/test_petstore.json")
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Instrument Suite")
+}
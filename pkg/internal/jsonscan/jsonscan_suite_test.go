@@ -0,0 +1,13 @@
+package jsonscan
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestJSONScan(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "JSON Scan Suite")
+}
@@ -0,0 +1,144 @@
+// Package jsonscan moves over tokens of a valid JSON without decoding values, it is used by decoders
+// which decode only the parts of audit events and request bodies they use
+package jsonscan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Scanner moves over tokens of a valid JSON, Pos is the current position in Data
+type Scanner struct {
+	Data []byte
+	Pos  int
+}
+
+// Str moves after a string which starts at the current position, it returns the quoted string
+// and true if the string has escape sequences
+func (s *Scanner) Str() ([]byte, bool) {
+	start := s.Pos
+	// most strings do not have escape sequences, they end at the first quote
+	if end := bytes.IndexByte(s.Data[start+1:], '"'); end >= 0 && bytes.IndexByte(s.Data[start+1:start+1+end], '\\') < 0 {
+		s.Pos = start + end + 2
+		return s.Data[start:s.Pos], false
+	}
+	escaped := false
+	for s.Pos++; s.Pos < len(s.Data); s.Pos++ {
+		switch s.Data[s.Pos] {
+		case '"':
+			s.Pos++
+			return s.Data[start:s.Pos], escaped
+		case '\\':
+			escaped = true
+			s.Pos++
+		}
+	}
+	return s.Data[start:], escaped
+}
+
+// Value moves after a value which starts at the current position and returns it
+func (s *Scanner) Value() []byte {
+	start := s.Pos
+	switch s.Peek() {
+	case '"':
+		s.Str()
+	case '{', '[':
+		depth := 0
+		for ; s.Pos < len(s.Data); s.Pos++ {
+			switch s.Data[s.Pos] {
+			case '"':
+				s.Str()
+				s.Pos--
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					s.Pos++
+					return s.Data[start:s.Pos]
+				}
+			}
+		}
+	default:
+		// numbers and literals end with a delimiter
+		for s.Pos < len(s.Data) {
+			switch s.Data[s.Pos] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				return s.Data[start:s.Pos]
+			}
+			s.Pos++
+		}
+	}
+	return s.Data[start:s.Pos]
+}
+
+// Peek returns the current character, 0 at the end of data
+func (s *Scanner) Peek() byte {
+	if s.Pos < len(s.Data) {
+		return s.Data[s.Pos]
+	}
+	return 0
+}
+
+// Space moves after white spaces
+func (s *Scanner) Space() {
+	for s.Pos < len(s.Data) {
+		switch s.Data[s.Pos] {
+		case ' ', '\t', '\n', '\r':
+			s.Pos++
+		default:
+			return
+		}
+	}
+}
+
+// Fields iterates over fields of an object, null is an empty object, keys are compared exactly
+type Fields struct {
+	Scanner
+	Key []byte
+	Val []byte
+	Err error
+}
+
+// FieldsOf starts iteration over fields of the JSON object
+func FieldsOf(b []byte) Fields {
+	f := Fields{Scanner: Scanner{Data: b}}
+	f.Space()
+	switch {
+	case string(f.Data[f.Pos:]) == "null":
+		f.Pos = len(f.Data)
+	case f.Peek() != '{':
+		f.Err = fmt.Errorf("Invalid JSON object '%s'", f.Data[f.Pos:])
+	default:
+		f.Pos++
+	}
+	return f
+}
+
+// Next moves to the next field, it returns false at the end of the object or if a key can not be decoded
+func (f *Fields) Next() bool {
+	f.Space()
+	if f.Err != nil || f.Peek() != '"' {
+		return false
+	}
+	raw, escaped := f.Str()
+	f.Key = raw[1 : len(raw)-1]
+	if escaped {
+		var key string
+		if f.Err = json.Unmarshal(raw, &key); f.Err != nil {
+			return false
+		}
+		f.Key = []byte(key)
+	}
+	f.Space()
+	// skip ':'
+	f.Pos++
+	f.Space()
+	f.Val = f.Value()
+	f.Space()
+	if f.Peek() == ',' {
+		f.Pos++
+	}
+	return true
+}
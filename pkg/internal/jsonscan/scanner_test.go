@@ -0,0 +1,46 @@
+package jsonscan
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSON scanner", func() {
+
+	table.DescribeTable("Should move after a value", func(data string, value string) {
+		s := &Scanner{Data: []byte(data)}
+		Expect(string(s.Value())).To(Equal(value))
+		Expect(s.Pos).To(Equal(len(value)))
+	},
+		table.Entry("With a string", `"a\"}b",1`, `"a\"}b"`),
+		table.Entry("With a number", `12.5}`, `12.5`),
+		table.Entry("With a literal", `true ,`, `true`),
+		table.Entry("With nested objects", `{"a":{"b":["}",{}]}},2`, `{"a":{"b":["}",{}]}}`),
+		table.Entry("With an array", `[1,[2],"]"]]`, `[1,[2],"]"]`),
+	)
+
+	It("Should iterate over fields of an object", func() {
+		f := FieldsOf([]byte(` { "a" : 1, "bc":{"d":[1]} ,"e":"f"}`))
+		var keys, values []string
+		for f.Next() {
+			keys = append(keys, string(f.Key))
+			values = append(values, string(f.Val))
+		}
+		Expect(f.Err).NotTo(HaveOccurred())
+		Expect(keys).To(Equal([]string{"a", "bc", "e"}))
+		Expect(values).To(Equal([]string{"1", `{"d":[1]}`, `"f"`}))
+	})
+
+	It("Should iterate over null as an empty object", func() {
+		f := FieldsOf([]byte(`null`))
+		Expect(f.Next()).To(BeFalse())
+		Expect(f.Err).NotTo(HaveOccurred())
+	})
+
+	It("Should fail for values other than objects", func() {
+		f := FieldsOf([]byte(`[{"a":1}]`))
+		Expect(f.Next()).To(BeFalse())
+		Expect(f.Err).To(HaveOccurred())
+	})
+})
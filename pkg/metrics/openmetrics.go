@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// ContentType is a content type of OpenMetrics text format
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Options controls which metrics are exposed, field metrics may produce a lot of series for big APIs
+type Options struct {
+	// Fields enables counters of hits per body and query field
+	Fields bool
+	// MaxFieldDepth limits depth of field metrics, hits of deeper fields are summed by their ancestor at max depth,
+	// 0 does not limit anything
+	MaxFieldDepth int
+	// MaxFieldSeries limits number of field series, fields over the limit are dropped and counted
+	// by rest_coverage_field_series_dropped, 0 does not limit anything
+	MaxFieldSeries int
+}
+
+// Encode writes coverage metrics in OpenMetrics text format, series are sorted so the output is deterministic
+func Encode(w io.Writer, coverage *stats.Coverage, options Options) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+
+	e.family("rest_coverage_percent", "gauge", "Total REST API coverage in percent.")
+	e.sample("rest_coverage_percent", nil, coverage.Percent)
+	e.family("rest_coverage_unique_hits", "gauge", "Number of covered endpoints and fields.")
+	e.sample("rest_coverage_unique_hits", nil, float64(coverage.UniqueHits))
+	e.family("rest_coverage_expected_unique_hits", "gauge", "Number of endpoints and fields included in the total coverage.")
+	e.sample("rest_coverage_expected_unique_hits", nil, float64(coverage.ExpectedUniqueHits))
+
+	endpoints := coverage.SortedEndpoints()
+	e.family("rest_coverage_endpoint_percent", "gauge", "Coverage of endpoint in percent.")
+	for _, endpoint := range endpoints {
+		e.sample("rest_coverage_endpoint_percent", endpointLabels(endpoint), endpoint.Percent)
+	}
+	e.family("rest_coverage_endpoint_hits", "counter", "Number of requests matched to endpoint.")
+	for _, endpoint := range endpoints {
+		e.sample("rest_coverage_endpoint_hits_total", endpointLabels(endpoint), float64(endpoint.Hits))
+	}
+
+	if options.Fields {
+		series, dropped := 0, 0
+		e.family("rest_coverage_field_subtree_hits", "counter", "Sum of hits of body or query field and its nested fields.")
+		for _, endpoint := range endpoints {
+			for _, params := range []struct {
+				location string
+				trie     *stats.Trie
+			}{{"body", endpoint.Body}, {"query", endpoint.Query}} {
+				if params.trie == nil {
+					continue
+				}
+				for _, f := range params.trie.Root.Leaves(options.MaxFieldDepth) {
+					if options.MaxFieldSeries > 0 && series >= options.MaxFieldSeries {
+						dropped++
+						continue
+					}
+					series++
+					labels := append(endpointLabels(endpoint), label{"location", params.location}, label{"field", f.Path})
+					e.sample("rest_coverage_field_subtree_hits_total", labels, float64(f.Node.Hits))
+				}
+			}
+		}
+		e.family("rest_coverage_field_series_dropped", "gauge", "Number of field series dropped due to the series limit.")
+		e.sample("rest_coverage_field_series_dropped", nil, float64(dropped))
+	}
+
+	e.printf("# EOF\n")
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// Handler serves coverage metrics, coverage is provided by snapshot on each scrape
+func Handler(snapshot func() (*stats.Coverage, error), options Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coverage, err := snapshot()
+		if err != nil {
+			glog.Errorf("Could not calculate coverage metrics: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if err := Encode(w, coverage, options); err != nil {
+			glog.Errorf("Could not write coverage metrics: %s", err)
+		}
+	})
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+// encoder writes OpenMetrics text format, it keeps the first write error
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+func (e *encoder) family(name string, metricType string, help string) {
+	e.printf("# TYPE %s %s\n# HELP %s %s\n", name, metricType, name, help)
+}
+
+func (e *encoder) sample(name string, labels []label, value float64) {
+	if len(labels) == 0 {
+		e.printf("%s %s\n", name, formatValue(value))
+		return
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.name+`="`+escapeLabelValue(l.value)+`"`)
+	}
+	e.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatValue(value))
+}
+
+func endpointLabels(e *stats.Endpoint) []label {
+	return []label{
+		{"path", e.Path},
+		{"method", e.Method},
+		{"group", e.Group},
+		{"version", e.Version},
+		{"resource", e.Resource},
+	}
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return strings.Replace(v, "\n", `\n`, -1)
+}
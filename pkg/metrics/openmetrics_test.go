@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("OpenMetrics encoder", func() {
+
+	var coverage *stats.Coverage
+
+	BeforeEach(func() {
+		create := &stats.Endpoint{
+			Params:   stats.Params{Body: stats.NewTrie(), Query: stats.NewTrie()},
+			Path:     "/apis/example.io/v1/namespaces/{namespace}/foos",
+			Method:   "post",
+			Group:    "example.io",
+			Version:  "v1",
+			Resource: "foos",
+			Percent:  50,
+			Hits:     2,
+		}
+		spec := create.Body.Add("spec", nil, false)
+		image := create.Body.Add("image", spec, true)
+		create.Body.Add("replicas", spec, true)
+		create.Body.IncreaseHits(image)
+		create.Body.IncreaseHits(image)
+		create.Query.Add("dryRun", nil, true)
+
+		list := &stats.Endpoint{
+			Params:   stats.Params{Body: stats.NewTrie(), Query: stats.NewTrie()},
+			Path:     "/apis/example.io/v1/namespaces/{namespace}/foos",
+			Method:   "get",
+			Group:    "example.io",
+			Version:  "v1",
+			Resource: "foos",
+		}
+		coverage = &stats.Coverage{
+			UniqueHits:         3,
+			ExpectedUniqueHits: 6,
+			Percent:            50,
+			Endpoints: map[string]map[string]*stats.Endpoint{
+				create.Path: {"post": create, "get": list},
+			},
+		}
+	})
+
+	encode := func(options Options) string {
+		var out bytes.Buffer
+		Expect(Encode(&out, coverage, options)).To(Succeed())
+		return out.String()
+	}
+
+	It("Should encode total and endpoint metrics", func() {
+		labels := `path="/apis/example.io/v1/namespaces/{namespace}/foos",method="%s",group="example.io",version="v1",resource="foos"`
+		Expect(encode(Options{})).To(Equal("" +
+			"# TYPE rest_coverage_percent gauge\n" +
+			"# HELP rest_coverage_percent Total REST API coverage in percent.\n" +
+			"rest_coverage_percent 50\n" +
+			"# TYPE rest_coverage_unique_hits gauge\n" +
+			"# HELP rest_coverage_unique_hits Number of covered endpoints and fields.\n" +
+			"rest_coverage_unique_hits 3\n" +
+			"# TYPE rest_coverage_expected_unique_hits gauge\n" +
+			"# HELP rest_coverage_expected_unique_hits Number of endpoints and fields included in the total coverage.\n" +
+			"rest_coverage_expected_unique_hits 6\n" +
+			"# TYPE rest_coverage_endpoint_percent gauge\n" +
+			"# HELP rest_coverage_endpoint_percent Coverage of endpoint in percent.\n" +
+			"rest_coverage_endpoint_percent{" + fmt.Sprintf(labels, "get") + "} 0\n" +
+			"rest_coverage_endpoint_percent{" + fmt.Sprintf(labels, "post") + "} 50\n" +
+			"# TYPE rest_coverage_endpoint_hits counter\n" +
+			"# HELP rest_coverage_endpoint_hits Number of requests matched to endpoint.\n" +
+			"rest_coverage_endpoint_hits_total{" + fmt.Sprintf(labels, "get") + "} 0\n" +
+			"rest_coverage_endpoint_hits_total{" + fmt.Sprintf(labels, "post") + "} 2\n" +
+			"# EOF\n"))
+	})
+
+	It("Should encode field metrics", func() {
+		metrics := encode(Options{Fields: true})
+		Expect(metrics).To(ContainSubstring(`rest_coverage_field_subtree_hits_total{path="/apis/example.io/v1/namespaces/{namespace}/foos",method="post",group="example.io",version="v1",resource="foos",location="body",field="spec.image"} 2` + "\n"))
+		Expect(metrics).To(ContainSubstring(`location="body",field="spec.replicas"} 0` + "\n"))
+		Expect(metrics).To(ContainSubstring(`location="query",field="dryRun"} 0` + "\n"))
+		Expect(metrics).To(ContainSubstring("rest_coverage_field_series_dropped 0\n"))
+		Expect(metrics).To(HaveSuffix("# EOF\n"))
+	})
+
+	It("Should limit depth of field metrics", func() {
+		metrics := encode(Options{Fields: true, MaxFieldDepth: 1})
+		Expect(metrics).To(ContainSubstring(`location="body",field="spec"} 2` + "\n"))
+		Expect(metrics).NotTo(ContainSubstring(`field="spec.image"`))
+	})
+
+	It("Should limit number of field series", func() {
+		metrics := encode(Options{Fields: true, MaxFieldSeries: 2})
+		Expect(strings.Count(metrics, "rest_coverage_field_subtree_hits_total{")).To(Equal(2))
+		Expect(metrics).To(ContainSubstring("rest_coverage_field_series_dropped 1\n"))
+	})
+
+	It("Should escape label values", func() {
+		Expect(escapeLabelValue("a\"b\\c\nd")).To(Equal(`a\"b\\c\nd`))
+	})
+
+	It("Should serve metrics", func() {
+		server := httptest.NewServer(Handler(func() (*stats.Coverage, error) {
+			return coverage, nil
+		}, Options{}))
+		defer server.Close()
+
+		resp, err := server.Client().Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Header.Get("Content-Type")).To(Equal(ContentType))
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal(encode(Options{})))
+	})
+})
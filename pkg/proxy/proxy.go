@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+// Recorder receives events captured by the proxy, it is called concurrently
+type Recorder func(e *event.Event)
+
+// Proxy represents an HTTP reverse proxy which records all requests passed to the API server
+type Proxy struct {
+	reverseProxy *httputil.ReverseProxy
+	record       Recorder
+}
+
+// New initializes Proxy, if transport is nil then http.DefaultTransport is used
+func New(target *url.URL, transport http.RoundTripper, record Recorder) *Proxy {
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	reverseProxy.Transport = transport
+	// flush immediately to support watch requests
+	reverseProxy.FlushInterval = -1
+
+	return &Proxy{
+		reverseProxy: reverseProxy,
+		record:       record,
+	}
+}
+
+// ServeHTTP forwards a request to the API server and records it once the response header is written,
+// thereby a client never observes a response before the request is recorded
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			glog.Errorf("Could not read request body for '%s %s': %s", r.Method, r.URL, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	rw := &responseWriter{
+		ResponseWriter: w,
+		record: func(code int) {
+			p.record(&event.Event{
+				Method:       r.Method,
+				RequestURI:   r.URL.RequestURI(),
+				RequestBody:  body,
+				ContentType:  r.Header.Get("Content-Type"),
+				ResponseCode: code,
+				// the proxy does not authenticate users, only impersonation headers are known
+				ImpersonatedUser:   r.Header.Get("Impersonate-User"),
+				ImpersonatedGroups: r.Header["Impersonate-Group"],
+				UserAgent:          r.UserAgent(),
+				SourceIPs:          sourceIPs(r),
+
+				RequestReceivedTimestamp: received,
+				StageTimestamp:           time.Now(),
+			})
+		},
+	}
+	p.reverseProxy.ServeHTTP(rw, r)
+	// response without any header and body
+	rw.recordOnce(http.StatusOK)
+}
+
+// sourceIPs returns client IPs, X-Forwarded-For addresses go first the same as in k8s audit log
+func sourceIPs(r *http.Request) []string {
+	var ips []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, ip := range strings.Split(h, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ips = append(ips, host)
+	}
+	return ips
+}
+
+// responseWriter records a request when the reverse proxy writes a response code
+type responseWriter struct {
+	http.ResponseWriter
+	record   func(code int)
+	recorded bool
+}
+
+func (w *responseWriter) recordOnce(code int) {
+	if !w.recorded {
+		w.recorded = true
+		w.record(code)
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.recordOnce(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.recordOnce(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush is required by the reverse proxy to stream watch responses
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"path"
+	"runtime"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var petStoreSwaggerPath string
+var expectedOutputPath string
+
+func TestProxy(t *testing.T) {
+	_, p, _, ok := runtime.Caller(0)
+	if !ok {
+		panic("Not possible to get test file path")
+	}
+	petStoreSwaggerPath = path.Join(path.Dir(p), "../../fixtures/test_petstore.json")
+	expectedOutputPath = path.Join(path.Dir(p), "../report/fixtures/test_output.json")
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Proxy Suite")
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/report"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Recording proxy", func() {
+
+	var (
+		apiServer *httptest.Server
+		server    *httptest.Server
+		lock      sync.Mutex
+		events    []*event.Event
+	)
+
+	BeforeEach(func() {
+		events = nil
+		apiServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+		}))
+		target, err := url.Parse(apiServer.URL)
+		Expect(err).NotTo(HaveOccurred())
+
+		server = httptest.NewServer(New(target, nil, func(e *event.Event) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, e)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		apiServer.Close()
+	})
+
+	doRequest := func(method string, uri string, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+uri, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		return resp
+	}
+
+	It("Should forward a request and record it", func() {
+		resp := doRequest(http.MethodPost, "/pets?dryRun=All", `{"name":"bite"}`)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Method).To(Equal(http.MethodPost))
+		Expect(events[0].RequestURI).To(Equal("/pets?dryRun=All"))
+		Expect(string(events[0].RequestBody)).To(Equal(`{"name":"bite"}`))
+		Expect(events[0].ContentType).To(Equal("application/json"))
+		Expect(events[0].ResponseCode).To(Equal(http.StatusOK))
+	})
+
+	It("Should record a response code", func() {
+		resp := doRequest(http.MethodDelete, "/pets/bite", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].ResponseCode).To(Equal(http.StatusNoContent))
+	})
+
+	It("Should calculate the same coverage as from audit log", func() {
+		var expectedCoverage stats.Coverage
+
+		content, err := ioutil.ReadFile(expectedOutputPath)
+		Expect(err).NotTo(HaveOccurred())
+		err = json.Unmarshal(content, &expectedCoverage)
+		Expect(err).NotTo(HaveOccurred())
+
+		doRequest(http.MethodGet, "/pets?limit=100", "")
+		doRequest(http.MethodPost, "/pets", `{"pet":{"name":"bite","kind":{"color":"red"}}}`)
+		doRequest(http.MethodPost, "/pets", `{"pet":{"name":"Run","kind":{"origin":{"region":"Chocolate hills"}}}}`)
+		doRequest(http.MethodPost, "/pets", `{"pet":{"name":"that's not mydog","kind":{"origin":{"country":"Myhouse","region":"behind the fridge"}}}}`)
+		doRequest(http.MethodGet, "/pets/bite", "")
+		doRequest(http.MethodPatch, "/pets/bite", "")
+		doRequest(http.MethodDelete, "/pets/bite", "")
+
+		coverage, err := report.GenerateFromEvents(events, report.Config{SwaggerPath: petStoreSwaggerPath})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage.Percent).To(Equal(expectedCoverage.Percent), "percent should be equal")
+		Expect(coverage.UniqueHits).To(Equal(expectedCoverage.UniqueHits), "uniqueHits should be equal")
+		Expect(coverage.ExpectedUniqueHits).To(Equal(expectedCoverage.ExpectedUniqueHits), "expectedUniqueHits should be equal")
+	})
+})
@@ -0,0 +1,263 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/internal/jsonscan"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// bodyWalker walks a JSON request body token by token and increases hits of trie nodes,
+// values of fields which are not defined in swagger are skipped without being decoded
+type bodyWalker struct {
+	jsonscan.Scanner
+	body *stats.Trie
+	// test is a name of the test which sent the request, empty if unknown
+	test string
+	// directives enables skipping of strategic merge patch directives like $patch or $setElementOrder/containers
+	directives bool
+}
+
+// bodyPatchType returns patch type of the request body, it is empty for regular JSON objects,
+// false is returned if the body is not JSON, for instance protobuf, YAML or server-side apply requests.
+// Audit logs do not record content type, patch requests with an array body are JSON patches,
+// other patch requests are treated as strategic merge patches which are a superset of merge patches
+func bodyPatchType(e *event.Event) (types.PatchType, bool) {
+	mediaType := e.ContentType
+	if t, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = t
+	}
+	switch patch := types.PatchType(mediaType); patch {
+	case types.JSONPatchType, types.MergePatchType, types.StrategicMergePatchType:
+		return patch, true
+	case "":
+		if e.Verb != "patch" && !strings.EqualFold(e.Method, "patch") {
+			return "", true
+		}
+		if body := bytes.TrimSpace(e.RequestBody); len(body) > 0 && body[0] == '[' {
+			return types.JSONPatchType, true
+		}
+		return types.StrategicMergePatchType, true
+	}
+	return "", strings.Contains(mediaType, "json")
+}
+
+// walkBody matches a JSON object or an array of objects to the body trie without building generic maps,
+// fields not defined in swagger are counted for their parent, empty objects are counted as values
+// and arrays are walked element by element, data has to be a valid JSON. Hit nodes are attributed to the test if it is not empty.
+func walkBody(data []byte, body *stats.Trie, test string) error {
+	w := &bodyWalker{Scanner: jsonscan.Scanner{Data: data}, body: body, test: test}
+	w.Space()
+	switch w.Peek() {
+	case '{':
+		w.object(body.Root)
+	case '[':
+		w.Pos++
+		for w.Space(); w.Peek() != ']'; w.Space() {
+			if w.Peek() != '{' {
+				return fmt.Errorf("array element is not an object")
+			}
+			w.object(body.Root)
+			w.Space()
+			if w.Peek() == ',' {
+				w.Pos++
+			}
+		}
+	default:
+		return fmt.Errorf("body is not an object")
+	}
+	return nil
+}
+
+// walkStrategicMergePatch matches a strategic merge patch to the body trie, the patch is a partial object,
+// so it is walked as a body, but directives are skipped as they are not fields
+func walkStrategicMergePatch(data []byte, body *stats.Trie, test string) error {
+	w := &bodyWalker{Scanner: jsonscan.Scanner{Data: data}, body: body, test: test, directives: true}
+	w.Space()
+	if w.Peek() != '{' {
+		return fmt.Errorf("patch is not an object")
+	}
+	w.object(body.Root)
+	return nil
+}
+
+// walkJSONPatch matches operations of a JSON patch to the body trie, fields are found by JSON pointers of operations,
+// array indexes are skipped as array elements share the node of their field, values of added or replaced fields
+// are walked as bodies. Pointers to fields which are not defined in swagger are counted for their deepest known parent
+func walkJSONPatch(data []byte, body *stats.Trie, test string) error {
+	var operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &operations); err != nil {
+		return fmt.Errorf("patch is not a list of operations")
+	}
+	for _, o := range operations {
+		// test operations do not change fields
+		if o.Op == "test" {
+			continue
+		}
+		node, found := pointerNode(body.Root, o.Path)
+		if found && len(o.Value) > 0 && (o.Op == "add" || o.Op == "replace") {
+			w := &bodyWalker{Scanner: jsonscan.Scanner{Data: o.Value}, body: body, test: test}
+			w.value(node)
+		} else if node != body.Root {
+			w := &bodyWalker{body: body, test: test}
+			w.hit(node)
+		}
+	}
+	return nil
+}
+
+// pointerNode returns the trie node of JSON pointer, it returns false and the deepest known node
+// if the pointer goes through fields which are not defined in swagger
+func pointerNode(root *stats.Node, pointer string) (*stats.Node, bool) {
+	if pointer == "" {
+		return root, true
+	}
+	node := root
+	for _, key := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		key = strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+		if n := node.Children[key]; n != nil {
+			node = n
+		} else if !isArrayIndex(key) {
+			return node, false
+		}
+	}
+	return node, true
+}
+
+// isArrayIndex checks if JSON pointer key is an array index, '-' points after the last element
+func isArrayIndex(key string) bool {
+	if key == "-" {
+		return true
+	}
+	for _, c := range key {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// value walks a value which starts at the current position, objects are matched to children of node
+func (w *bodyWalker) value(node *stats.Node) {
+	w.Space()
+	switch w.Peek() {
+	case '{':
+		w.object(node)
+	case '[':
+		w.array(node)
+	default:
+		w.Value()
+		if node != w.body.Root {
+			w.hit(node)
+		}
+	}
+}
+
+// object walks an object which starts at the current position, fields are matched to children of node
+func (w *bodyWalker) object(node *stats.Node) {
+	w.Pos++
+	w.Space()
+	if w.Peek() == '}' {
+		w.Pos++
+		if node != w.body.Root {
+			// include empty objects
+			w.hit(node)
+		}
+		return
+	}
+	for {
+		w.Space()
+		if w.directives && w.directive() {
+			w.Str()
+			w.Space()
+			// skip ':'
+			w.Pos++
+			w.Space()
+			w.Value()
+		} else {
+			n := w.child(node)
+			w.Space()
+			// skip ':'
+			w.Pos++
+			w.Space()
+
+			switch w.Peek() {
+			case '{':
+				w.object(n)
+			case '[':
+				w.array(n)
+			default:
+				w.Value()
+				w.hit(n)
+			}
+		}
+
+		w.Space()
+		if w.Peek() == '}' {
+			w.Pos++
+			return
+		}
+		// skip ','
+		w.Pos++
+	}
+}
+
+// hit increases hits of the node and attributes them to the test
+func (w *bodyWalker) hit(node *stats.Node) {
+	w.body.IncreaseHits(node)
+	if w.test != "" {
+		w.body.AddTest(node, w.test)
+	}
+}
+
+// array walks objects of an array which starts at the current position, other elements are skipped
+func (w *bodyWalker) array(node *stats.Node) {
+	w.Pos++
+	for w.Space(); w.Peek() != ']'; w.Space() {
+		if w.Peek() == '{' {
+			w.object(node)
+		} else {
+			w.Value()
+		}
+		w.Space()
+		if w.Peek() == ',' {
+			w.Pos++
+		}
+	}
+	w.Pos++
+}
+
+// child reads an object key and returns the child node of the key,
+// if child node does not exist then the current node is returned,
+// for instance, having a.b.c.d if 'c' does not have child 'd' then hits are increased for 'c'
+func (w *bodyWalker) child(node *stats.Node) *stats.Node {
+	var n *stats.Node
+	if raw, escaped := w.Str(); escaped {
+		// only keys with escape sequences are decoded
+		var key string
+		json.Unmarshal(raw, &key)
+		n = node.Children[key]
+	} else {
+		n = node.Children[string(raw[1:len(raw)-1])]
+	}
+	if n == nil {
+		return node
+	}
+	return n
+}
+
+// directive checks if the object key at the current position is a strategic merge patch directive
+func (w *bodyWalker) directive() bool {
+	return w.Pos+1 < len(w.Data) && w.Data[w.Pos+1] == '$'
+}
@@ -0,0 +1,211 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/go-openapi/loads"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/analysis"
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+const fooPath = "/apis/example.io/v1/namespaces/{namespace}/foos"
+
+// fooBody is a body of a typical create request
+const fooBody = `{"apiVersion":"example.io/v1","kind":"Foo","metadata":{"name":"foo","namespace":"default",` +
+	`"labels":{"app":"foo","tier":"backend"},"annotations":{"description":"a foo with \"quotes\""}},` +
+	`"spec":{"image":"nginx","replicas":3,"ports":[{"name":"http","port":80},{"name":"https","port":443}],` +
+	`"env":[{"name":"A","value":"1"},{"name":"B","value":null}],"args":["-v",true,1.5e3,[]]}}`
+
+// fooEndpoint builds a coverage of kubernetes fixture and returns endpoint which creates foos
+func fooEndpoint() *stats.Endpoint {
+	document, err := loads.JSONSpec(kubernetesSwaggerPath)
+	if err != nil {
+		panic(err)
+	}
+	coverage, err := analysis.AnalyzeSwagger(document, "", false)
+	if err != nil {
+		panic(err)
+	}
+	return coverage.Endpoints[fooPath]["post"]
+}
+
+// walkGeneric is a reference implementation which decodes the body into generic maps before walking it
+func walkGeneric(data []byte, body *stats.Trie) error {
+	var req interface{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	if r, ok := req.([]interface{}); ok {
+		for _, v := range r {
+			if err := walkGenericParams(v, body, body.Root); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walkGenericParams(req, body, body.Root)
+}
+
+func walkGenericParams(params interface{}, body *stats.Trie, node *stats.Node) error {
+	p, ok := params.(map[string]interface{})
+	if !ok && node.Depth == 0 {
+		return fmt.Errorf("body is not an object")
+	} else if !ok {
+		return nil
+	}
+	if len(p) == 0 && node != body.Root {
+		body.IncreaseHits(node)
+		return nil
+	}
+	for k, v := range p {
+		n := node.GetChild(k)
+		if n == nil {
+			n = node
+		}
+		switch obj := v.(type) {
+		case map[string]interface{}:
+			walkGenericParams(obj, body, n)
+		case []interface{}:
+			for _, v := range obj {
+				walkGenericParams(v, body, n)
+			}
+		default:
+			body.IncreaseHits(n)
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Body walker", func() {
+
+	table.DescribeTable("Should count the same hits as decoding into generic maps", func(body string) {
+		expected, actual := fooEndpoint(), fooEndpoint()
+		Expect(json.Valid([]byte(body))).To(BeTrue())
+		Expect(walkGeneric([]byte(body), expected.Params.Body)).To(Succeed())
+		Expect(walkBody([]byte(body), actual.Params.Body, "")).To(Succeed())
+		Expect(actual.Params.Body).To(Equal(expected.Params.Body))
+	},
+		table.Entry("With a typical object", fooBody),
+		table.Entry("With an array of objects", "["+fooBody+", {}, "+fooBody+"]"),
+		table.Entry("With an empty object", `{}`),
+		table.Entry("With empty nested objects", `{"metadata": {}, "spec": {"ports": [{}]}}`),
+		table.Entry("With unknown fields", `{"unknown": {"a": [1, {"b": {}}]}, "spec": {"unknown": "x", "image": "y"}}`),
+		table.Entry("With white spaces", " \n{ \"spec\" :\t{ \"replicas\" : 1 } , \"kind\" : \"Foo\" }\r\n"),
+		table.Entry("With escaped keys", `{"spec": {"replicas": 1}, "kind\n": "Foo"}`),
+		table.Entry("With nulls and literals", `{"kind": null, "spec": {"replicas": false, "image": true}}`),
+		table.Entry("With nested arrays", `{"spec": {"ports": [[{"port": 1}], {"port": 2}, "x", null]}}`),
+		table.Entry("With strings containing brackets", `{"kind": "{[\"]}", "spec": {"image": "]}"}}`),
+	)
+
+	table.DescribeTable("Should fail for bodies which are not objects", func(body string) {
+		Expect(walkBody([]byte(body), fooEndpoint().Params.Body, "")).NotTo(Succeed())
+	},
+		table.Entry("With a string", `"foo"`),
+		table.Entry("With null", `null`),
+		table.Entry("With an array of numbers", `[1, 2]`),
+	)
+
+	It("Should report invalid JSON", func() {
+		endpoint := fooEndpoint()
+		Expect(matchBodyParams([]byte(`{"spec": {`), "", "", endpoint, NopLogger())).NotTo(Succeed())
+		Expect(matchBodyParams([]byte(`"foo"`), "", "", endpoint, NopLogger())).To(MatchError(ContainSubstring("Invalid requestObject")))
+		Expect(endpoint.Params.Body.UniqueHits).To(BeZero())
+	})
+
+	Context("With patches", func() {
+
+		hits := func(body *stats.Trie, path ...string) int {
+			node := body.Root
+			for _, key := range path {
+				node = node.Children[key]
+			}
+			return node.Hits
+		}
+
+		table.DescribeTable("Should detect patch type", func(e event.Event, expected types.PatchType, json bool) {
+			patch, ok := bodyPatchType(&e)
+			Expect(ok).To(Equal(json))
+			Expect(patch).To(Equal(expected))
+		},
+			table.Entry("With JSON object", event.Event{ContentType: "application/json; charset=utf-8"}, types.PatchType(""), true),
+			table.Entry("With JSON patch", event.Event{ContentType: "application/json-patch+json"}, types.JSONPatchType, true),
+			table.Entry("With merge patch", event.Event{ContentType: "application/merge-patch+json"}, types.MergePatchType, true),
+			table.Entry("With strategic merge patch", event.Event{ContentType: "application/strategic-merge-patch+json"}, types.StrategicMergePatchType, true),
+			table.Entry("With apply patch", event.Event{ContentType: "application/apply-patch+yaml"}, types.PatchType(""), false),
+			table.Entry("With protobuf", event.Event{ContentType: "application/vnd.kubernetes.protobuf"}, types.PatchType(""), false),
+			table.Entry("With audit create", event.Event{Verb: "create", RequestBody: []byte(`[{}]`)}, types.PatchType(""), true),
+			table.Entry("With audit JSON patch", event.Event{Verb: "patch", RequestBody: []byte(` [{"op": "remove", "path": "/spec"}]`)}, types.JSONPatchType, true),
+			table.Entry("With audit object patch", event.Event{Verb: "patch", RequestBody: []byte(`{"spec": {}}`)}, types.StrategicMergePatchType, true),
+		)
+
+		It("Should match JSON patch operations by their pointers", func() {
+			body := fooEndpoint().Params.Body
+			patch := `[{"op": "test", "path": "/kind", "value": "Foo"},
+				{"op": "replace", "path": "/spec/replicas", "value": 2},
+				{"op": "add", "path": "/spec/ports/-", "value": {"name": "grpc", "port": 9090}},
+				{"op": "remove", "path": "/metadata/labels/app~1name"},
+				{"op": "add", "path": "/spec/unknown/image", "value": {"image": "nginx"}}]`
+			Expect(matchBodyParams([]byte(patch), types.JSONPatchType, "", &stats.Endpoint{Params: stats.Params{Body: body}}, NopLogger())).To(Succeed())
+			Expect(hits(body, "kind")).To(BeZero(), "test operation does not change fields")
+			Expect(hits(body, "spec", "replicas")).To(Equal(1))
+			Expect(hits(body, "spec", "ports", "name")).To(Equal(1))
+			Expect(hits(body, "spec", "ports", "port")).To(Equal(1))
+			Expect(hits(body, "metadata", "labels")).To(Equal(1))
+			Expect(hits(body, "spec", "image")).To(BeZero(), "value of unknown field is not walked")
+			Expect(body.Root.Children).NotTo(HaveKey("op"))
+			Expect(body.Root.Children).NotTo(HaveKey("path"))
+		})
+
+		It("Should skip strategic merge patch directives", func() {
+			body := fooEndpoint().Params.Body
+			patch := `{"spec": {"$setElementOrder/ports": [{"name": "http"}], "ports": [{"name": "http", "$patch": "delete"}],
+				"$retainKeys": ["image"], "image": "nginx"}}`
+			Expect(matchBodyParams([]byte(patch), types.StrategicMergePatchType, "", &stats.Endpoint{Params: stats.Params{Body: body}}, NopLogger())).To(Succeed())
+			Expect(hits(body, "spec", "image")).To(Equal(1))
+			Expect(hits(body, "spec", "ports", "name")).To(Equal(1))
+			Expect(hits(body, "spec")).To(Equal(2), "directives are not counted for their parent")
+		})
+
+		It("Should match merge patch as an object", func() {
+			body := fooEndpoint().Params.Body
+			Expect(matchBodyParams([]byte(`{"spec": {"image": null}}`), types.MergePatchType, "", &stats.Endpoint{Params: stats.Params{Body: body}}, NopLogger())).To(Succeed())
+			Expect(hits(body, "spec", "image")).To(Equal(1))
+		})
+	})
+})
+
+func benchmarkBody(b *testing.B, walk func([]byte, *stats.Trie) error) {
+	// suite variables are set by TestCoverage which is not run by benchmarks
+	kubernetesSwaggerPath = "../../fixtures/test_kubernetes.json"
+	endpoint := fooEndpoint()
+	body := []byte(fooBody)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := walk(body, endpoint.Params.Body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBodyGeneric(b *testing.B) {
+	benchmarkBody(b, walkGeneric)
+}
+
+func BenchmarkBodyStreaming(b *testing.B) {
+	benchmarkBody(b, func(data []byte, body *stats.Trie) error {
+		if !json.Valid(data) {
+			b.Fatal("invalid body")
+		}
+		return walkBody(data, body, "")
+	})
+}
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// checkpointVersion is a version of the checkpoint format
+const checkpointVersion = 1
+
+// fingerprintSize is a maximum number of bytes at the beginning of a file used to recognize it
+const fingerprintSize = 1024
+
+// Checkpoint represents state of a collector, it is saved periodically, so processing of large logs can be resumed
+// after a failure without reading them from the beginning
+type Checkpoint struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// Coverage holds hits of requests matched so far, its coverage numbers are not calculated
+	Coverage *stats.Coverage `json:"coverage"`
+	// Files holds read positions of log files by their paths
+	Files map[string]*FileState `json:"files,omitempty"`
+	// Events and Matched are numbers of observed events and events matched to swagger endpoints
+	Events  int `json:"events"`
+	Matched int `json:"matched"`
+	// Window holds events seen by the time window, nil if the window is not set
+	Window *filter.WindowState `json:"window,omitempty"`
+	// AuditIDs are recent audit IDs remembered by dedupe from the oldest one
+	AuditIDs []string `json:"auditIDs,omitempty"`
+	// Test is a name of the test started by the last marker event, empty if attribution is not based on markers
+	Test string `json:"test,omitempty"`
+}
+
+// FileState represents a read position in a log file
+type FileState struct {
+	// Offset is a number of bytes already read, it always points to the beginning of a line
+	Offset int64 `json:"offset"`
+	// Fingerprint is a hash of the first FingerprintSize bytes of the file, it recognizes a file which has been replaced,
+	// for instance by log rotation
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	FingerprintSize int64  `json:"fingerprintSize,omitempty"`
+}
+
+// SaveCheckpoint writes checkpoint to a file, the file is replaced atomically, so a failure does not leave
+// a partially written checkpoint
+func SaveCheckpoint(path string, checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadCheckpoint reads a checkpoint saved by SaveCheckpoint
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("Invalid checkpoint '%s': %s", path, err)
+	}
+	if checkpoint.Version != checkpointVersion {
+		return nil, fmt.Errorf("Invalid checkpoint '%s': unsupported version %d", path, checkpoint.Version)
+	}
+	return checkpoint, nil
+}
+
+// copy returns a copy of the file state, nil for nil state
+func (s *FileState) copy() *FileState {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+// replaced checks if the file is not the one which has been read so far, it has been truncated or replaced by another file
+func (s *FileState) replaced(f *os.File) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < s.Offset {
+		return true, nil
+	}
+	if s.FingerprintSize == 0 {
+		return false, nil
+	}
+	fingerprint, err := fingerprint(f, s.FingerprintSize)
+	if err != nil {
+		return false, err
+	}
+	return fingerprint != s.Fingerprint, nil
+}
+
+// updateFingerprint recognizes the file by its first bytes which have been read so far
+func (s *FileState) updateFingerprint(f *os.File) error {
+	size := s.Offset
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+	if size == s.FingerprintSize {
+		return nil
+	}
+	fingerprint, err := fingerprint(f, size)
+	if err != nil {
+		return err
+	}
+	s.Fingerprint, s.FingerprintSize = fingerprint, size
+	return nil
+}
+
+// fingerprint returns a hash of the first size bytes of the file
+func fingerprint(f *os.File, size int64) (string, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err == io.EOF {
+		// the file is shorter than the fingerprint, it can not be the same file
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
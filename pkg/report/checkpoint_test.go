@@ -0,0 +1,260 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+)
+
+var _ = Describe("Checkpoints", func() {
+
+	var (
+		dir            string
+		logPath        string
+		checkpointPath string
+		lines          [][]byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "rest-coverage")
+		Expect(err).NotTo(HaveOccurred())
+		logPath = filepath.Join(dir, "audit.log")
+		checkpointPath = filepath.Join(dir, "checkpoint.json")
+		Expect(generateAuditLog(logPath, 1000)).To(Succeed())
+		data, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		lines = bytes.SplitAfter(data, []byte("\n"))
+		// the growing log starts with the first half
+		Expect(ioutil.WriteFile(logPath, bytes.Join(lines[:500], nil), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	appendLog := func(data []byte) {
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+		_, err = f.Write(data)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	newOptions := func(windowed bool) Options {
+		options := Options{Config: Config{Dedupe: true, DedupeSize: 20}, Spec: SpecFile(kubernetesSwaggerPath), Logger: NopLogger()}
+		if windowed {
+			var err error
+			options.Window, err = filter.NewWindow("marker:foos/foo", "marker:ns-50/foos/foo@delete", false)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		return options
+	}
+
+	newCollector := func(options Options) *Collector {
+		collector, err := NewCollector(options)
+		Expect(err).NotTo(HaveOccurred())
+		return collector
+	}
+
+	table.DescribeTable("Should resume from a saved checkpoint", func(windowed bool) {
+		expectedCollector := newCollector(newOptions(windowed))
+		Expect(expectedCollector.ObserveBatch(readEvents(bytes.Join(lines, nil)))).To(Succeed())
+		expected := expectedCollector.Coverage()
+
+		collector := newCollector(newOptions(windowed))
+		read, err := collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(500))
+		Expect(SaveCheckpoint(checkpointPath, collector.Checkpoint())).To(Succeed())
+
+		appendLog(bytes.Join(lines[500:], nil))
+		checkpoint, err := LoadCheckpoint(checkpointPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checkpoint.Files[logPath].Offset).To(BeNumerically(">", 0))
+		resumed := newCollector(newOptions(windowed))
+		Expect(resumed.Restore(checkpoint)).To(Succeed())
+		read, err = resumed.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(500))
+
+		// window timestamps loaded from a checkpoint are in another location, reports are the same
+		Expect(json.Marshal(resumed.Coverage())).To(MatchJSON(toJSON(expected)))
+		Expect(resumed.Progress()).To(Equal(expectedCollector.Progress()))
+	},
+		table.Entry("With all requests", false),
+		table.Entry("With time window", true),
+	)
+
+	It("Should leave an incomplete last line when following", func() {
+		collector := newCollector(newOptions(false))
+		line := lines[500]
+		appendLog(line[:len(line)/2])
+		read, err := collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(500))
+
+		appendLog(line[len(line)/2:])
+		read, err = collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(1))
+	})
+
+	It("Should read a truncated or replaced file from the beginning", func() {
+		collector := newCollector(newOptions(false))
+		_, err := collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the same size with different content
+		Expect(ioutil.WriteFile(logPath, bytes.Join(lines[1:501], nil), 0644)).To(Succeed())
+		read, err := collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(500))
+
+		Expect(ioutil.WriteFile(logPath, bytes.Join(lines[:10], nil), 0644)).To(Succeed())
+		read, err = collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(10))
+		Expect(collector.Progress().Events).To(Equal(1010))
+	})
+
+	It("Should keep the position of the last observed event after an error", func() {
+		appendLog([]byte("{invalid\n"))
+		collector := newCollector(newOptions(false))
+		read, err := collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).To(HaveOccurred())
+		Expect(read).To(Equal(500))
+		Expect(collector.Checkpoint().Files[logPath].Offset).To(Equal(int64(len(bytes.Join(lines[:500], nil)))))
+	})
+
+	It("Should follow appended lines until the context is done", func() {
+		collector := newCollector(newOptions(false))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		updates := make(chan int, 10)
+		go func() {
+			done <- collector.FollowFile(ctx, logPath, event.FormatAuditLog, 10*time.Millisecond, func() {
+				updates <- collector.Progress().Events
+			})
+		}()
+
+		Eventually(updates).Should(Receive(Equal(500)))
+		appendLog(bytes.Join(lines[500:], nil))
+		Eventually(func() int { return collector.Progress().Events }).Should(Equal(1000))
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("Should follow a rotated file", func() {
+		collector := newCollector(newOptions(false))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		f, read, err := collector.followFile(ctx, nil, logPath, event.DecodeAuditLine)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { f.Close() }()
+		Expect(read).To(Equal(500))
+
+		// lines written to the rotated file before the writer reopens the path are read as well
+		Expect(os.Rename(logPath, logPath+".1")).To(Succeed())
+		f, read, err = collector.followFile(ctx, f, logPath, event.DecodeAuditLine)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(BeZero())
+		rotated, err := os.OpenFile(logPath+".1", os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = rotated.Write(bytes.Join(lines[500:600], nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated.Close()).To(Succeed())
+		Expect(ioutil.WriteFile(logPath, bytes.Join(lines[600:], nil), 0644)).To(Succeed())
+
+		f, read, err = collector.followFile(ctx, f, logPath, event.DecodeAuditLine)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(500))
+		Expect(collector.Checkpoint().Files[logPath].Offset).To(Equal(int64(len(bytes.Join(lines[600:], nil)))))
+
+		expected := newCollector(newOptions(false))
+		Expect(expected.ObserveBatch(readEvents(bytes.Join(lines, nil)))).To(Succeed())
+		Expect(collector.Coverage()).To(Equal(expected.Coverage()))
+	})
+
+	It("Should follow a truncated file from the beginning", func() {
+		collector := newCollector(newOptions(false))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		f, read, err := collector.followFile(ctx, nil, logPath, event.DecodeAuditLine)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { f.Close() }()
+		Expect(read).To(Equal(500))
+
+		Expect(os.Truncate(logPath, 0)).To(Succeed())
+		appendLog(bytes.Join(lines[500:510], nil))
+		f, read, err = collector.followFile(ctx, f, logPath, event.DecodeAuditLine)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(10))
+		Expect(collector.Progress().Events).To(Equal(510))
+	})
+
+	It("Should count events with the same audit ID once", func() {
+		events := readEvents(bytes.Join(lines[:15], nil))
+		collector := newCollector(Options{Config: Config{Dedupe: true}, Spec: SpecFile(kubernetesSwaggerPath), Logger: NopLogger()})
+		Expect(collector.ObserveBatch(events)).To(Succeed())
+		expected := collector.Coverage()
+		Expect(collector.ObserveBatch(events)).To(Succeed())
+		Expect(collector.Coverage()).To(Equal(expected))
+
+		// IDs are forgotten once there are more recent ones than the dedupe size
+		collector = newCollector(Options{Config: Config{Dedupe: true, DedupeSize: 5}, Spec: SpecFile(kubernetesSwaggerPath), Logger: NopLogger()})
+		Expect(collector.ObserveBatch(events)).To(Succeed())
+		Expect(collector.ObserveBatch(events)).To(Succeed())
+		Expect(collector.Coverage()).NotTo(Equal(expected))
+		Expect(collector.Checkpoint().AuditIDs).To(HaveLen(5))
+	})
+
+	It("Should not restore a checkpoint of another swagger definition or window", func() {
+		collector := newCollector(newOptions(false))
+		_, err := collector.ReadFile(context.Background(), logPath, event.FormatAuditLog, false)
+		Expect(err).NotTo(HaveOccurred())
+		checkpoint := collector.Checkpoint()
+
+		Expect(newCollector(newOptions(true)).Restore(checkpoint)).NotTo(Succeed())
+		petStore := newCollector(Options{Spec: SpecFile(petStoreSwaggerPath), Logger: NopLogger()})
+		Expect(petStore.Restore(checkpoint)).To(MatchError(ContainSubstring("does not match swagger definition")))
+		Expect(collector.Restore(checkpoint)).To(MatchError(ContainSubstring("before any event")))
+	})
+
+	It("Should not load invalid checkpoints", func() {
+		Expect(ioutil.WriteFile(checkpointPath, []byte(`{"version": 2}`), 0644)).To(Succeed())
+		_, err := LoadCheckpoint(checkpointPath)
+		Expect(err).To(MatchError(ContainSubstring("unsupported version")))
+		Expect(ioutil.WriteFile(checkpointPath, []byte(`{`), 0644)).To(Succeed())
+		_, err = LoadCheckpoint(checkpointPath)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func toJSON(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	Expect(err).NotTo(HaveOccurred())
+	return data
+}
+
+// readEvents decodes audit log lines
+func readEvents(data []byte) []*event.Event {
+	var events []*event.Event
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		e, err := event.DecodeAuditLine(line)
+		Expect(err).NotTo(HaveOccurred())
+		events = append(events, e)
+	}
+	return events
+}
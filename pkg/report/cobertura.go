@@ -0,0 +1,205 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// coberturaDocType is a DTD of Cobertura XML reports
+const coberturaDocType = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+// coberturaNonResource is a package name of endpoints which are not k8s resources
+const coberturaNonResource = "non-resource"
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity string            `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int  `xml:"number,attr"`
+	Hits   int  `xml:"hits,attr"`
+	Branch bool `xml:"branch,attr"`
+}
+
+// FprintCobertura writes coverage in Cobertura XML format, API groups are packages, endpoints are classes
+// and each endpoint has a line for the request itself followed by lines for its body and query fields,
+// lines are named by methods, for instance body:spec.replicas. Excluded endpoints and fields are skipped.
+func FprintCobertura(w io.Writer, coverage *stats.Coverage, timestamp time.Time) error {
+	packages := make(map[string]*coberturaPackage)
+	covered, valid := 0, 0
+	for _, e := range coverage.SortedEndpoints() {
+		if e.Excluded || (coverage.DiscoveryExcluded && e.Discovery) {
+			continue
+		}
+		name := coberturaNonResource
+		if e.Resource != "" {
+			name = endpointGroup(e)
+		}
+		p, ok := packages[name]
+		if !ok {
+			p = &coberturaPackage{Name: name, BranchRate: "0", Complexity: "0"}
+			packages[name] = p
+		}
+		class := coberturaEndpoint(e)
+		p.Classes = append(p.Classes, class)
+		for _, l := range class.Lines {
+			valid++
+			if l.Hits > 0 {
+				covered++
+			}
+		}
+	}
+
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := coberturaCoverage{
+		LineRate:     rate(covered, valid),
+		BranchRate:   "0",
+		LinesCovered: covered,
+		LinesValid:   valid,
+		Complexity:   "0",
+		Version:      "rest-coverage",
+		Timestamp:    timestamp.UnixNano() / int64(time.Millisecond),
+	}
+	for _, name := range names {
+		p := packages[name]
+		pCovered, pValid := 0, 0
+		for _, c := range p.Classes {
+			for _, l := range c.Lines {
+				pValid++
+				if l.Hits > 0 {
+					pCovered++
+				}
+			}
+		}
+		p.LineRate = rate(pCovered, pValid)
+		report.Packages = append(report.Packages, *p)
+	}
+
+	if _, err := io.WriteString(w, xml.Header+coberturaDocType+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// coberturaEndpoint maps endpoint into a class, the first line is hit by requests to the endpoint
+func coberturaEndpoint(e *stats.Endpoint) coberturaClass {
+	class := coberturaClass{
+		Name:       strings.ToUpper(e.Method) + " " + e.Path,
+		Filename:   e.Path,
+		BranchRate: "0",
+		Complexity: "0",
+	}
+	request := coberturaLine{Number: 1, Hits: e.Hits}
+	if e.MethodCalled && request.Hits == 0 {
+		request.Hits = 1
+	}
+	class.Methods = append(class.Methods, coberturaMethod{Name: "request", LineRate: lineRate(request.Hits),
+		BranchRate: "0", Complexity: "0", Lines: []coberturaLine{request}})
+	class.Lines = append(class.Lines, request)
+
+	for _, params := range []struct {
+		location string
+		trie     *stats.Trie
+	}{{"body", e.Body}, {"query", e.Query}} {
+		if params.trie == nil {
+			continue
+		}
+		fields := params.trie.Root.Leaves(0)
+		// body defined as an empty object is a leaf itself, it is named by location only
+		if params.trie.Root.IsLeaf {
+			fields = append([]stats.Field{{Node: params.trie.Root}}, fields...)
+		}
+		for _, l := range fields {
+			if l.Node.Excluded {
+				continue
+			}
+			name := params.location
+			if l.Path != "" {
+				name += ":" + l.Path
+			}
+			line := coberturaLine{Number: len(class.Lines) + 1, Hits: l.Node.Hits}
+			class.Methods = append(class.Methods, coberturaMethod{Name: name,
+				LineRate: lineRate(line.Hits), BranchRate: "0", Complexity: "0", Lines: []coberturaLine{line}})
+			class.Lines = append(class.Lines, line)
+		}
+	}
+
+	covered := 0
+	for _, l := range class.Lines {
+		if l.Hits > 0 {
+			covered++
+		}
+	}
+	class.LineRate = rate(covered, len(class.Lines))
+	return class
+}
+
+// rate formats ratio of covered lines, Cobertura expects a number between 0 and 1
+func rate(covered int, valid int) string {
+	if valid == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.4f", float64(covered)/float64(valid))
+}
+
+// lineRate formats rate of a single line, it is covered if it has been hit at least once
+func lineRate(hits int) string {
+	if hits > 0 {
+		return rate(1, 1)
+	}
+	return rate(0, 1)
+}
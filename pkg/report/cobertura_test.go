@@ -0,0 +1,114 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Cobertura reporter", func() {
+
+	timestamp := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	write := func(coverage *stats.Coverage) (string, *coberturaCoverage) {
+		var out bytes.Buffer
+		Expect(FprintCobertura(&out, coverage, timestamp)).To(Succeed())
+		report := &coberturaCoverage{}
+		Expect(xml.Unmarshal(out.Bytes(), report)).To(Succeed())
+		return out.String(), report
+	}
+
+	findClass := func(report *coberturaCoverage, pkg string, name string) *coberturaClass {
+		for _, p := range report.Packages {
+			if p.Name != pkg {
+				continue
+			}
+			for i := range p.Classes {
+				if p.Classes[i].Name == name {
+					return &p.Classes[i]
+				}
+			}
+		}
+		return nil
+	}
+
+	It("Should map API groups to packages and endpoints to classes", func() {
+		coverage, err := Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+
+		output, report := write(coverage)
+		Expect(output).To(HavePrefix(xml.Header + coberturaDocType + "\n<coverage "))
+		Expect(report.Timestamp).To(Equal(timestamp.Unix() * 1000))
+		Expect(report.LinesValid).To(Equal(coverage.ExpectedUniqueHits))
+		Expect(report.LinesCovered).To(Equal(coverage.UniqueHits))
+		Expect(report.LineRate).To(Equal("0.2446"))
+
+		names := []string{}
+		for _, p := range report.Packages {
+			names = append(names, p.Name)
+		}
+		Expect(names).To(ContainElement("example.io"))
+		Expect(sort.StringsAreSorted(names)).To(BeTrue(), "packages should be sorted")
+
+		class := findClass(report, "example.io", "POST /apis/example.io/v1/namespaces/{namespace}/foos")
+		Expect(class).NotTo(BeNil())
+		Expect(class.Filename).To(Equal("/apis/example.io/v1/namespaces/{namespace}/foos"))
+		Expect(class.Methods[0].Name).To(Equal("request"))
+		Expect(class.Lines[0].Number).To(Equal(1))
+		Expect(class.Lines[0].Hits).To(BeNumerically(">", 0))
+		Expect(class.Lines).To(HaveLen(len(class.Methods)))
+
+		methods := map[string]int{}
+		for i, m := range class.Methods {
+			Expect(m.Lines).To(HaveLen(1))
+			Expect(m.Lines[0]).To(Equal(class.Lines[i]))
+			Expect(m.Lines[0].Number).To(Equal(i + 1))
+			methods[m.Name] = m.Lines[0].Hits
+		}
+		Expect(methods).To(HaveKeyWithValue("body:spec.replicas", 1))
+		Expect(methods).To(HaveKeyWithValue("body:status.phase", 0))
+		Expect(methods).To(HaveKeyWithValue("query:dryRun", 0))
+
+		patch := findClass(report, "example.io", "PATCH /apis/example.io/v1/namespaces/{namespace}/foos/{name}")
+		Expect(patch).NotTo(BeNil())
+		Expect(patch.Methods[1].Name).To(Equal("body"))
+	})
+
+	It("Should skip excluded endpoints and fields", func() {
+		exclusions, err := exclusion.Load(kubernetesExclusionsPath)
+		Expect(err).NotTo(HaveOccurred())
+		coverage, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+			SwaggerPath: kubernetesSwaggerPath,
+			Exclusions:  exclusions,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, report := write(coverage)
+		Expect(report.LinesValid).To(Equal(coverage.ExpectedUniqueHits))
+		Expect(findClass(report, "example.io", "DELETE /apis/example.io/v1/namespaces/{namespace}/foos")).To(BeNil())
+
+		class := findClass(report, "example.io", "POST /apis/example.io/v1/namespaces/{namespace}/foos")
+		Expect(class).NotTo(BeNil())
+		for _, m := range class.Methods {
+			Expect(m.Name).NotTo(Equal("body:spec.legacyMode"))
+		}
+	})
+
+	It("Should be deterministic", func() {
+		coverage, err := Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+		output, _ := write(coverage)
+		for i := 0; i < 5; i++ {
+			again, _ := write(coverage)
+			Expect(again).To(Equal(output))
+		}
+	})
+})
@@ -0,0 +1,336 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// Collector matches events incrementally as they come, it is safe for concurrent use,
+// so events can be observed by many goroutines while coverage snapshots are taken, for instance:
+//
+//	collector, err := report.NewCollector(report.Options{Spec: report.SpecFile(swaggerPath)})
+//	...
+//	collector.Observe(e)
+//	coverage := collector.Coverage()
+type Collector struct {
+	lock    sync.Mutex
+	matcher *matcher
+	events  int
+	// files holds read positions of files read by ReadFile
+	files map[string]*FileState
+}
+
+// NewCollector loads swagger definition and builds an empty coverage, options.Sources, options.Progress
+// and options.ProgressInterval are not used by the collector
+func NewCollector(options Options) (*Collector, error) {
+	m, err := newMatcher(options)
+	if err != nil {
+		return nil, err
+	}
+	return &Collector{matcher: m, files: make(map[string]*FileState)}, nil
+}
+
+// Observe matches a single event, normalizers are applied first
+func (c *Collector) Observe(e *event.Event) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events++
+	return c.matcher.observe(e)
+}
+
+// ObserveBatch matches events in the given order, events of the batch are not interleaved with events observed
+// by other goroutines, it stops at the first error
+func (c *Collector) ObserveBatch(events []*event.Event) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, e := range events {
+		c.events++
+		if err := c.matcher.observe(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Coverage returns a snapshot of the coverage of events observed so far, the collector keeps matching
+// following events, the snapshot is not modified by them
+func (c *Collector) Coverage() *stats.Coverage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.matcher.snapshot()
+}
+
+// Progress returns numbers of observed events and events matched to swagger endpoints
+func (c *Collector) Progress() Progress {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return Progress{Events: c.events, Matched: c.matcher.matched}
+}
+
+// Checkpoint returns the current state of the collector, it can be saved by SaveCheckpoint
+// and restored by another collector with the same options
+func (c *Collector) Checkpoint() *Checkpoint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	checkpoint := &Checkpoint{
+		Version:  checkpointVersion,
+		Time:     time.Now(),
+		Coverage: c.matcher.coverage.Copy(),
+		Files:    make(map[string]*FileState, len(c.files)),
+		Events:   c.events,
+		Matched:  c.matcher.matched,
+		AuditIDs: c.matcher.seen.state(),
+	}
+	for path, state := range c.files {
+		checkpoint.Files[path] = state.copy()
+	}
+	if w := c.matcher.options.Window; w != nil {
+		state := w.State()
+		checkpoint.Window = &state
+	}
+	if a := c.matcher.options.Attribution; a != nil {
+		checkpoint.Test = a.State()
+	}
+	return checkpoint
+}
+
+// Restore continues from the checkpoint, it has to be called before any event is observed,
+// the collector has to be built with the same swagger definition and options as the one which created the checkpoint
+func (c *Collector) Restore(checkpoint *Checkpoint) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.events > 0 {
+		return fmt.Errorf("Checkpoint has to be restored before any event is observed")
+	}
+	if checkpoint.Coverage != nil {
+		for path, methods := range checkpoint.Coverage.Endpoints {
+			for method := range methods {
+				if _, ok := c.matcher.coverage.Endpoints[path][method]; !ok {
+					return fmt.Errorf("Checkpoint does not match swagger definition, endpoint '%s %s' not found",
+						strings.ToUpper(method), path)
+				}
+			}
+		}
+	}
+	w := c.matcher.options.Window
+	if (w == nil) != (checkpoint.Window == nil) {
+		return fmt.Errorf("Checkpoint does not match time window options")
+	}
+
+	if checkpoint.Coverage != nil {
+		c.matcher.coverage.Merge(checkpoint.Coverage)
+	}
+	if w != nil {
+		w.Restore(*checkpoint.Window)
+	}
+	if c.matcher.seen != nil {
+		c.matcher.seen.restore(checkpoint.AuditIDs)
+	}
+	if a := c.matcher.options.Attribution; a != nil {
+		a.Restore(checkpoint.Test)
+	}
+	c.events, c.matcher.matched = checkpoint.Events, checkpoint.Matched
+	for path, state := range checkpoint.Files {
+		c.files[path] = state.copy()
+	}
+	return nil
+}
+
+// ReadFile matches events of a line based log file which have not been read yet, the read position is kept
+// by the collector and saved in checkpoints, so the next call reads only appended lines.
+// A file which has been truncated or replaced is read from the beginning. If follow is set, the last line
+// is read only if it ends with a new line character, as it may be still written. It returns a number of read events,
+// a file must not be read by many goroutines at once.
+func (c *Collector) ReadFile(ctx context.Context, path string, format string, follow bool) (int, error) {
+	decode := c.matcher.decoder(format)
+	if decode == nil {
+		return 0, fmt.Errorf("Input format '%s' is not line based", format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.readFile(ctx, f, path, decode, follow)
+}
+
+// readFile reads the opened file from the position kept for the path
+func (c *Collector) readFile(ctx context.Context, f *os.File, path string, decode event.LineDecoder, follow bool) (int, error) {
+	c.lock.Lock()
+	state, ok := c.files[path]
+	if !ok {
+		state = &FileState{}
+		c.files[path] = state
+	}
+	current := state.copy()
+	c.lock.Unlock()
+
+	replaced, err := current.replaced(f)
+	if err != nil {
+		return 0, err
+	}
+	if replaced {
+		c.matcher.logger.Warningf("File '%s' has been truncated or replaced, it is read from the beginning", path)
+		current = &FileState{}
+	}
+	if _, err := f.Seek(current.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(f)
+	read := 0
+	for eof := false; !eof; {
+		if err := ctx.Err(); err != nil {
+			return read, err
+		}
+		// lines are decoded without holding the lock, events of a batch are observed at once with their positions,
+		// so a checkpoint never contains hits of an event without its position
+		var (
+			events []*event.Event
+			ends   []int64
+			failed error
+		)
+		offset := current.Offset
+		for len(events) < pipelineBatchSize && failed == nil {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				eof = true
+				if follow || len(line) == 0 {
+					break
+				}
+			} else if err != nil {
+				failed = err
+				break
+			}
+			if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+				e, err := decode(trimmed)
+				if err != nil {
+					failed = err
+					break
+				}
+				events = append(events, e)
+				ends = append(ends, offset+int64(len(line)))
+			}
+			offset += int64(len(line))
+		}
+		if failed == nil {
+			// empty lines at the end of the batch are read as well
+			ends = append(ends, offset)
+		}
+
+		c.lock.Lock()
+		for i, e := range events {
+			c.events++
+			if err := c.matcher.observe(e); err != nil {
+				failed = err
+				break
+			}
+			current.Offset = ends[i]
+			read++
+		}
+		if failed == nil {
+			current.Offset = ends[len(ends)-1]
+		}
+		err := current.updateFingerprint(f)
+		*state = *current
+		c.lock.Unlock()
+		if failed != nil {
+			return read, failed
+		}
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// FollowFile reads appended lines of the file every interval until the context is done, like tail -F.
+// A rotated file is read to the end before the new file at the path is read from the beginning, a truncated file
+// is read from the beginning as well. Update is called after new events are read, it can be nil.
+// The file does not have to exist when following starts.
+func (c *Collector) FollowFile(ctx context.Context, path string, format string, interval time.Duration, update func()) error {
+	decode := c.matcher.decoder(format)
+	if decode == nil {
+		return fmt.Errorf("Input format '%s' is not line based", format)
+	}
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for {
+		var (
+			read int
+			err  error
+		)
+		f, read, err = c.followFile(ctx, f, path, decode)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if read > 0 && update != nil {
+			update()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// followFile reads appended lines of the followed file, it returns the file which should be followed next,
+// nil if the path does not exist yet
+func (c *Collector) followFile(ctx context.Context, f *os.File, path string, decode event.LineDecoder) (*os.File, int, error) {
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return f, 0, err
+	}
+	if f == nil {
+		if err != nil {
+			return nil, 0, nil
+		}
+		if f, err = os.Open(path); os.IsNotExist(err) {
+			return nil, 0, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		read, err := c.readFile(ctx, f, path, decode, true)
+		return f, read, err
+	}
+
+	// a missing path is still being rotated, lines appended to the old file are read meanwhile
+	rotated := false
+	if info != nil {
+		current, err := f.Stat()
+		if err != nil {
+			return f, 0, err
+		}
+		rotated = !os.SameFile(info, current)
+	}
+	read, err := c.readFile(ctx, f, path, decode, !rotated)
+	if err != nil || !rotated {
+		return f, read, err
+	}
+
+	c.matcher.logger.Infof("File '%s' has been rotated", path)
+	f.Close()
+	c.lock.Lock()
+	c.files[path] = &FileState{}
+	c.lock.Unlock()
+	next, n, err := c.followFile(ctx, nil, path, decode)
+	return next, read + n, err
+}
@@ -0,0 +1,86 @@
+package report
+
+import (
+	"io"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Collector", func() {
+
+	var (
+		expected  *stats.Coverage
+		events    []*event.Event
+		collector *Collector
+	)
+
+	BeforeEach(func() {
+		var err error
+		expected, err = Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+
+		reader, closer, err := event.OpenFile(kubernetesAuditLogPath, event.FormatAuditLog)
+		Expect(err).NotTo(HaveOccurred())
+		defer closer.Close()
+		events = nil
+		for {
+			e, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			events = append(events, e)
+		}
+
+		collector, err = NewCollector(Options{Spec: SpecFile(kubernetesSwaggerPath), Logger: NopLogger()})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Should observe events from many goroutines", func() {
+		var wg sync.WaitGroup
+		for _, e := range events {
+			wg.Add(1)
+			go func(e *event.Event) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				Expect(collector.Observe(e)).To(Succeed())
+				Expect(collector.Coverage().Percent).To(BeNumerically("<=", expected.Percent))
+			}(e)
+		}
+		wg.Wait()
+
+		Expect(collector.Coverage()).To(Equal(expected))
+		Expect(collector.Progress().Events).To(Equal(len(events)))
+	})
+
+	It("Should observe batches", func() {
+		Expect(collector.ObserveBatch(events[:5])).To(Succeed())
+		Expect(collector.ObserveBatch(events[5:])).To(Succeed())
+		Expect(collector.Coverage()).To(Equal(expected))
+	})
+
+	It("Should not modify snapshots by following events", func() {
+		Expect(collector.ObserveBatch(events[:5])).To(Succeed())
+		snapshot := collector.Coverage()
+		percent, uniqueHits := snapshot.Percent, snapshot.UniqueHits
+		Expect(collector.Coverage()).To(Equal(snapshot))
+
+		Expect(collector.ObserveBatch(events[5:])).To(Succeed())
+		Expect(snapshot.Percent).To(Equal(percent))
+		Expect(snapshot.UniqueHits).To(Equal(uniqueHits))
+		Expect(snapshot.Percent).To(BeNumerically("<", expected.Percent))
+		Expect(collector.Coverage()).To(Equal(expected))
+	})
+
+	It("Should keep trie parents within a snapshot", func() {
+		Expect(collector.ObserveBatch(events)).To(Succeed())
+		endpoint := collector.Coverage().Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"]
+		replicas := endpoint.Body.Root.GetChild("spec").GetChild("replicas")
+		Expect(replicas.Parent.Parent).To(BeIdenticalTo(endpoint.Body.Root))
+	})
+})
@@ -0,0 +1,72 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// supported table modes
+const (
+	// TableEndpoints writes one row per path and method
+	TableEndpoints = "endpoints"
+	// TableFields writes one row per body and query field which is a trie leaf
+	TableFields = "fields"
+)
+
+// TableOptions represents settings of CSV and TSV reports
+type TableOptions struct {
+	// Separator separates columns, ',' for CSV and '\t' for TSV
+	Separator rune
+	// Mode is TableEndpoints or TableFields
+	Mode string
+}
+
+// WriteTable writes coverage as CSV or TSV with a header row, rows are sorted by path, method, location and field
+func WriteTable(w io.Writer, coverage *stats.Coverage, options TableOptions) error {
+	writer := csv.NewWriter(w)
+	if options.Separator != 0 {
+		writer.Comma = options.Separator
+	}
+
+	endpoints := coverage.SortedEndpoints()
+	switch options.Mode {
+	case TableEndpoints, "":
+		writer.Write([]string{"path", "method", "called", "uniqueHits", "expectedUniqueHits", "score", "expectedScore", "percent"})
+		for _, e := range endpoints {
+			writer.Write([]string{
+				e.Path,
+				e.Method,
+				strconv.FormatBool(e.MethodCalled),
+				strconv.Itoa(e.UniqueHits),
+				strconv.Itoa(e.ExpectedUniqueHits),
+				formatScore(e.Score),
+				formatScore(e.ExpectedScore),
+				fmt.Sprintf("%.2f", e.Percent),
+			})
+		}
+	case TableFields:
+		writer.Write([]string{"path", "method", "location", "field", "hits"})
+		for _, e := range endpoints {
+			for _, params := range []struct {
+				location string
+				trie     *stats.Trie
+			}{{"body", e.Body}, {"query", e.Query}} {
+				if params.trie == nil {
+					continue
+				}
+				for _, f := range params.trie.Root.Leaves(0) {
+					writer.Write([]string{e.Path, e.Method, params.location, f.Path, strconv.Itoa(f.Node.Hits)})
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("Invalid table mode '%s', expected '%s' or '%s'", options.Mode, TableEndpoints, TableFields)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
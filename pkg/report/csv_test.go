@@ -0,0 +1,73 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Table writer", func() {
+
+	var coverage *stats.Coverage
+
+	BeforeEach(func() {
+		var err error
+		coverage, err = Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	write := func(options TableOptions) string {
+		var out bytes.Buffer
+		Expect(WriteTable(&out, coverage, options)).To(Succeed())
+		return out.String()
+	}
+
+	table.DescribeTable("Should write sorted rows", func(options TableOptions, rows int, expected ...string) {
+		output := write(options)
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		Expect(lines).To(HaveLen(rows + 1))
+		for i, e := range expected {
+			Expect(lines[i]).To(Equal(e))
+		}
+		for i := 0; i < 5; i++ {
+			Expect(write(options)).To(Equal(output), "output should be stable")
+		}
+	},
+		table.Entry("With endpoints in CSV", TableOptions{Separator: ',', Mode: TableEndpoints}, 19,
+			"path,method,called,uniqueHits,expectedUniqueHits,score,expectedScore,percent",
+			"/apis/,get,true,1,1,1,1,100.00",
+			"/apis/example.io/,get,false,0,1,0,1,0.00",
+			"/apis/example.io/v1/,get,true,1,1,1,1,100.00",
+			"/apis/example.io/v1/foos,get,true,1,9,1,9,11.11",
+			"/apis/example.io/v1/namespaces/{namespace}/foos,delete,true,2,13,2,13,15.38",
+			"/apis/example.io/v1/namespaces/{namespace}/foos,get,true,2,9,2,9,22.22"),
+		table.Entry("With endpoints in TSV by default", TableOptions{Separator: '\t'}, 19,
+			"path\tmethod\tcalled\tuniqueHits\texpectedUniqueHits\tscore\texpectedScore\tpercent"),
+		table.Entry("With fields", TableOptions{Separator: ',', Mode: TableFields}, 118,
+			"path,method,location,field,hits",
+			"/apis/example.io/v1/foos,get,query,continue,0"),
+	)
+
+	It("Should write dotted field paths of body leaves", func() {
+		output := write(TableOptions{Separator: '\t', Mode: TableFields})
+		Expect(output).To(ContainSubstring("" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tspec.image\t1\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tspec.legacyMode\t0\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tspec.ports.name\t1\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tspec.ports.port\t1\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tspec.replicas\t1\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tstatus.phase\t0\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tbody\tstatus.readyReplicas\t0\n" +
+			"/apis/example.io/v1/namespaces/{namespace}/foos\tpost\tquery\tdryRun\t0\n"))
+	})
+
+	It("Should not write unknown mode", func() {
+		var out bytes.Buffer
+		Expect(WriteTable(&out, coverage, TableOptions{Mode: "leaves"})).NotTo(Succeed())
+	})
+})
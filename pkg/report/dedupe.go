@@ -0,0 +1,69 @@
+package report
+
+import (
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+// DefaultDedupeSize is a number of recent audit IDs remembered to drop duplicated events
+const DefaultDedupeSize = 100000
+
+// dedupe remembers audit IDs of recent events, audit logs may record a separate event for each stage of a request,
+// for instance RequestReceived and ResponseComplete, stages of a request are logged close to each other,
+// so only a limited number of recent IDs is remembered
+type dedupe struct {
+	seen map[string]bool
+	// ids is a ring buffer of remembered IDs, next is an index of the oldest one once the buffer is full
+	ids  []string
+	next int
+	size int
+}
+
+func newDedupe(size int) *dedupe {
+	if size <= 0 {
+		size = DefaultDedupeSize
+	}
+	return &dedupe{seen: make(map[string]bool), size: size}
+}
+
+// duplicate checks if an event with the same audit ID has been seen and remembers the ID,
+// events without audit ID are never duplicated, nil dedupe does not drop anything
+func (d *dedupe) duplicate(e *event.Event) bool {
+	if d == nil || e.AuditID == "" {
+		return false
+	}
+	if d.seen[e.AuditID] {
+		return true
+	}
+	d.add(e.AuditID)
+	return false
+}
+
+func (d *dedupe) add(id string) {
+	if len(d.ids) < d.size {
+		d.ids = append(d.ids, id)
+	} else {
+		delete(d.seen, d.ids[d.next])
+		d.ids[d.next] = id
+		d.next = (d.next + 1) % d.size
+	}
+	d.seen[id] = true
+}
+
+// state returns remembered IDs from the oldest one, nil for nil dedupe
+func (d *dedupe) state() []string {
+	if d == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(d.ids))
+	ids = append(ids, d.ids[d.next:]...)
+	return append(ids, d.ids[:d.next]...)
+}
+
+// restore remembers IDs in the given order, the oldest ones are forgotten if there are more IDs than the size
+func (d *dedupe) restore(ids []string) {
+	for _, id := range ids {
+		if !d.seen[id] {
+			d.add(id)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// DefaultMarkdownMaxSize is a size limit of GitHub comments
+const DefaultMarkdownMaxSize = 65536
+
+// verbsOrder defines order of verb columns in markdown tables, other verbs are sorted alphabetically after them
+var verbsOrder = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection", "connect"}
+
+// MarkdownOptions represents settings of the markdown summary
+type MarkdownOptions struct {
+	// Baseline is a previous report, the summary shows coverage changes against it if set
+	Baseline *stats.Coverage
+	// MaxSize limits size of the summary in bytes, the least important sections are omitted to fit it, 0 does not limit anything
+	MaxSize int
+}
+
+// FprintMarkdown writes a compact summary in markdown format suitable for pull request comments,
+// sections of the least important API groups are omitted first: uncovered fields of API groups with the lowest
+// uncovered weight, then their tables, so the largest coverage gaps are kept
+func FprintMarkdown(w io.Writer, coverage *stats.Coverage, options MarkdownOptions) error {
+	groups := make([]string, 0, len(coverage.Groups))
+	for g := range coverage.Groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	header := markdownHeader(coverage, options.Baseline)
+	tables := make(map[string]string, len(groups))
+	details := make(map[string]string, len(groups))
+	for _, g := range groups {
+		tables[g] = markdownGroupTable(coverage, g, options.Baseline)
+		if d := markdownUncovered(coverage, g); d != "" {
+			details[g] = d
+		}
+	}
+
+	dropped := droppingOrder(coverage, groups)
+	omitted := 0
+	for options.MaxSize > 0 && len(joinMarkdown(header, groups, tables, details, omitted)) > options.MaxSize {
+		switch {
+		case len(details) > 0:
+			for _, g := range dropped {
+				if _, ok := details[g]; ok {
+					delete(details, g)
+					break
+				}
+			}
+		case len(tables) > 0:
+			for _, g := range dropped {
+				if _, ok := tables[g]; ok {
+					delete(tables, g)
+					break
+				}
+			}
+		default:
+			return fmt.Errorf("Markdown summary does not fit in %d bytes", options.MaxSize)
+		}
+		omitted++
+	}
+
+	_, err := io.WriteString(w, joinMarkdown(header, groups, tables, details, omitted))
+	return err
+}
+
+// droppingOrder sorts API groups from the least important one, by uncovered weight, then by uncovered endpoints,
+// groups of the same importance are dropped from the alphabetically last one
+func droppingOrder(coverage *stats.Coverage, groups []string) []string {
+	order := append([]string{}, groups...)
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := coverage.Groups[order[i]], coverage.Groups[order[j]]
+		if weightA, weightB := a.ExpectedScore-a.Score, b.ExpectedScore-b.Score; weightA != weightB {
+			return weightA < weightB
+		}
+		if uncoveredA, uncoveredB := a.Endpoints-a.CalledEndpoints, b.Endpoints-b.CalledEndpoints; uncoveredA != uncoveredB {
+			return uncoveredA < uncoveredB
+		}
+		return order[i] > order[j]
+	})
+	return order
+}
+
+// joinMarkdown joins header with tables and details of API groups which have not been omitted, in order of groups
+func joinMarkdown(header string, groups []string, tables map[string]string, details map[string]string, omitted int) string {
+	var b strings.Builder
+	b.WriteString(header)
+	for _, g := range groups {
+		b.WriteString(tables[g])
+	}
+	for _, g := range groups {
+		b.WriteString(details[g])
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&b, "_%d sections omitted to fit size limit._\n", omitted)
+	}
+	return b.String()
+}
+
+func markdownHeader(coverage *stats.Coverage, baseline *stats.Coverage) string {
+	var b strings.Builder
+	b.WriteString("## REST API coverage\n\n")
+	fmt.Fprintf(&b, "**Total coverage: %.2f%%**", coverage.Percent)
+	if baseline != nil {
+		fmt.Fprintf(&b, " (%s)", formatDelta(coverage.Percent-baseline.Percent))
+	}
+	called, endpoints := 0, 0
+	for _, g := range coverage.Groups {
+		called += g.CalledEndpoints
+		endpoints += g.Endpoints
+	}
+	fmt.Fprintf(&b, ", %d/%d endpoints called\n\n", called, endpoints)
+	return b.String()
+}
+
+// markdownGroupTable renders coverage per resource of an API group with columns per verb
+func markdownGroupTable(coverage *stats.Coverage, groupName string, baseline *stats.Coverage) string {
+	group := coverage.Groups[groupName]
+	verbs, perVerb := resourceVerbs(coverage, groupName)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s: %.2f%%", groupName, group.Percent)
+	if baseline != nil {
+		if g, ok := baseline.Groups[groupName]; ok {
+			fmt.Fprintf(&b, " (%s)", formatDelta(group.Percent-g.Percent))
+		}
+	}
+	b.WriteString("\n\n| Resource | Coverage |")
+	for _, v := range verbs {
+		fmt.Fprintf(&b, " %s |", v)
+	}
+	b.WriteString("\n|---|---|")
+	for range verbs {
+		b.WriteString("---|")
+	}
+	b.WriteString("\n")
+
+	versions := make([]string, 0, len(group.Versions))
+	for v := range group.Versions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	for _, v := range versions {
+		for _, r := range sortedKeys(group.Versions[v].Resources) {
+			resource := group.Versions[v].Resources[r]
+			fmt.Fprintf(&b, "| %s/%s | %.2f%%", v, r, resource.Percent)
+			if base := baselineResource(baseline, groupName, v, r); base != nil {
+				fmt.Fprintf(&b, " (%s)", formatDelta(resource.Percent-base.Percent))
+			}
+			b.WriteString(" |")
+			for _, verb := range verbs {
+				if s, ok := perVerb[v+"/"+r][verb]; ok {
+					fmt.Fprintf(&b, " %.0f%% |", s.Percent)
+				} else {
+					b.WriteString(" - |")
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// resourceVerbs returns ordered verbs of the API group and coverage per version/resource and verb
+func resourceVerbs(coverage *stats.Coverage, groupName string) ([]string, map[string]map[string]*stats.Summary) {
+	perVerb := make(map[string]map[string]*stats.Summary)
+	seen := make(map[string]bool)
+	for _, methods := range coverage.Endpoints {
+		for _, e := range methods {
+			if e.Resource == "" || e.Excluded || endpointGroup(e) != groupName || e.Action == "" {
+				continue
+			}
+			key := e.Version + "/" + e.Resource
+			if _, ok := perVerb[key]; !ok {
+				perVerb[key] = make(map[string]*stats.Summary)
+			}
+			verb := filter.ActionVerb(e.Action)
+			if _, ok := perVerb[key][verb]; !ok {
+				perVerb[key][verb] = &stats.Summary{}
+			}
+			perVerb[key][verb].Add(e)
+			seen[verb] = true
+		}
+	}
+
+	var verbs []string
+	for _, v := range verbsOrder {
+		if seen[v] {
+			verbs = append(verbs, v)
+			delete(seen, v)
+		}
+	}
+	var others []string
+	for v := range seen {
+		others = append(others, v)
+	}
+	sort.Strings(others)
+	return append(verbs, others...), perVerb
+}
+
+// markdownUncovered renders collapsible list of not called endpoints and uncovered fields of an API group
+func markdownUncovered(coverage *stats.Coverage, groupName string) string {
+	var items []string
+	for _, e := range coverage.SortedEndpoints() {
+		if e.Resource == "" || endpointGroup(e) != groupName || !isUncovered(e) {
+			continue
+		}
+		item := fmt.Sprintf("- `%s %s`", strings.ToUpper(e.Method), e.Path)
+		if !e.MethodCalled {
+			item += " not called"
+		}
+		if fields := uncoveredFields(e.Body.Root); len(fields) > 0 {
+			item += "; body: `" + strings.Join(fields, "`, `") + "`"
+		}
+		if fields := uncoveredFields(e.Query.Root); len(fields) > 0 {
+			item += "; query: `" + strings.Join(fields, "`, `") + "`"
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("<details>\n<summary>Uncovered in %s (%d endpoints)</summary>\n\n%s\n\n</details>\n\n",
+		groupName, len(items), strings.Join(items, "\n"))
+}
+
+// uncoveredFields returns sorted dotted paths of uncovered leaves, excluded fields are skipped
+func uncoveredFields(node *stats.Node) []string {
+	var fields []string
+	for _, l := range node.Leaves(0) {
+		if !l.Node.Excluded && l.Node.Hits == 0 {
+			fields = append(fields, l.Path)
+		}
+	}
+	return fields
+}
+
+func baselineResource(baseline *stats.Coverage, group string, version string, resource string) *stats.Summary {
+	if baseline == nil {
+		return nil
+	}
+	g, ok := baseline.Groups[group]
+	if !ok {
+		return nil
+	}
+	v, ok := g.Versions[version]
+	if !ok {
+		return nil
+	}
+	return v.Resources[resource]
+}
+
+// endpointGroup returns API group name used in aggregations
+func endpointGroup(e *stats.Endpoint) string {
+	if e.Group == "" {
+		return "core"
+	}
+	return e.Group
+}
+
+func formatDelta(delta float64) string {
+	switch {
+	case delta > 0.005:
+		return fmt.Sprintf("+%.2f%%", delta)
+	case delta < -0.005:
+		return fmt.Sprintf("%.2f%%", delta)
+	default:
+		return "no change"
+	}
+}
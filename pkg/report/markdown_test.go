@@ -0,0 +1,98 @@
+package report
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Markdown summary", func() {
+
+	var coverage *stats.Coverage
+
+	BeforeEach(func() {
+		var err error
+		coverage, err = Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	markdown := func(options MarkdownOptions) string {
+		var out bytes.Buffer
+		Expect(FprintMarkdown(&out, coverage, options)).To(Succeed())
+		return out.String()
+	}
+
+	It("Should render total coverage and table per API group", func() {
+		summary := markdown(MarkdownOptions{})
+		Expect(summary).To(HavePrefix("## REST API coverage\n\n**Total coverage: 24.46%**, 10/15 endpoints called\n\n"))
+		Expect(summary).To(ContainSubstring("### example.io: 22.96%\n\n" +
+			"| Resource | Coverage | get | list | watch | create | update | patch | delete | deletecollection | connect |\n" +
+			"|---|---|---|---|---|---|---|---|---|---|---|\n" +
+			"| v1/foos | 22.96% | 25% | 17% | 11% | 56% | 19% | 27% | 14% | 15% | 100% |\n"))
+	})
+
+	It("Should render uncovered fields in collapsible section", func() {
+		summary := markdown(MarkdownOptions{})
+		Expect(summary).To(ContainSubstring("<details>\n<summary>Uncovered in example.io (14 endpoints)</summary>\n\n"))
+		Expect(summary).To(ContainSubstring("- `GET /apis/example.io/v1/namespaces/{namespace}/foos/{name}/status` not called; query: `pretty`\n"))
+		Expect(summary).To(ContainSubstring("body: `metadata.resourceVersion`, `metadata.uid`, `spec.legacyMode`"))
+		Expect(summary).NotTo(ContainSubstring("/console`"), "fully covered endpoint should not be listed")
+	})
+
+	It("Should render delta against baseline report", func() {
+		window, err := filter.NewWindow("", "+30s", false)
+		Expect(err).NotTo(HaveOccurred())
+		baseline, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{SwaggerPath: kubernetesSwaggerPath, Window: window})
+		Expect(err).NotTo(HaveOccurred())
+
+		// baseline is usually a report saved by previous run
+		dir, err := ioutil.TempDir("", "markdown")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		baselinePath := path.Join(dir, "baseline.json")
+		Expect(Dump(baselinePath, baseline)).To(Succeed())
+		baseline, err = Load(baselinePath)
+		Expect(err).NotTo(HaveOccurred())
+
+		summary := markdown(MarkdownOptions{Baseline: baseline})
+		Expect(summary).To(MatchRegexp(`\*\*Total coverage: 24\.46%\*\* \(\+\d+\.\d\d%\)`))
+		Expect(summary).To(MatchRegexp(`### example\.io: 22\.96% \(\+\d+\.\d\d%\)`))
+		Expect(summary).To(MatchRegexp(`\| v1/foos \| 22\.96% \(\+\d+\.\d\d%\) \|`))
+
+		Expect(markdown(MarkdownOptions{Baseline: coverage})).To(ContainSubstring("**Total coverage: 24.46%** (no change)"))
+	})
+
+	It("Should omit the least important sections to fit size limit", func() {
+		full := markdown(MarkdownOptions{})
+		limited := markdown(MarkdownOptions{MaxSize: len(full) - 1})
+		Expect(len(limited)).To(BeNumerically("<", len(full)))
+		Expect(limited).NotTo(ContainSubstring("<details>"))
+		Expect(limited).To(ContainSubstring("### example.io"))
+		Expect(limited).To(HaveSuffix("_1 sections omitted to fit size limit._\n"))
+
+		var out bytes.Buffer
+		Expect(FprintMarkdown(&out, coverage, MarkdownOptions{MaxSize: 10})).NotTo(Succeed())
+	})
+
+	It("Should keep the largest uncovered API group when omitting sections", func() {
+		coverage.Groups["aa.io"] = &stats.Group{
+			Summary:  stats.Summary{Endpoints: 1, CalledEndpoints: 1, Score: 1, ExpectedScore: 1, Percent: 100},
+			Versions: map[string]*stats.Version{},
+		}
+		full := markdown(MarkdownOptions{})
+		Expect(full).To(ContainSubstring("### aa.io"))
+		limited := markdown(MarkdownOptions{MaxSize: len(full) - len(markdownUncovered(coverage, "example.io")) - 1})
+		Expect(limited).NotTo(ContainSubstring("<details>"))
+		Expect(limited).NotTo(ContainSubstring("### aa.io"))
+		Expect(limited).To(ContainSubstring("### example.io"))
+		Expect(limited).To(HaveSuffix("_2 sections omitted to fit size limit._\n"))
+	})
+})
@@ -0,0 +1,454 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/analysis"
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// Config represents settings of coverage calculation, it is embedded by Options which add sources of swagger
+// definition and events
+type Config struct {
+	// SwaggerPath is a path to swagger definition, it is used if Options.Spec is nil
+	SwaggerPath string
+	// Filter limits the report to accepted endpoints and requests, nil does not limit anything
+	Filter *filter.Filter
+	// IgnoreResourceVersion calculates the coverage without versions distinction
+	IgnoreResourceVersion bool
+	// ExcludeDiscovery excludes k8s API discovery endpoints like /apis or /version from the total coverage
+	ExcludeDiscovery bool
+	// Window limits the report to requests within a time window, nil does not limit anything,
+	// each report uses its own copy of the window, so the config can be reused
+	Window *filter.Window
+	// Exclusions removes intentionally not tested endpoints and fields from the total coverage, nil does not exclude anything
+	Exclusions *exclusion.List
+	// ExcludeReadOnly excludes read-only fields from the total coverage, for instance status of k8s objects in bodies
+	// of endpoints other than the status subresource or fields described as read-only like metadata.uid
+	ExcludeReadOnly bool
+	// Dedupe counts events with the same audit ID once, audit logs may record a separate event for each stage of a request
+	Dedupe bool
+	// DedupeSize is a number of recent audit IDs remembered by Dedupe, 0 means DefaultDedupeSize
+	DedupeSize int
+	// Attribution attributes events to tests, tests are recorded for each endpoint and field they hit,
+	// nil keeps tests set by the event source, each report uses its own copy of the attribution,
+	// so the config can be reused
+	Attribution *filter.Attribution
+	// AnalyzeTests adds contribution of tests to the report, it finds redundant tests and a minimal subset of tests
+	// which preserves the coverage of all tests
+	AnalyzeTests bool
+	// Scoring is a model of the coverage numbers, it sets weights of method calls and fields or scores only endpoints
+	// or only fields, the model is recorded in the report metadata, nil means DefaultScoring
+	Scoring *stats.Scoring
+	// Workers is a number of goroutines decoding and matching events, 0 or 1 matches events sequentially,
+	// the coverage does not depend on the number of workers
+	Workers int
+}
+
+// matcher matches requests to stats structure which has been built based on swagger definition
+type matcher struct {
+	coverage *stats.Coverage
+	basePath string
+	// kubernetes enables k8s discovery and non-resource URL rules, it is set if swagger defines k8s API
+	kubernetes bool
+	options    Options
+	logger     Logger
+	// seen drops events with already seen audit IDs, nil if dedupe is disabled
+	seen *dedupe
+	// matched is a number of requests matched to swagger endpoints
+	matched int
+}
+
+// newMatcher loads swagger definition and builds an empty stats structure
+func newMatcher(options Options) (*matcher, error) {
+	if options.Spec == nil && options.SwaggerPath == "" {
+		return nil, fmt.Errorf("Swagger definition is required")
+	}
+	if options.Spec == nil {
+		options.Spec = SpecFile(options.SwaggerPath)
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = glogLogger{}
+	}
+	sDocument, err := options.Spec.Load()
+	if err != nil {
+		return nil, err
+	}
+	coverage, err := analysis.AnalyzeSwaggerWithFilter(sDocument, options.Filter, options.IgnoreResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range options.Exclusions.Apply(coverage) {
+		logger.Warningf("Exclusion '%s' does not match any endpoint or field", r)
+	}
+	if options.ExcludeReadOnly {
+		logger.Infof("Excluded %d read-only fields", exclusion.ExcludeReadOnly(coverage))
+	}
+	options.Window = options.Window.Clone()
+	options.Attribution = options.Attribution.Clone()
+	if options.Scoring == nil {
+		options.Scoring = DefaultScoring()
+	}
+	m := &matcher{
+		coverage:   coverage,
+		basePath:   strings.TrimSuffix(sDocument.BasePath(), "/"),
+		kubernetes: analysis.IsKubernetes(sDocument),
+		options:    options,
+		logger:     logger,
+	}
+	if options.Dedupe {
+		m.seen = newDedupe(options.DedupeSize)
+	}
+	return m, nil
+}
+
+// request is an event resolved to a swagger endpoint or a non-resource URL, resolving does not modify
+// the coverage, so events can be resolved concurrently
+type request struct {
+	event *event.Event
+	query url.Values
+	// test is a name of the test which sent the request, empty if unknown
+	test string
+	// path and method identify swagger endpoint, they are empty if the request is not counted for any endpoint
+	path   string
+	method string
+	// nonResourcePath is set for requests for non-resource URLs which are not defined in swagger
+	nonResourcePath string
+	// diagnostic is logged only if the request is accepted by the window
+	diagnostic string
+	err        error
+}
+
+// match matches a single request to stats structure
+func (m *matcher) match(e *event.Event) error {
+	// window and attribution have to see every event to find markers, duplicates are dropped before
+	if m.seen.duplicate(e) {
+		return nil
+	}
+	test := m.options.Attribution.Test(e)
+	if !m.options.Window.Accept(e) {
+		return nil
+	}
+	r := m.resolve(e)
+	r.test = test
+	if r.err != nil {
+		return r.err
+	}
+	if r.diagnostic != "" {
+		m.logger.Errorf("%s", r.diagnostic)
+	}
+	if m.count(m.coverage, r) {
+		m.matched++
+	}
+	return nil
+}
+
+// resolve finds swagger endpoint of the request, it does not modify the coverage
+func (m *matcher) resolve(e *event.Event) *request {
+	return m.resolveRequest(e, e)
+}
+
+// resolveRequest finds swagger endpoint of the request, requester provides attributes matched by event rules
+// of the filter, it is nil if they are not known yet, then event rules are not applied
+func (m *matcher) resolveRequest(e *event.Event, requester *event.Event) *request {
+	r := &request{event: e}
+	uri, err := url.Parse(e.RequestURI)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.query = uri.Query()
+
+	// swagger paths are relative to base path, k8s API does not define it
+	requestPath := uri.Path
+	if m.basePath != "" && strings.HasPrefix(requestPath, m.basePath+"/") {
+		requestPath = strings.TrimPrefix(requestPath, m.basePath)
+	}
+
+	// use a real HTTP method if known, audit log provides only k8s verbs
+	method := strings.ToLower(e.Method)
+	if method == "" {
+		method = getHTTPMethod(e.Verb)
+	}
+
+	path := m.findPath(e, requestPath)
+	if path == "" {
+		if !m.options.Filter.Match(filter.Request{Path: requestPath, Method: method, Verb: e.Verb, Event: requester}) {
+			return r
+		}
+		if m.isNonResource(e, requestPath) {
+			r.nonResourcePath = requestPath
+		} else if m.options.Filter.Empty() {
+			r.diagnostic = fmt.Sprintf("Path '%s' not found in swagger", uri.Path)
+		}
+		return r
+	}
+
+	if method == "" && e.Verb == "proxy" {
+		method = getConnectMethod(m.coverage.Endpoints[path])
+	}
+	if method == "" {
+		r.diagnostic = fmt.Sprintf("Method not found for '%s' verb and '%s' path", e.Verb, path)
+		return r
+	}
+
+	endpoint, ok := m.coverage.Endpoints[path][method]
+	if !ok {
+		r.diagnostic = fmt.Sprintf("Method '%s' not found for '%s' path", method, path)
+		return r
+	}
+
+	verb := e.Verb
+	if verb == "" {
+		verb = filter.ActionVerb(endpoint.Action)
+	}
+	// k8s serves watch on list path, count it for a dedicated watch endpoint if swagger defines it
+	if endpoint.Action == "list" && isWatch(e.Verb, method, r.query) {
+		verb = "watch"
+		if watchEndpoint, ok := m.coverage.Endpoints[getWatchPath(path)][method]; ok {
+			endpoint = watchEndpoint
+		}
+	}
+
+	// swagger endpoints are already filtered, but requests can be excluded by their own path, verb or requester
+	if !m.options.Filter.Match(filter.Request{Path: requestPath, SwaggerPath: endpoint.Path, Method: method, Verb: verb, Event: requester}) {
+		return r
+	}
+	r.path, r.method = endpoint.Path, endpoint.Method
+	return r
+}
+
+// count increases hits of the resolved request in coverage, it returns true if the request is matched to an endpoint,
+// coverage has to be built from the same swagger definition as the matcher coverage
+func (m *matcher) count(coverage *stats.Coverage, r *request) bool {
+	if r.nonResourcePath != "" {
+		addNonResourceURL(coverage, r.event, r.nonResourcePath, m.kubernetes && analysis.IsDiscoveryPath(r.nonResourcePath))
+		return false
+	}
+	if r.path == "" {
+		return false
+	}
+
+	endpoint := coverage.Endpoints[r.path][r.method]
+	endpoint.MethodCalled = true
+	endpoint.Hits++
+	if r.test != "" {
+		endpoint.AddTest(r.test)
+	}
+	matchQueryParams(r.query, r.test, endpoint, m.logger)
+	// only JSON bodies and JSON patches are supported, protobuf or YAML requests are skipped
+	if patch, ok := bodyPatchType(r.event); ok {
+		if err := matchBodyParams(r.event.RequestBody, patch, r.test, endpoint, m.logger); err != nil {
+			m.logger.Errorf("%s", err)
+		}
+	}
+	return true
+}
+
+// matchSource matches all events of the source sequentially, normalizers are applied first, read is called for each event
+func (m *matcher) matchSource(ctx context.Context, source EventSource, read func()) error {
+	reader, closer, err := m.open(source)
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		e, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		read()
+
+		if err := m.observe(e); err != nil {
+			return err
+		}
+	}
+}
+
+// open opens the source, lines of files are decoded by the matcher decoder, so the reader returns nil
+// for skipped events
+func (m *matcher) open(source EventSource) (event.Reader, io.Closer, error) {
+	s, ok := source.(lineFileSource)
+	if !ok {
+		return source.Open()
+	}
+	lines, _, closer, err := s.OpenLines()
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodedLines{lines: lines, decode: m.decoder(s.format)}, closer, nil
+}
+
+// observe applies normalizers and matches the normalized event, nil events skipped by decoders are not matched
+func (m *matcher) observe(e *event.Event) error {
+	if e == nil {
+		return nil
+	}
+	if e = m.normalize(e); e == nil {
+		return nil
+	}
+	return m.match(e)
+}
+
+// normalize applies normalizers in order, it returns nil if the event is dropped
+func (m *matcher) normalize(e *event.Event) *event.Event {
+	for _, normalize := range m.options.Normalizers {
+		if e = normalize(e); e == nil {
+			return nil
+		}
+	}
+	return e
+}
+
+// decoder returns a line decoder of the format, if the filter limits requests, audit events which can not be counted
+// are skipped by their request attributes before bodies are decoded, events are not skipped if the window, attribution,
+// dedupe or normalizers are used, as they have to see every event
+func (m *matcher) decoder(format string) event.LineDecoder {
+	if format != event.FormatAuditLog || m.options.Filter.Empty() || m.options.Window != nil ||
+		m.options.Attribution != nil || m.seen != nil || len(m.options.Normalizers) > 0 {
+		return event.Decoder(format)
+	}
+	return event.AuditLineDecoder(m.acceptsAudit)
+}
+
+// acceptsAudit checks if an audit event can be counted by its request attributes, requester attributes
+// are not decoded yet, so events rejected only by event rules of the filter are accepted
+func (m *matcher) acceptsAudit(verb string, requestURI string, objectRef *auditv1.ObjectReference) bool {
+	r := m.resolveRequest(&event.Event{Verb: verb, RequestURI: requestURI, ObjectRef: objectRef}, nil)
+	return r.err != nil || r.diagnostic != "" || r.path != "" || r.nonResourcePath != ""
+}
+
+// calculate provides the coverage numbers and the report metadata once all requests are matched
+func (m *matcher) calculate() *stats.Coverage {
+	return m.summarize(m.coverage)
+}
+
+// snapshot provides the coverage numbers and the report metadata of requests matched so far,
+// matching can continue as the snapshot is a copy
+func (m *matcher) snapshot() *stats.Coverage {
+	return m.summarize(m.coverage.Copy())
+}
+
+// summarize calculates the coverage numbers and adds the report metadata to coverage
+func (m *matcher) summarize(coverage *stats.Coverage) *stats.Coverage {
+	calculateCoverage(coverage, m.options.ExcludeDiscovery, m.options.Scoring)
+	coverage.Exclusions = m.options.Exclusions.Summarize(coverage)
+	scoring := *m.options.Scoring
+	coverage.Metadata = &stats.Metadata{Scoring: &scoring}
+	if w := m.options.Window; w != nil {
+		window := &stats.TimeWindow{Requests: w.Accepted()}
+		if w.Start != nil {
+			window.StartBound = w.Start.String()
+		}
+		if w.End != nil {
+			window.EndBound = w.End.String()
+		}
+		start, end := w.Effective()
+		if !start.IsZero() {
+			window.Start = &start
+		}
+		if !end.IsZero() {
+			window.End = &end
+		}
+		coverage.Metadata.Window = window
+	}
+	// tests are analyzed by the scoring model of the metadata
+	if m.options.AnalyzeTests {
+		coverage.Redundancy = AnalyzeTests(coverage)
+	}
+	return coverage
+}
+
+// findPath returns swagger path for a request path, empty if not found, for k8s API
+// non-resource and discovery requests are matched only to swagger paths without path params
+func (m *matcher) findPath(e *event.Event, requestPath string) string {
+	if (e.Verb != "" && e.ObjectRef == nil) || (m.kubernetes && analysis.IsDiscoveryPath(requestPath)) {
+		return findStaticSwaggerPath(m.coverage, getSwaggerPath(requestPath, nil, m.options.IgnoreResourceVersion))
+	}
+
+	path := getSwaggerPath(requestPath, e.ObjectRef, m.options.IgnoreResourceVersion)
+	if _, ok := m.coverage.Endpoints[path]; ok {
+		return path
+	}
+	return findSwaggerPath(m.coverage, path)
+}
+
+// addNonResourceURL reports a request for non-resource URL which is not defined in swagger,
+// discovery marks k8s API discovery URLs
+func addNonResourceURL(coverage *stats.Coverage, e *event.Event, requestPath string, discovery bool) {
+	if coverage.NonResourceURLs == nil {
+		coverage.NonResourceURLs = make(map[string]*stats.NonResourceURL)
+	}
+	u, ok := coverage.NonResourceURLs[requestPath]
+	if !ok {
+		u = &stats.NonResourceURL{
+			Path:      requestPath,
+			Methods:   make(map[string]int),
+			Discovery: discovery,
+		}
+		coverage.NonResourceURLs[requestPath] = u
+	}
+
+	method := strings.ToLower(e.Method)
+	if method == "" {
+		method = e.Verb
+	}
+	u.Hits++
+	u.Methods[method]++
+}
+
+// isNonResource checks if request is not for a k8s resource, audit log provides object reference only for resource requests,
+// for other events the decision is based on the path, k8s resources are served under /api/{version} and /apis/{group}/{version},
+// requests not found in swagger of other APIs are never non-resource requests
+func (m *matcher) isNonResource(e *event.Event, path string) bool {
+	if e.Verb != "" {
+		return e.ObjectRef == nil
+	}
+	if !m.kubernetes {
+		return false
+	}
+	s := strings.Split(strings.Trim(path, "/"), "/")
+	switch s[0] {
+	case "api":
+		return len(s) <= 2
+	case "apis":
+		return len(s) <= 3
+	default:
+		return true
+	}
+}
+
+// findStaticSwaggerPath finds swagger path without path params, trailing slash is ignored
+func findStaticSwaggerPath(coverage *stats.Coverage, path string) string {
+	if _, ok := coverage.Endpoints[path]; ok {
+		return path
+	}
+	path = strings.Trim(path, "/")
+	for swaggerPath := range coverage.Endpoints {
+		if strings.Trim(swaggerPath, "/") == path {
+			return swaggerPath
+		}
+	}
+	return ""
+}
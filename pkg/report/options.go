@@ -0,0 +1,208 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-openapi/loads"
+	"github.com/golang/glog"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+)
+
+// DefaultProgressInterval is a number of events between progress callbacks
+const DefaultProgressInterval = 1000
+
+// Options represents settings of the report generation used by GenerateContext
+type Options struct {
+	Config
+	// Spec provides swagger definition, nil loads it from Config.SwaggerPath
+	Spec SpecSource
+	// Sources provide events, they are read one after another in the given order
+	Sources []EventSource
+	// Normalizers modify events before they are matched, they are applied in the given order,
+	// they are called concurrently if Workers > 1
+	Normalizers []Normalizer
+	// Logger receives diagnostics like requests not found in swagger, nil logs with glog,
+	// its calls are serialized if Workers > 1, so it does not have to be safe for concurrent use
+	Logger Logger
+	// Progress is called every ProgressInterval events and once all events of a source are read, nil disables it
+	Progress func(Progress)
+	// ProgressInterval is a number of events between progress callbacks, 0 means DefaultProgressInterval
+	ProgressInterval int
+}
+
+// Progress represents state of the report generation
+type Progress struct {
+	// Source is an index of the source being read
+	Source int
+	// Sources is a number of all sources
+	Sources int
+	// Events is a number of events read so far from all sources
+	Events int
+	// Matched is a number of events matched to swagger endpoints so far
+	Matched int
+	// Done is set once all events of the source are read
+	Done bool
+}
+
+// Logger receives diagnostics of the report generation
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// glogLogger is a default logger
+type glogLogger struct{}
+
+func (glogLogger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (glogLogger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (glogLogger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
+
+// nopLogger drops all diagnostics
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})    {}
+func (nopLogger) Warningf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{})   {}
+
+// NopLogger returns a logger which drops all diagnostics
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+// SpecSource provides swagger definition
+type SpecSource interface {
+	Load() (*loads.Document, error)
+}
+
+// SpecFunc adapts a function to SpecSource
+type SpecFunc func() (*loads.Document, error)
+
+// Load calls the function
+func (f SpecFunc) Load() (*loads.Document, error) {
+	return f()
+}
+
+// SpecFile loads swagger definition from a JSON file or URL
+func SpecFile(path string) SpecSource {
+	return SpecFunc(func() (*loads.Document, error) {
+		return loads.JSONSpec(path)
+	})
+}
+
+// SpecBytes loads swagger definition from JSON data
+func SpecBytes(data []byte) SpecSource {
+	return SpecFunc(func() (*loads.Document, error) {
+		return loads.Analyzed(json.RawMessage(data), "")
+	})
+}
+
+// SpecReader loads swagger definition in JSON format from reader
+func SpecReader(r io.Reader) SpecSource {
+	return SpecFunc(func() (*loads.Document, error) {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return loads.Analyzed(json.RawMessage(data), "")
+	})
+}
+
+// SpecDocument uses already loaded swagger definition
+func SpecDocument(document *loads.Document) SpecSource {
+	return SpecFunc(func() (*loads.Document, error) {
+		return document, nil
+	})
+}
+
+// EventSource provides events, returned closer is closed once all events are read, it can be nil
+type EventSource interface {
+	Open() (event.Reader, io.Closer, error)
+}
+
+// EventSourceFunc adapts a function to EventSource
+type EventSourceFunc func() (event.Reader, io.Closer, error)
+
+// Open calls the function
+func (f EventSourceFunc) Open() (event.Reader, io.Closer, error) {
+	return f()
+}
+
+// FileSource reads events from a file in the given format, see event.OpenFile for supported formats,
+// lines of line based formats are decoded concurrently if Options.Workers > 1
+func FileSource(path string, format string) EventSource {
+	if event.Decoder(format) != nil {
+		return lineFileSource{path: path, format: format}
+	}
+	return EventSourceFunc(func() (event.Reader, io.Closer, error) {
+		return event.OpenFile(path, format)
+	})
+}
+
+// ReaderSource reads events from reader
+func ReaderSource(reader event.Reader) EventSource {
+	return EventSourceFunc(func() (event.Reader, io.Closer, error) {
+		return reader, nil, nil
+	})
+}
+
+// EventsSource provides already collected events
+func EventsSource(events []*event.Event) EventSource {
+	return EventSourceFunc(func() (event.Reader, io.Closer, error) {
+		return &sliceReader{events: events}, nil, nil
+	})
+}
+
+// sliceReader provides events from a slice
+type sliceReader struct {
+	events []*event.Event
+	next   int
+}
+
+func (r *sliceReader) Next() (*event.Event, error) {
+	if r.next >= len(r.events) {
+		return nil, io.EOF
+	}
+	e := r.events[r.next]
+	r.next++
+	return e, nil
+}
+
+// Normalizer modifies an event before it is matched, nil drops the event
+type Normalizer func(e *event.Event) *event.Event
+
+// StripPathPrefix removes a prefix from request URIs, for instance a prefix added by a reverse proxy,
+// events without the prefix are not modified
+func StripPathPrefix(prefix string) Normalizer {
+	prefix = "/" + strings.Trim(prefix, "/")
+	return func(e *event.Event) *event.Event {
+		rest := strings.TrimPrefix(e.RequestURI, prefix)
+		if rest == e.RequestURI || (rest != "" && rest[0] != '/' && rest[0] != '?') {
+			return e
+		}
+		if rest == "" || rest[0] == '?' {
+			rest = "/" + rest
+		}
+		normalized := *e
+		normalized.RequestURI = rest
+		return &normalized
+	}
+}
+
+// Options converts config into options with swagger definition loaded from config.SwaggerPath
+func (c Config) Options(sources ...EventSource) Options {
+	return Options{Config: c, Sources: sources}
+}
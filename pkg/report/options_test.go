@@ -0,0 +1,184 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// recordingLogger keeps all diagnostics
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, "I "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "W "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "E "+fmt.Sprintf(format, args...))
+}
+
+var _ = Describe("Library API", func() {
+
+	var (
+		expected *stats.Coverage
+		logger   *recordingLogger
+	)
+
+	BeforeEach(func() {
+		var err error
+		expected, err = Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+		logger = &recordingLogger{}
+	})
+
+	createFoo := func(uri string) *event.Event {
+		return &event.Event{
+			Method:      "POST",
+			RequestURI:  uri,
+			ContentType: "application/json",
+			RequestBody: []byte(`{"spec": {"replicas": 1}}`),
+		}
+	}
+
+	It("Should generate the same report as Generate", func() {
+		data, err := ioutil.ReadFile(kubernetesSwaggerPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		coverage, err := GenerateContext(context.Background(), Options{
+			Spec:    SpecBytes(data),
+			Sources: []EventSource{FileSource(kubernetesAuditLogPath, event.FormatAuditLog)},
+			Logger:  NopLogger(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage).To(Equal(expected))
+	})
+
+	It("Should read all sources", func() {
+		coverage, err := GenerateContext(context.Background(), Options{
+			Spec: SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{
+				FileSource(kubernetesAuditLogPath, event.FormatAuditLog),
+				FileSource(kubernetesAuditLogPath, event.FormatAuditLog),
+			},
+			Logger: NopLogger(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage.Percent).To(Equal(expected.Percent))
+		for path, methods := range coverage.Endpoints {
+			for method, e := range methods {
+				Expect(e.Hits).To(Equal(2*expected.Endpoints[path][method].Hits), path+" "+method)
+			}
+		}
+	})
+
+	It("Should report progress", func() {
+		var progress []Progress
+		_, err := GenerateContext(context.Background(), Options{
+			Spec: SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{
+				FileSource(kubernetesAuditLogPath, event.FormatAuditLog),
+				EventsSource([]*event.Event{createFoo("/apis/example.io/v1/namespaces/default/foos")}),
+			},
+			Logger:           NopLogger(),
+			Progress:         func(p Progress) { progress = append(progress, p) },
+			ProgressInterval: 10,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(progress).To(HaveLen(3))
+		Expect(progress[0]).To(Equal(Progress{Source: 0, Sources: 2, Events: 10, Matched: progress[0].Matched}))
+		Expect(progress[1].Done).To(BeTrue())
+		Expect(progress[1].Events).To(Equal(15))
+		Expect(progress[2]).To(Equal(Progress{Source: 1, Sources: 2, Events: 16, Matched: progress[1].Matched + 1, Done: true}))
+	})
+
+	It("Should stop when context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := GenerateContext(ctx, Options{
+			Spec:    SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{FileSource(kubernetesAuditLogPath, event.FormatAuditLog)},
+			Logger:  NopLogger(),
+		})
+		Expect(err).To(Equal(context.Canceled))
+	})
+
+	It("Should stop in the middle of a source when context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := 0
+		_, err := GenerateContext(ctx, Options{
+			Spec:    SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{FileSource(kubernetesAuditLogPath, event.FormatAuditLog)},
+			Logger:  NopLogger(),
+			Progress: func(p Progress) {
+				events = p.Events
+				cancel()
+			},
+			ProgressInterval: 5,
+		})
+		Expect(err).To(Equal(context.Canceled))
+		Expect(events).To(Equal(5))
+	})
+
+	It("Should send diagnostics to logger", func() {
+		_, err := GenerateContext(context.Background(), Options{
+			Spec:    SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{EventsSource([]*event.Event{createFoo("/apis/other.io/v1/namespaces/default/bars")})},
+			Logger:  logger,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logger.messages).To(ContainElement("E Path '/apis/other.io/v1/namespaces/default/bars' not found in swagger"))
+	})
+
+	It("Should normalize events before matching", func() {
+		dropped := func(e *event.Event) *event.Event {
+			if e.Method == "DELETE" {
+				return nil
+			}
+			return e
+		}
+		coverage, err := GenerateContext(context.Background(), Options{
+			Spec: SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{EventsSource([]*event.Event{
+				createFoo("/cluster-a/apis/example.io/v1/namespaces/default/foos?dryRun=All"),
+				{Method: "DELETE", RequestURI: "/cluster-a/apis/example.io/v1/namespaces/default/foos/foo"},
+			})},
+			Normalizers: []Normalizer{StripPathPrefix("/cluster-a/"), dropped},
+			Logger:      logger,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logger.messages).NotTo(ContainElement(HavePrefix("E ")))
+
+		create := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"]
+		Expect(create.Hits).To(Equal(1))
+		Expect(create.Query.Root.GetChild("dryRun").Hits).To(Equal(1))
+		Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}"]["delete"].MethodCalled).To(BeFalse())
+	})
+
+	It("Should require swagger definition", func() {
+		_, err := GenerateContext(context.Background(), Options{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should load swagger definition from config path", func() {
+		coverage, err := GenerateContext(context.Background(), Options{
+			Config:  Config{SwaggerPath: kubernetesSwaggerPath},
+			Sources: []EventSource{FileSource(kubernetesAuditLogPath, event.FormatAuditLog)},
+			Logger:  NopLogger(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage).To(Equal(expected))
+	})
+})
@@ -0,0 +1,373 @@
+package report
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"sync"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// pipelineBatchSize is a number of lines or events processed by a worker at once
+const pipelineBatchSize = 256
+
+// LineSource is an EventSource which provides raw lines, lines are decoded concurrently if Options.Workers > 1
+type LineSource interface {
+	EventSource
+	OpenLines() (event.LineReader, event.LineDecoder, io.Closer, error)
+}
+
+// lineFileSource reads a file in a line based format
+type lineFileSource struct {
+	path   string
+	format string
+}
+
+func (s lineFileSource) Open() (event.Reader, io.Closer, error) {
+	return event.OpenFile(s.path, s.format)
+}
+
+func (s lineFileSource) OpenLines() (event.LineReader, event.LineDecoder, io.Closer, error) {
+	return event.OpenLines(s.path, s.format)
+}
+
+// decodedLines reads events of decoded lines, events skipped by the decoder are nil
+type decodedLines struct {
+	lines  event.LineReader
+	decode event.LineDecoder
+}
+
+func (r decodedLines) Next() (*event.Event, error) {
+	line, err := r.lines.Next()
+	if err != nil {
+		return nil, err
+	}
+	return r.decode(line)
+}
+
+// batch is a part of a source processed by a worker, batches are numbered in order of the source
+type batch struct {
+	seq int
+	// lines are decoded by decode into events, lines are not used if decode is nil
+	lines  [][]byte
+	decode event.LineDecoder
+	events []*event.Event
+	// requests are resolved events, nil for events skipped by the decoder or dropped by normalizers
+	requests []*request
+	// err is an error of reading or decoding which happened after all events of the batch
+	err error
+}
+
+func (b *batch) size() int {
+	return len(b.lines) + len(b.events)
+}
+
+// shard counts requests of a subset of endpoints, endpoints are shared with the matcher coverage
+// as each endpoint is counted by a single shard, non-resource URLs are counted in a map of the shard
+type shard struct {
+	coverage *stats.Coverage
+}
+
+// syncLogger serializes calls of a logger which is not required to be safe for concurrent use
+type syncLogger struct {
+	mu     sync.Mutex
+	logger Logger
+}
+
+func (l *syncLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Infof(format, args...)
+}
+
+func (l *syncLogger) Warningf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Warningf(format, args...)
+}
+
+func (l *syncLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Errorf(format, args...)
+}
+
+// pipeline matches events concurrently, events are decoded and resolved to swagger endpoints by workers,
+// then they are passed in order of the source through the window and dispatched to shards by endpoints,
+// so requests of an endpoint are always counted by the same shard in order of the source, non-resource URLs of shards
+// are merged once all sources are read, therefore the result does not depend on the number of workers
+type pipeline struct {
+	matcher *matcher
+	workers int
+	shards  []*shard
+}
+
+// newPipeline initializes pipeline, calls of the matcher logger are serialized as shards count requests concurrently,
+// glog is safe for concurrent use and it reports callers by depth, so it is not wrapped
+func newPipeline(m *matcher, workers int) *pipeline {
+	if _, ok := m.logger.(glogLogger); !ok {
+		m.logger = &syncLogger{logger: m.logger}
+	}
+	p := &pipeline{matcher: m, workers: workers}
+	for i := 0; i < workers; i++ {
+		p.shards = append(p.shards, &shard{coverage: &stats.Coverage{Endpoints: m.coverage.Endpoints}})
+	}
+	return p
+}
+
+// run matches all events of the source, read is called in order of the source for each event
+func (p *pipeline) run(ctx context.Context, source EventSource, read func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}
+
+	var wg sync.WaitGroup
+	batches := make(chan *batch, p.workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(batches)
+		if err := p.read(ctx, source, batches); err != nil {
+			fail(err)
+		}
+	}()
+
+	resolved := make(chan *batch, p.workers)
+	var workers sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for b := range batches {
+				p.resolve(b)
+				select {
+				case resolved <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(resolved)
+	}()
+
+	queues := make([]chan []*request, len(p.shards))
+	for i, s := range p.shards {
+		queues[i] = make(chan []*request, 1)
+		wg.Add(1)
+		go func(s *shard, queue <-chan []*request) {
+			defer wg.Done()
+			for requests := range queue {
+				for _, r := range requests {
+					p.matcher.count(s.coverage, r)
+				}
+			}
+		}(s, queues[i])
+	}
+
+	if err := p.dispatch(ctx, resolved, queues, read); err != nil {
+		fail(err)
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	// batches of stopped workers are dropped
+	for range resolved {
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+// read splits the source into batches of lines or events, it stops at the first error which is passed with the last batch
+func (p *pipeline) read(ctx context.Context, source EventSource, batches chan<- *batch) error {
+	var (
+		next   func(b *batch) error
+		decode event.LineDecoder
+		closer io.Closer
+	)
+	if s, ok := source.(LineSource); ok {
+		lines, d, c, err := s.OpenLines()
+		if err != nil {
+			return err
+		}
+		decode, closer = d, c
+		// files are decoded by the matcher decoder which skips events that can not be counted
+		if f, ok := source.(lineFileSource); ok {
+			decode = p.matcher.decoder(f.format)
+		}
+		next = func(b *batch) error {
+			line, err := lines.Next()
+			if err == nil {
+				b.lines = append(b.lines, line)
+			}
+			return err
+		}
+	} else {
+		reader, c, err := source.Open()
+		if err != nil {
+			return err
+		}
+		closer = c
+		next = func(b *batch) error {
+			e, err := reader.Next()
+			if err == nil {
+				b.events = append(b.events, e)
+			}
+			return err
+		}
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	for seq := 0; ; seq++ {
+		b := &batch{seq: seq, decode: decode}
+		eof := false
+		for b.size() < pipelineBatchSize {
+			if err := next(b); err == io.EOF {
+				eof = true
+				break
+			} else if err != nil {
+				b.err = err
+				break
+			}
+		}
+		if b.size() > 0 || b.err != nil {
+			select {
+			case batches <- b:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if eof || b.err != nil {
+			return nil
+		}
+	}
+}
+
+// resolve decodes lines of the batch, applies normalizers and resolves events to swagger endpoints,
+// decoding stops at the first invalid line
+func (p *pipeline) resolve(b *batch) {
+	if b.decode != nil {
+		for _, line := range b.lines {
+			e, err := b.decode(line)
+			if err != nil {
+				b.err = err
+				break
+			}
+			b.events = append(b.events, e)
+		}
+		b.lines = nil
+	}
+	b.requests = make([]*request, len(b.events))
+	for i, e := range b.events {
+		if e == nil {
+			continue
+		}
+		if e = p.matcher.normalize(e); e != nil {
+			b.requests[i] = p.matcher.resolve(e)
+		}
+	}
+}
+
+// dispatch passes resolved batches in order of the source through the window and sends requests to shards
+func (p *pipeline) dispatch(ctx context.Context, resolved <-chan *batch, queues []chan []*request, read func()) error {
+	pending := make(map[int]*batch)
+	next := 0
+	for b := range resolved {
+		pending[b.seq] = b
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if err := p.pass(ctx, b, queues, read); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *pipeline) pass(ctx context.Context, b *batch, queues []chan []*request, read func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m := p.matcher
+	requests := make([][]*request, len(queues))
+	for _, r := range b.requests {
+		read()
+		// window and attribution have to see every event in order to find markers, duplicates are dropped before
+		if r == nil || m.seen.duplicate(r.event) {
+			continue
+		}
+		r.test = m.options.Attribution.Test(r.event)
+		if !m.options.Window.Accept(r.event) {
+			continue
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if r.diagnostic != "" {
+			m.logger.Errorf("%s", r.diagnostic)
+		}
+		if r.path == "" && r.nonResourcePath == "" {
+			continue
+		}
+		if r.path != "" {
+			m.matched++
+		}
+		s := shardOf(r, len(queues))
+		requests[s] = append(requests[s], r)
+	}
+	for i, r := range requests {
+		if len(r) == 0 {
+			continue
+		}
+		select {
+		case queues[i] <- r:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return b.err
+}
+
+// shardOf returns index of the shard which counts the request, requests of an endpoint are counted by the same shard
+func shardOf(r *request, shards int) int {
+	h := fnv.New32a()
+	if r.path != "" {
+		h.Write([]byte(r.method + " " + r.path))
+	} else {
+		h.Write([]byte(r.nonResourcePath))
+	}
+	return int(h.Sum32() % uint32(shards))
+}
+
+// merge adds non-resource URLs counted by shards into the matcher coverage, hits of endpoints are already counted in it
+func (p *pipeline) merge() {
+	for _, s := range p.shards {
+		p.matcher.coverage.Merge(&stats.Coverage{NonResourceURLs: s.coverage.NonResourceURLs})
+		s.coverage.NonResourceURLs = nil
+	}
+}
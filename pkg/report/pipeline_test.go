@@ -0,0 +1,268 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+)
+
+// generateAuditLog writes a large audit log built from the kubernetes fixture, requests are spread over 97 namespaces
+func generateAuditLog(path string, lines int) error {
+	data, err := ioutil.ReadFile(kubernetesAuditLogPath)
+	if err != nil {
+		return err
+	}
+	events := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for i := 0; i < lines; i++ {
+		ns := fmt.Sprintf("ns-%d", i%97)
+		line := bytes.Replace(events[i%len(events)], []byte("namespaces/default/"), []byte("namespaces/"+ns+"/"), -1)
+		line = bytes.Replace(line, []byte(`"namespace":"default"`), []byte(`"namespace":"`+ns+`"`), -1)
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+// exclusiveLogger records diagnostics, overlapping calls are detected as recordingLogger is not safe for concurrent use
+type exclusiveLogger struct {
+	recordingLogger
+	active     int32
+	concurrent bool
+}
+
+func (l *exclusiveLogger) enter() {
+	if atomic.AddInt32(&l.active, 1) != 1 {
+		l.concurrent = true
+	}
+	// give other goroutines a chance to overlap
+	runtime.Gosched()
+}
+
+func (l *exclusiveLogger) leave() {
+	atomic.AddInt32(&l.active, -1)
+}
+
+func (l *exclusiveLogger) Infof(format string, args ...interface{}) {
+	l.enter()
+	defer l.leave()
+	l.recordingLogger.Infof(format, args...)
+}
+
+func (l *exclusiveLogger) Warningf(format string, args ...interface{}) {
+	l.enter()
+	defer l.leave()
+	l.recordingLogger.Warningf(format, args...)
+}
+
+func (l *exclusiveLogger) Errorf(format string, args ...interface{}) {
+	l.enter()
+	defer l.leave()
+	l.recordingLogger.Errorf(format, args...)
+}
+
+var _ = Describe("Parallel pipeline", func() {
+
+	var (
+		dir     string
+		logPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "rest-coverage")
+		Expect(err).NotTo(HaveOccurred())
+		logPath = filepath.Join(dir, "audit.log")
+		Expect(generateAuditLog(logPath, 3000)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	generateWith := func(options Options, workers int, window *filter.Window, exclusions *exclusion.List, sources ...EventSource) (interface{}, error) {
+		options.Spec = SpecFile(kubernetesSwaggerPath)
+		options.Sources = sources
+		options.Window = window
+		options.Exclusions = exclusions
+		options.Logger = NopLogger()
+		options.Workers = workers
+		return GenerateContext(context.Background(), options)
+	}
+	generate := func(workers int, window *filter.Window, exclusions *exclusion.List, sources ...EventSource) (interface{}, error) {
+		return generateWith(Options{}, workers, window, exclusions, sources...)
+	}
+
+	table.DescribeTable("Should not depend on number of workers", func(windowed bool, dedupe bool, attribution string) {
+		var window *filter.Window
+		if windowed {
+			var err error
+			window, err = filter.NewWindow("marker:foos/foo", "marker:ns-50/foos/foo@delete", false)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		exclusions, err := exclusion.Load(kubernetesExclusionsPath)
+		Expect(err).NotTo(HaveOccurred())
+		source := FileSource(logPath, event.FormatAuditLog)
+
+		options := Options{Config: Config{Dedupe: dedupe}}
+		options.Attribution, err = filter.NewAttribution(attribution)
+		Expect(err).NotTo(HaveOccurred())
+		expected, err := generateWith(options, 1, window, exclusions, source, source)
+		Expect(err).NotTo(HaveOccurred())
+		for _, workers := range []int{2, 3, 8} {
+			coverage, err := generateWith(options, workers, window, exclusions, source, source)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(coverage).To(Equal(expected), fmt.Sprintf("%d workers", workers))
+		}
+	},
+		table.Entry("With all requests", false, false, ""),
+		table.Entry("With time window", true, false, ""),
+		table.Entry("With dedupe", false, true, ""),
+		table.Entry("With marker attribution", false, false, "marker:foos"),
+	)
+
+	It("Should serialize logger calls", func() {
+		// unknown query params are logged by shards which count requests
+		data, err := ioutil.ReadFile(logPath)
+		Expect(err).NotTo(HaveOccurred())
+		data = regexp.MustCompile(`"requestURI":"[^"?]*`).ReplaceAllFunc(data, func(uri []byte) []byte {
+			return append(append([]byte{}, uri...), "?unknown=true&"...)
+		})
+		Expect(ioutil.WriteFile(logPath, data, 0644)).To(Succeed())
+
+		generateLogged := func(workers int) *exclusiveLogger {
+			logger := &exclusiveLogger{}
+			_, err := GenerateContext(context.Background(), Options{
+				Config:  Config{Workers: workers},
+				Spec:    SpecFile(kubernetesSwaggerPath),
+				Sources: []EventSource{FileSource(logPath, event.FormatAuditLog)},
+				Logger:  logger,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return logger
+		}
+		expected := generateLogged(1)
+		Expect(len(expected.messages)).To(BeNumerically(">", 100))
+		logger := generateLogged(8)
+		Expect(logger.concurrent).To(BeFalse())
+		Expect(logger.messages).To(HaveLen(len(expected.messages)))
+	})
+
+	It("Should match events of sources which do not provide lines", func() {
+		reader, closer, err := event.OpenFile(logPath, event.FormatAuditLog)
+		Expect(err).NotTo(HaveOccurred())
+		defer closer.Close()
+		var events []*event.Event
+		for e, err := reader.Next(); err == nil; e, err = reader.Next() {
+			events = append(events, e)
+		}
+
+		expected, err := generate(1, nil, nil, FileSource(logPath, event.FormatAuditLog))
+		Expect(err).NotTo(HaveOccurred())
+		coverage, err := generate(4, nil, nil, EventsSource(events))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage).To(Equal(expected))
+	})
+
+	It("Should stop at the first invalid line", func() {
+		morePath := filepath.Join(dir, "more.log")
+		Expect(generateAuditLog(morePath, 1000)).To(Succeed())
+		more, err := ioutil.ReadFile(morePath)
+		Expect(err).NotTo(HaveOccurred())
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.Write(append([]byte("{invalid\n"), more...))
+		f.Close()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, expected := generate(1, nil, nil, FileSource(logPath, event.FormatAuditLog))
+		Expect(expected).To(HaveOccurred())
+		_, err = generate(4, nil, nil, FileSource(logPath, event.FormatAuditLog))
+		Expect(err).To(Equal(expected))
+	})
+
+	It("Should report progress in order and stop when context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var progress []Progress
+		_, err := GenerateContext(ctx, Options{
+			Config:  Config{Workers: 4},
+			Spec:    SpecFile(kubernetesSwaggerPath),
+			Sources: []EventSource{FileSource(logPath, event.FormatAuditLog)},
+			Logger:  NopLogger(),
+			Progress: func(p Progress) {
+				progress = append(progress, p)
+				if p.Events == 2000 {
+					cancel()
+				}
+			},
+			ProgressInterval: 500,
+		})
+		Expect(err).To(Equal(context.Canceled))
+		Expect(progress).To(HaveLen(4))
+		for i, p := range progress {
+			Expect(p.Events).To(Equal((i + 1) * 500))
+		}
+	})
+})
+
+func benchmarkGenerate(b *testing.B, lines int) {
+	dir, err := ioutil.TempDir("", "rest-coverage")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// suite variables are set by TestCoverage which is not run by benchmarks
+	kubernetesSwaggerPath = "../../fixtures/test_kubernetes.json"
+	kubernetesAuditLogPath = "../../fixtures/test_kubernetes_audit.log"
+	logPath := filepath.Join(dir, "audit.log")
+	if err := generateAuditLog(logPath, lines); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := GenerateContext(context.Background(), Options{
+					Config:  Config{Workers: workers},
+					Spec:    SpecFile(kubernetesSwaggerPath),
+					Sources: []EventSource{FileSource(logPath, event.FormatAuditLog)},
+					Logger:  NopLogger(),
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGenerate10k(b *testing.B) {
+	benchmarkGenerate(b, 10000)
+}
+
+func BenchmarkGenerate100k(b *testing.B) {
+	benchmarkGenerate(b, 100000)
+}
@@ -0,0 +1,394 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// ANSI colors used by the text printer
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorGray  = "\x1b[90m"
+	colorReset = "\x1b[0m"
+)
+
+// PrintOptions represents settings of the text report
+type PrintOptions struct {
+	// Detailed shows coverage of each endpoint with all its body and query fields
+	Detailed bool
+	// OnlyUncovered limits detailed report to not called endpoints and uncovered fields
+	OnlyUncovered bool
+	// MaxDepth limits depth of printed fields, 0 does not limit anything
+	MaxDepth int
+	// Color marks covered and uncovered items with colors
+	Color bool
+}
+
+// IsTerminal checks if file is a terminal, it is used to enable colors
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Print shows a generated report, if detailed it will show coverage for each endpoint with its fields
+func Print(coverage *stats.Coverage, detailed bool) error {
+	return Fprint(os.Stdout, coverage, PrintOptions{Detailed: detailed})
+}
+
+// Fprint writes a generated report in text format, endpoints and fields are sorted
+func Fprint(w io.Writer, coverage *stats.Coverage, options PrintOptions) error {
+	p := &printer{w: w, options: options}
+	p.printf("\nREST API coverage report:\n\n")
+	if options.Detailed {
+		p.printEndpoints(coverage.Endpoints)
+	}
+	if len(coverage.Actions) > 0 {
+		p.printf("\nCoverage per action:\n\n")
+		for _, a := range sortedKeys(coverage.Actions) {
+			p.printSummary("", a, coverage.Actions[a])
+		}
+	}
+	if len(coverage.Groups) > 0 {
+		p.printGroups(coverage.Groups)
+	}
+	if len(coverage.Kinds) > 0 {
+		p.printf("\nCoverage per kind:\n\n")
+		for _, k := range sortedKeys(coverage.Kinds) {
+			p.printSummary("", k, coverage.Kinds[k])
+		}
+	}
+	if len(coverage.NonResourceURLs) > 0 {
+		p.printf("\nNon-resource URLs not defined in swagger:\n\n")
+		paths := make([]string, 0, len(coverage.NonResourceURLs))
+		for path := range coverage.NonResourceURLs {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			u := coverage.NonResourceURLs[path]
+			if u.Discovery {
+				p.printf("%s\t%d hits (discovery)\n", path, u.Hits)
+			} else {
+				p.printf("%s\t%d hits\n", path, u.Hits)
+			}
+		}
+	}
+	if coverage.DiscoveryExcluded {
+		p.printf("\nAPI discovery endpoints are excluded from total coverage\n")
+	}
+	if coverage.ReadOnlyExcluded {
+		p.printf("\nRead-only fields are excluded from total coverage\n")
+	}
+	if len(coverage.Exclusions) > 0 {
+		p.printExclusions(coverage.Exclusions)
+	}
+	if coverage.Redundancy != nil {
+		p.printRedundancy(coverage.Redundancy)
+	}
+	if coverage.Metadata != nil && coverage.Metadata.Window != nil {
+		p.printWindow(coverage.Metadata.Window)
+	}
+	if coverage.Metadata != nil && coverage.Metadata.Scoring != nil && *coverage.Metadata.Scoring != *DefaultScoring() {
+		p.printf("\nScoring model: %s\n", FormatScoring(coverage.Metadata.Scoring))
+	}
+	if coverage.RequiredFields.Fields > 0 || coverage.OptionalFields.Fields > 0 {
+		p.printf("\n")
+		p.printFields("Required", &coverage.RequiredFields)
+		p.printFields("Optional", &coverage.OptionalFields)
+	}
+	p.printf("\nTotal coverage: %.2f%%\n\n", coverage.Percent)
+	return p.err
+}
+
+// FprintProgress writes a single line summary of coverage collected so far, it is printed repeatedly while
+// a requests log is followed
+func FprintProgress(w io.Writer, coverage *stats.Coverage, progress Progress, now time.Time) error {
+	called, endpoints := 0, 0
+	for _, methods := range coverage.Endpoints {
+		for _, endpoint := range methods {
+			if endpoint.Excluded {
+				continue
+			}
+			endpoints++
+			if endpoint.MethodCalled {
+				called++
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s Total coverage: %.2f%%, called endpoints: %d/%d, requests: %d, matched: %d\n",
+		now.Format(time.RFC3339), coverage.Percent, called, endpoints, progress.Events, progress.Matched)
+	return err
+}
+
+// printer writes a text report, it keeps the first write error
+type printer struct {
+	w       io.Writer
+	options PrintOptions
+	err     error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+// colored wraps text with color if colors are enabled
+func (p *printer) colored(color string, text string) string {
+	if !p.options.Color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// printEndpoints shows a tree of endpoints with their body and query fields
+func (p *printer) printEndpoints(endpoints map[string]map[string]*stats.Endpoint) {
+	paths := make([]string, 0, len(endpoints))
+	for path := range endpoints {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		methods := make([]string, 0, len(endpoints[path]))
+		for m, e := range endpoints[path] {
+			if p.options.OnlyUncovered && !isUncovered(e) {
+				continue
+			}
+			methods = append(methods, m)
+		}
+		if len(methods) == 0 {
+			continue
+		}
+		sort.Strings(methods)
+
+		p.printf("%s\n", path)
+		for _, m := range methods {
+			p.printEndpoint(endpoints[path][m])
+		}
+		p.printf("\n")
+	}
+}
+
+func (p *printer) printEndpoint(e *stats.Endpoint) {
+	method := strings.ToUpper(e.Method)
+	if e.Action != "" {
+		method += "(" + e.Action + ")"
+	}
+	status := fmt.Sprintf("%.2f%% (score %s/%s)", e.Percent, formatScore(e.Score), formatScore(e.ExpectedScore))
+	switch {
+	case e.Excluded:
+		status = p.colored(colorGray, status+" excluded")
+	case !e.MethodCalled:
+		status = p.colored(colorRed, status+" not called")
+	case e.Score == e.ExpectedScore:
+		status = p.colored(colorGreen, status)
+	}
+	p.printf("  %s: %s\n", method, status)
+
+	for _, params := range []struct {
+		name string
+		trie *stats.Trie
+	}{{"body", e.Body}, {"query", e.Query}} {
+		if params.trie == nil || len(params.trie.Root.Children) == 0 {
+			continue
+		}
+		if p.options.OnlyUncovered && !hasUncoveredLeaves(params.trie.Root) {
+			continue
+		}
+		p.printf("    %s\n", params.name)
+		p.printNodes(params.trie.Root, 1, "      ")
+	}
+}
+
+// printNodes shows sorted children of the node, nodes deeper than max depth are summarized by their parent
+func (p *printer) printNodes(node *stats.Node, depth int, indent string) {
+	keys := make([]string, 0, len(node.Children))
+	for k := range node.Children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := node.Children[k]
+		if p.options.OnlyUncovered && !hasUncoveredLeaves(child) {
+			continue
+		}
+		truncated := p.options.MaxDepth > 0 && depth >= p.options.MaxDepth && len(child.Children) > 0
+
+		status := fmt.Sprintf("%d", child.Hits)
+		switch {
+		case child.Excluded:
+			status = p.colored(colorGray, status+" excluded")
+		case child.IsLeaf && child.Hits == 0:
+			status = p.colored(colorRed, status+" uncovered")
+		case child.IsLeaf:
+			status = p.colored(colorGreen, status)
+		case truncated:
+			covered, total := countLeaves(child)
+			status += fmt.Sprintf(" (%d/%d fields covered)", covered, total)
+			if covered < total {
+				status = p.colored(colorRed, status)
+			}
+		}
+		if child.Deprecated {
+			status += " deprecated"
+		}
+		p.printf("%s%s: %s\n", indent, k, status)
+
+		if !truncated {
+			p.printNodes(child, depth+1, indent+"  ")
+		}
+	}
+}
+
+// isUncovered checks if endpoint has not been called or it has uncovered fields, excluded endpoints are skipped
+func isUncovered(e *stats.Endpoint) bool {
+	if e.Excluded {
+		return false
+	}
+	return !e.MethodCalled || hasUncoveredLeaves(e.Body.Root) || hasUncoveredLeaves(e.Query.Root)
+}
+
+// hasUncoveredLeaves checks if node is or contains a leaf without hits, excluded nodes are skipped
+func hasUncoveredLeaves(node *stats.Node) bool {
+	if node.Excluded {
+		return false
+	}
+	if node.IsLeaf && node.Hits == 0 {
+		return true
+	}
+	for _, child := range node.Children {
+		if hasUncoveredLeaves(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// countLeaves returns numbers of covered and all leaves of the node, excluded nodes are skipped
+func countLeaves(node *stats.Node) (int, int) {
+	if node.Excluded {
+		return 0, 0
+	}
+	covered, total := 0, 0
+	if node.IsLeaf {
+		total++
+		if node.Hits > 0 {
+			covered++
+		}
+	}
+	for _, child := range node.Children {
+		c, t := countLeaves(child)
+		covered += c
+		total += t
+	}
+	return covered, total
+}
+
+// printGroups shows coverage per API group, version and resource
+func (p *printer) printGroups(groups map[string]*stats.Group) {
+	p.printf("\nCoverage per API group:\n\n")
+	names := make([]string, 0, len(groups))
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		group := groups[g]
+		p.printSummary("", g, &group.Summary)
+		versions := make([]string, 0, len(group.Versions))
+		for v := range group.Versions {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		for _, v := range versions {
+			version := group.Versions[v]
+			p.printSummary("  ", v, &version.Summary)
+			for _, r := range sortedKeys(version.Resources) {
+				p.printSummary("    ", r, version.Resources[r])
+			}
+		}
+	}
+}
+
+func (p *printer) printSummary(indent string, name string, s *stats.Summary) {
+	p.printf("%s%s:\t%.2f%%\t(%d/%d endpoints called)\n", indent, name, s.Percent, s.CalledEndpoints, s.Endpoints)
+}
+
+func (p *printer) printFields(name string, s *stats.FieldSummary) {
+	p.printf("%s fields coverage: %.2f%% (%d/%d)\n", name, s.Percent, s.CoveredFields, s.Fields)
+}
+
+// sortedKeys returns sorted names of summaries
+func sortedKeys(summaries map[string]*stats.Summary) []string {
+	keys := make([]string, 0, len(summaries))
+	for k := range summaries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printRedundancy shows contribution of each test, redundant tests and the minimal subset of tests
+func (p *printer) printRedundancy(r *stats.Redundancy) {
+	p.printf("\nContribution of tests:\n\n")
+	for _, t := range r.Tests {
+		unique := fmt.Sprintf("%d unique (%.2f%%)", t.Unique, t.UniquePercent)
+		if t.Unique == 0 {
+			unique = p.colored(colorRed, unique)
+		}
+		p.printf("%s\t%d covered\t%s\t%d gain\n", t.Name, t.Covered, unique, t.Gain)
+	}
+	p.printf("\nRedundant tests: %d of %d\n", len(r.Redundant), len(r.Tests))
+	for _, t := range r.Redundant {
+		p.printf("\t%s\n", t)
+	}
+	p.printf("\nMinimal subset of tests covering %d items: %d of %d\n", r.Covered, len(r.Minimal), len(r.Tests))
+	for _, t := range r.Minimal {
+		p.printf("\t%s\n", t)
+	}
+}
+
+// printExclusions shows excluded endpoints and fields, excluded items which have been hit are flagged
+func (p *printer) printExclusions(exclusions []*stats.Exclusion) {
+	p.printf("\nExcluded:\n\n")
+	for _, e := range exclusions {
+		item := e.Path
+		if e.Method != "" {
+			item = strings.ToUpper(e.Method) + " " + item
+		}
+		if e.Field != "" {
+			item += " " + e.Location + ":" + e.Field
+			p.printf("%s\t%d fields\t%s\n", item, e.Fields, e.Reason)
+		} else {
+			p.printf("%s\t%d endpoints\t%s\n", item, e.Endpoints, e.Reason)
+		}
+		if e.Hits > 0 {
+			p.printf("\t%s\n", p.colored(colorRed, fmt.Sprintf("WARNING: excluded item has been hit %d times", e.Hits)))
+		}
+	}
+}
+
+// printWindow shows time window of requests included in the report
+func (p *printer) printWindow(w *stats.TimeWindow) {
+	bound := func(t *time.Time, configured string) string {
+		s := "unknown"
+		if t != nil {
+			s = t.Format(time.RFC3339)
+		}
+		if configured != "" {
+			s += " (" + configured + ")"
+		}
+		return s
+	}
+	p.printf("\nRequests between %s and %s: %d\n", bound(w.Start, w.StartBound), bound(w.End, w.EndBound), w.Requests)
+}
@@ -0,0 +1,115 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Text printer", func() {
+
+	var coverage *stats.Coverage
+
+	BeforeEach(func() {
+		var err error
+		coverage, err = Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	print := func(options PrintOptions) string {
+		var out bytes.Buffer
+		Expect(Fprint(&out, coverage, options)).To(Succeed())
+		return out.String()
+	}
+
+	It("Should print the same detailed report every time", func() {
+		report := print(PrintOptions{Detailed: true})
+		for i := 0; i < 5; i++ {
+			Expect(print(PrintOptions{Detailed: true})).To(Equal(report))
+		}
+	})
+
+	It("Should print sorted endpoints and fields with hits", func() {
+		report := print(PrintOptions{Detailed: true})
+		Expect(strings.Index(report, "/apis/example.io/v1/foos\n")).To(BeNumerically("<", strings.Index(report, "/apis/example.io/v1/namespaces/{namespace}/foos\n")))
+		Expect(report).To(ContainSubstring("" +
+			"  POST(post): 55.56% (score 10/18)\n" +
+			"    body\n" +
+			"      apiVersion: 1\n" +
+			"      kind: 1\n" +
+			"      metadata: 3\n" +
+			"        labels: 1\n" +
+			"        name: 1\n" +
+			"        namespace: 1\n" +
+			"        resourceVersion: 0 uncovered\n" +
+			"        uid: 0 uncovered\n"))
+		Expect(report).To(ContainSubstring("  GET(get): 0.00% (score 0/1) not called\n"))
+		Expect(report).NotTo(ContainSubstring(colorRed))
+	})
+
+	It("Should print weighted scores of endpoints", func() {
+		scoring, err := ParseScoring("method=10,decay=0.5")
+		Expect(err).NotTo(HaveOccurred())
+		coverage, err = GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{SwaggerPath: kubernetesSwaggerPath, Scoring: scoring})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(print(PrintOptions{Detailed: true})).To(ContainSubstring("  POST(post): 73.17% (score 15/20.5)\n"))
+	})
+
+	It("Should mark deprecated fields", func() {
+		report := print(PrintOptions{Detailed: true})
+		Expect(report).To(ContainSubstring("        legacyMode: 0 uncovered deprecated\n"))
+		Expect(report).NotTo(ContainSubstring("image: 1 deprecated"))
+	})
+
+	It("Should print only uncovered fields", func() {
+		report := print(PrintOptions{Detailed: true, OnlyUncovered: true})
+		Expect(report).To(ContainSubstring("" +
+			"  POST(post): 55.56% (score 10/18)\n" +
+			"    body\n" +
+			"      metadata: 3\n" +
+			"        resourceVersion: 0 uncovered\n"))
+		Expect(report).NotTo(ContainSubstring("apiVersion: 1"))
+		Expect(report).NotTo(ContainSubstring("/apis/example.io/v1/namespaces/{namespace}/foos/{name}/console\n"), "fully covered endpoint should be skipped")
+	})
+
+	It("Should limit depth of fields", func() {
+		report := print(PrintOptions{Detailed: true, MaxDepth: 1})
+		Expect(report).To(ContainSubstring("      metadata: 3 (3/5 fields covered)\n"))
+		Expect(report).NotTo(ContainSubstring("        uid: 0 uncovered\n"))
+	})
+
+	It("Should color uncovered fields", func() {
+		report := print(PrintOptions{Detailed: true, Color: true})
+		Expect(report).To(ContainSubstring("uid: " + colorRed + "0 uncovered" + colorReset))
+		Expect(report).To(ContainSubstring("name: " + colorGreen + "1" + colorReset))
+	})
+
+	It("Should print summary without detailed report", func() {
+		report := print(PrintOptions{})
+		Expect(report).NotTo(ContainSubstring("POST(post)"))
+		Expect(report).To(ContainSubstring("Coverage per API group:"))
+		Expect(report).To(HaveSuffix("Total coverage: 24.46%\n\n"))
+		Expect(report).NotTo(ContainSubstring("Scoring model:"), "default model should not be printed")
+	})
+
+	It("Should print the scoring model", func() {
+		scoring, err := ParseScoring("endpoints")
+		Expect(err).NotTo(HaveOccurred())
+		coverage.Metadata.Scoring = scoring
+		Expect(print(PrintOptions{})).To(ContainSubstring(
+			"\nScoring model: metric=endpoints,method=1,body=1,query=1,required=1,optional=1,decay=1\n"))
+	})
+
+	It("Should print a single line progress", func() {
+		var out bytes.Buffer
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		Expect(FprintProgress(&out, coverage, Progress{Events: 10, Matched: 8}, now)).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`^2020-01-02T03:04:05Z Total coverage: 24.46%, called endpoints: \d+/\d+, requests: 10, matched: 8\n$`))
+	})
+})
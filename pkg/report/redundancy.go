@@ -0,0 +1,152 @@
+package report
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// AnalyzeTests computes contribution of tests to the coverage from tests recorded for endpoints and fields,
+// it finds tests which do not cover anything unique and a greedy minimal subset of tests which covers everything
+// covered by all tests. Items are the same as units of the total coverage: called endpoints and hit leaf fields,
+// excluded items are skipped. It works with reports loaded from JSON as well, fields without children are leaves there.
+// Unique percent of a test is a sum of scores of its unique items by the scoring model of the report over the expected score.
+func AnalyzeTests(coverage *stats.Coverage) *stats.Redundancy {
+	scoring := DefaultScoring()
+	if coverage.Metadata != nil && coverage.Metadata.Scoring != nil {
+		scoring = coverage.Metadata.Scoring
+	}
+	// items holds indexes of items covered by each test, owners holds a number of tests covering each item
+	// and weights holds a score of each item
+	items := make(map[string][]int)
+	var (
+		owners  []int
+		weights []float64
+	)
+	add := func(tests []string, weight float64) {
+		if len(tests) == 0 {
+			return
+		}
+		for _, test := range tests {
+			items[test] = append(items[test], len(owners))
+		}
+		owners = append(owners, len(tests))
+		weights = append(weights, weight)
+	}
+	for _, e := range coverage.SortedEndpoints() {
+		if e.Excluded || (coverage.DiscoveryExcluded && e.Discovery) {
+			continue
+		}
+		var method float64
+		if scoring.Metric != MetricFields {
+			method = scoring.Method
+		}
+		add(e.Tests, method)
+		for _, params := range []struct {
+			trie   *stats.Trie
+			weight float64
+		}{{e.Body, scoring.Body}, {e.Query, scoring.Query}} {
+			if params.trie == nil {
+				continue
+			}
+			if scoring.Metric == MetricEndpoints {
+				params.weight = 0
+			}
+			for _, f := range params.trie.Root.Leaves(0) {
+				if !f.Node.Excluded {
+					add(f.Node.Tests, leafWeight(scoring, f.Node, params.weight))
+				}
+			}
+		}
+	}
+
+	redundancy := &stats.Redundancy{
+		Covered:   len(owners),
+		Tests:     make([]*stats.TestContribution, 0, len(items)),
+		Redundant: []string{},
+		Minimal:   []string{},
+	}
+	contributions := make(map[string]*stats.TestContribution, len(items))
+	for test, covered := range items {
+		c := &stats.TestContribution{Name: test, Covered: len(covered)}
+		var score float64
+		for _, item := range covered {
+			if owners[item] == 1 {
+				c.Unique++
+				score += weights[item]
+			}
+		}
+		if coverage.ExpectedScore > 0 {
+			c.UniquePercent = score * 100 / coverage.ExpectedScore
+		}
+		contributions[test] = c
+		redundancy.Tests = append(redundancy.Tests, c)
+		if c.Unique == 0 {
+			redundancy.Redundant = append(redundancy.Redundant, test)
+		}
+	}
+	sort.Slice(redundancy.Tests, func(i, j int) bool {
+		return redundancy.Tests[i].Name < redundancy.Tests[j].Name
+	})
+	sort.Strings(redundancy.Redundant)
+
+	// lazy greedy set cover, gains only decrease as items are covered, so a stale gain is an upper bound
+	// and a test is selected once its recomputed gain is still the best one
+	covered := make([]bool, len(owners))
+	gains := make(gainHeap, 0, len(items))
+	for test, c := range contributions {
+		gains = append(gains, &testGain{test: test, gain: c.Covered})
+	}
+	heap.Init(&gains)
+	for gains.Len() > 0 {
+		top := heap.Pop(&gains).(*testGain)
+		top.gain = 0
+		for _, item := range items[top.test] {
+			if !covered[item] {
+				top.gain++
+			}
+		}
+		if top.gain == 0 {
+			continue
+		}
+		if gains.Len() > 0 && !top.before(gains[0]) {
+			heap.Push(&gains, top)
+			continue
+		}
+		for _, item := range items[top.test] {
+			covered[item] = true
+		}
+		contributions[top.test].Gain = top.gain
+		redundancy.Minimal = append(redundancy.Minimal, top.test)
+	}
+	return redundancy
+}
+
+// testGain is a number of items a test adds to the selected tests
+type testGain struct {
+	test string
+	gain int
+}
+
+// before orders tests by gain, ties are broken by names, so the selection does not depend on map order
+func (g *testGain) before(other *testGain) bool {
+	if g.gain != other.gain {
+		return g.gain > other.gain
+	}
+	return g.test < other.test
+}
+
+// gainHeap is a max heap of test gains
+type gainHeap []*testGain
+
+func (h gainHeap) Len() int            { return len(h) }
+func (h gainHeap) Less(i, j int) bool  { return h[i].before(h[j]) }
+func (h gainHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *gainHeap) Push(x interface{}) { *h = append(*h, x.(*testGain)) }
+func (h *gainHeap) Pop() interface{} {
+	old := *h
+	g := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return g
+}
@@ -0,0 +1,86 @@
+package report
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Redundancy analysis", func() {
+
+	events := func() []*event.Event {
+		return []*event.Event{
+			newFooEvent("create", fooListURI, `{"spec":{"replicas":1,"image":"nginx"}}`, "e2e -- TestA"),
+			newFooEvent("create", fooListURI, `{"spec":{"replicas":2}}`, "e2e -- TestB"),
+			newFooEvent("list", fooListURI+"?pretty=true", "", "e2e -- TestC"),
+			newFooEvent("list", fooListURI, "", "e2e -- TestD"),
+			// requests which are not attributed to any test are not analyzed
+			newFooEvent("delete", fooListURI, "", "kubectl"),
+		}
+	}
+
+	It("Should find redundant tests and a minimal subset of tests", func() {
+		coverage := generateAttributed(events(), Config{AnalyzeTests: true})
+		percent := func(unique int) float64 {
+			return float64(unique) * 100 / coverage.ExpectedScore
+		}
+		Expect(coverage.Redundancy).To(Equal(&stats.Redundancy{
+			Covered: 5,
+			Tests: []*stats.TestContribution{
+				{Name: "TestA", Covered: 3, Unique: 1, UniquePercent: percent(1), Gain: 3},
+				{Name: "TestB", Covered: 2},
+				{Name: "TestC", Covered: 2, Unique: 1, UniquePercent: percent(1), Gain: 2},
+				{Name: "TestD", Covered: 1},
+			},
+			Redundant: []string{"TestB", "TestD"},
+			Minimal:   []string{"TestA", "TestC"},
+		}))
+		Expect(AnalyzeTests(coverage.Copy())).To(Equal(coverage.Redundancy))
+	})
+
+	It("Should weight unique items by the scoring model", func() {
+		scoring, err := ParseScoring("method=10,decay=0.5")
+		Expect(err).NotTo(HaveOccurred())
+		coverage := generateAttributed(events(), Config{AnalyzeTests: true, Scoring: scoring})
+		// spec.image of TestA is a nested body field, pretty of TestC is a query param
+		Expect(coverage.Redundancy.Tests[0].Name).To(Equal("TestA"))
+		Expect(coverage.Redundancy.Tests[0].UniquePercent).To(BeNumerically("~", 0.5*100/coverage.ExpectedScore, 1e-9))
+		Expect(coverage.Redundancy.Tests[2].Name).To(Equal("TestC"))
+		Expect(coverage.Redundancy.Tests[2].UniquePercent).To(BeNumerically("~", 1*100/coverage.ExpectedScore, 1e-9))
+	})
+
+	It("Should select tests by their remaining gain", func() {
+		// TestBig covers the most items, but TestLeft and TestRight cover all of them together with more,
+		// the greedy subset is not optimal, gains of other tests drop once TestBig is selected
+		coverage := generateAttributed([]*event.Event{
+			newFooEvent("create", fooListURI, `{"apiVersion":"v1","kind":"Foo","spec":{"replicas":1,"image":"nginx"}}`, "e2e -- TestBig"),
+			newFooEvent("create", fooListURI, `{"apiVersion":"v1","kind":"Foo","metadata":{"name":"a"}}`, "e2e -- TestLeft"),
+			newFooEvent("create", fooListURI, `{"spec":{"replicas":1,"image":"nginx"},"metadata":{"namespace":"b"}}`, "e2e -- TestRight"),
+		}, Config{})
+		redundancy := AnalyzeTests(coverage)
+		Expect(redundancy.Minimal).To(Equal([]string{"TestBig", "TestLeft", "TestRight"}))
+		Expect(redundancy.Redundant).To(Equal([]string{"TestBig"}))
+
+		var gains []int
+		for _, t := range redundancy.Tests {
+			gains = append(gains, t.Gain)
+		}
+		Expect(gains).To(Equal([]int{5, 1, 1}))
+	})
+
+	It("Should analyze reports without tests", func() {
+		coverage := generateAttributed(nil, Config{AnalyzeTests: true})
+		Expect(coverage.Redundancy).To(Equal(&stats.Redundancy{Tests: []*stats.TestContribution{}, Redundant: []string{}, Minimal: []string{}}))
+	})
+
+	It("Should print contribution of tests", func() {
+		var out bytes.Buffer
+		Expect(Fprint(&out, generateAttributed(events(), Config{AnalyzeTests: true}), PrintOptions{})).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("Redundant tests: 2 of 4\n\tTestB\n\tTestD\n"))
+		Expect(out.String()).To(ContainSubstring("Minimal subset of tests covering 5 items: 2 of 4\n\tTestA\n\tTestC\n"))
+	})
+})
@@ -1,22 +1,19 @@
 package report
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/go-openapi/loads"
-	"github.com/golang/glog"
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
 
-	"github.com/mfranczy/crd-rest-coverage/pkg/analysis"
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	reqfilter "github.com/mfranczy/crd-rest-coverage/pkg/filter"
 	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
 )
 
@@ -27,15 +24,17 @@ import (
 // /apis/kubevirt.io/*/namespaces/{namespace}/virtualmachineinstances/{name}
 // that can be useful if you want to calculate the coverage without versions distinction
 func getSwaggerPath(path string, objectRef *auditv1.ObjectReference, ignoreResourceVersion bool) string {
-	if namespace := objectRef.Namespace; namespace != "" {
-		path = strings.Replace(path, "namespaces/"+namespace, "namespaces/{namespace}", 1)
-	}
-	if name := objectRef.Name; name != "" {
-		path = strings.Replace(path, objectRef.Resource+"/"+name, objectRef.Resource+"/{name}", 1)
+	if objectRef != nil {
+		if namespace := objectRef.Namespace; namespace != "" {
+			path = strings.Replace(path, "namespaces/"+namespace, "namespaces/{namespace}", 1)
+		}
+		if name := objectRef.Name; name != "" {
+			path = strings.Replace(path, objectRef.Resource+"/"+name, objectRef.Resource+"/{name}", 1)
+		}
 	}
 	if ignoreResourceVersion {
 		s := strings.Split(path, "/")
-		if len(s) >= 3 && s[3] != "" {
+		if len(s) >= 4 && s[3] != "" {
 			s[3] = "*"
 		}
 		path = strings.Join(s, "/")
@@ -43,6 +42,42 @@ func getSwaggerPath(path string, objectRef *auditv1.ObjectReference, ignoreResou
 	return path
 }
 
+// findSwaggerPath matches request path against swagger paths, a path param like {name} matches any path segment,
+// if more than one swagger path matches then the one with the highest number of static segments is chosen,
+// it is used for requests without object reference, for instance, recorded by the proxy
+func findSwaggerPath(coverage *stats.Coverage, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	found, foundStatic := "", -1
+	for swaggerPath := range coverage.Endpoints {
+		static, ok := matchPathSegments(strings.Split(strings.Trim(swaggerPath, "/"), "/"), segments)
+		if !ok {
+			continue
+		}
+		if static > foundStatic || (static == foundStatic && swaggerPath < found) {
+			found, foundStatic = swaggerPath, static
+		}
+	}
+	return found
+}
+
+// matchPathSegments checks if request path segments match swagger path segments and returns a number of matched static segments
+func matchPathSegments(swaggerSegments []string, segments []string) (int, bool) {
+	if len(swaggerSegments) != len(segments) {
+		return 0, false
+	}
+	static := 0
+	for i, s := range swaggerSegments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			continue
+		}
+		if s != segments[i] {
+			return 0, false
+		}
+		static++
+	}
+	return static, true
+}
+
 // getHTTPMethod translates k8s verbs from audit log into HTTP methods
 // NOTE: audit log does not provide information about HTTP methods
 func getHTTPMethod(verb string) string {
@@ -62,131 +97,204 @@ func getHTTPMethod(verb string) string {
 	}
 }
 
-// matchQueryParams matches query params from request log to stats structure which has been built based on swagger definition
-func matchQueryParams(values url.Values, endpoint *stats.Endpoint) {
+// isWatch checks if request is a k8s watch, watch can be requested by verb or by watch query param of a list request
+func isWatch(verb string, method string, query url.Values) bool {
+	if verb == "watch" || verb == "watchList" {
+		return true
+	}
+	watch := query.Get("watch")
+	return method == "get" && (watch == "true" || watch == "1")
+}
+
+// getWatchPath translates k8s list path into a dedicated watch path, as an example,
+// /apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances will be translated to
+// /apis/kubevirt.io/v1alpha3/watch/namespaces/{namespace}/virtualmachineinstances
+func getWatchPath(path string) string {
+	s := strings.Split(path, "/")
+	switch {
+	case len(s) > 4 && s[1] == "apis":
+		return strings.Join(append(s[:4:4], append([]string{"watch"}, s[4:]...)...), "/")
+	case len(s) > 3 && s[1] == "api":
+		return strings.Join(append(s[:3:3], append([]string{"watch"}, s[3:]...)...), "/")
+	default:
+		return ""
+	}
+}
+
+// getConnectMethod returns HTTP method of a connect endpoint, it is used for k8s proxy verb
+// which does not provide information about HTTP method, get is preferred if available
+func getConnectMethod(endpoints map[string]*stats.Endpoint) string {
+	method := ""
+	for m, e := range endpoints {
+		if e.Action != "connect" && e.Action != "proxy" {
+			continue
+		}
+		if m == "get" {
+			return m
+		}
+		if method == "" || m < method {
+			method = m
+		}
+	}
+	return method
+}
+
+// matchQueryParams matches query params from request log to stats structure which has been built based on swagger definition,
+// hits are attributed to the test if it is not empty
+func matchQueryParams(values url.Values, test string, endpoint *stats.Endpoint, logger Logger) {
 	for k := range values {
 		if n := endpoint.Query.Root.GetChild(k); n != nil {
 			endpoint.Params.Query.IncreaseHits(n)
+			if test != "" {
+				endpoint.Params.Query.AddTest(n, test)
+			}
 		} else {
-			glog.Errorf("Invalid query param: '%s' for '%s %s'", k, endpoint.Method, endpoint.Path)
+			logger.Errorf("Invalid query param: '%s' for '%s %s'", k, endpoint.Method, endpoint.Path)
 		}
 	}
 }
 
-// matchBodyParams matches body params from request log to stats structure which has been built based on swagger definition
-func matchBodyParams(requestObject *runtime.Unknown, endpoint *stats.Endpoint) error {
-	if requestObject != nil {
-		var req interface{}
-		err := json.Unmarshal(requestObject.Raw, &req)
-		if err != nil {
-			return err
+// matchBodyParams matches body params from request log to stats structure which has been built based on swagger definition,
+// patch is a type of patch requests, empty for regular objects, hits are attributed to the test if it is not empty
+func matchBodyParams(requestBody []byte, patch types.PatchType, test string, endpoint *stats.Endpoint, logger Logger) error {
+	if len(requestBody) > 0 {
+		// the body is walked without decoding, validation does not allocate
+		if !json.Valid(requestBody) {
+			var req interface{}
+			return json.Unmarshal(requestBody, &req)
 		}
-		switch r := req.(type) {
-		case []interface{}:
-			for _, v := range r {
-				err = extractBodyParams(v, "", endpoint.Params.Body, endpoint.Params.Body.Root)
-				if err != nil {
-					return fmt.Errorf("Invalid requestObject '%s' for '%s %s'", err, endpoint.Method, endpoint.Path)
-				}
-			}
+		var err error
+		switch patch {
+		case types.JSONPatchType:
+			err = walkJSONPatch(requestBody, endpoint.Params.Body, test)
+		case types.StrategicMergePatchType:
+			err = walkStrategicMergePatch(requestBody, endpoint.Params.Body, test)
 		default:
-			err = extractBodyParams(r, "", endpoint.Params.Body, endpoint.Params.Body.Root)
-			if err != nil {
-				return fmt.Errorf("Invalid requestObject '%s' for '%s %s'", err, endpoint.Method, endpoint.Path)
-			}
+			// merge patch is a partial object, fields are matched the same as for objects
+			err = walkBody(requestBody, endpoint.Params.Body, test)
 		}
-
-	} else if requestObject != nil {
-		glog.Warningf("Request '%s %s' should not contain body params", endpoint.Method, endpoint.Path)
+		if err != nil {
+			return fmt.Errorf("Invalid requestObject '%s' for '%s %s'", err, endpoint.Method, endpoint.Path)
+		}
+	} else if requestBody != nil {
+		logger.Warningf("Request '%s %s' should not contain body params", endpoint.Method, endpoint.Path)
 	}
 
 	return nil
 }
 
-// extractBodyParams iterates over json and increase hits number in stats.Trie
-func extractBodyParams(params interface{}, key string, body *stats.Trie, node *stats.Node) error {
-	p, ok := params.(map[string]interface{})
-	if !ok && node.Depth == 0 {
-		return fmt.Errorf("%v", p)
-	} else if !ok {
-		return nil
-	}
-
-	if len(p) == 0 && node != body.Root {
-		// include empty objects
-		body.IncreaseHits(node)
-		return nil
+// calculateCoverage provides a total REST API and PATH:METHOD coverage number, excluded endpoints are not included in the total number,
+// if excludeDiscovery is enabled then k8s API discovery endpoints are not included as well, percents are ratios of scores
+// of the scoring model, nil scoring means DefaultScoring, endpoints are summed in order, so fractional scores are deterministic
+func calculateCoverage(coverage *stats.Coverage, excludeDiscovery bool, scoring *stats.Scoring) {
+	if scoring == nil {
+		scoring = DefaultScoring()
 	}
+	coverage.UniqueHits = 0
+	coverage.ExpectedUniqueHits = 0
+	coverage.Score = 0
+	coverage.ExpectedScore = 0
+	coverage.RequiredFields = stats.FieldSummary{}
+	coverage.OptionalFields = stats.FieldSummary{}
+	coverage.DiscoveryExcluded = excludeDiscovery
+	coverage.Actions = make(map[string]*stats.Summary)
+	coverage.Groups = make(map[string]*stats.Group)
+	coverage.Kinds = make(map[string]*stats.Summary)
+	for _, e := range coverage.SortedEndpoints() {
+		e.UniqueHits = e.Query.UniqueHits + e.Body.UniqueHits
+
+		if e.MethodCalled {
+			e.UniqueHits++
+		}
+		// sometimes hit number is bigger than params number
+		// for instance it might be caused by missing models definition
+		// users have to make sure that their definitions are complete
+		if e.UniqueHits > e.ExpectedUniqueHits {
+			e.UniqueHits = e.ExpectedUniqueHits
+		}
 
-	for k, v := range p {
-		n := node.GetChild(k)
-		// if child node does not exist then increase the current node
-		// for instance, having a.b.c.d if 'c' does not have child 'd' then increase hits for 'c'
-		if n == nil {
-			n = node
+		e.Score, e.ExpectedScore = scoreEndpoint(scoring, e)
+		if e.ExpectedScore > 0 {
+			e.Percent = e.Score * 100 / e.ExpectedScore
+		} else {
+			e.Percent = 0
 		}
 
-		switch obj := v.(type) {
-		case map[string]interface{}:
-			extractBodyParams(obj, k, body, n)
-		case []interface{}:
-			for _, v := range obj {
-				extractBodyParams(v, k, body, n)
+		if e.Excluded || (excludeDiscovery && e.Discovery) {
+			continue
+		}
+		coverage.UniqueHits += e.UniqueHits
+		coverage.ExpectedUniqueHits += e.ExpectedUniqueHits
+		coverage.Score += e.Score
+		coverage.ExpectedScore += e.ExpectedScore
+		summarizeFields(coverage, e.Body.Root)
+		summarizeFields(coverage, e.Query.Root)
+
+		if e.Action != "" {
+			if _, ok := coverage.Actions[e.Action]; !ok {
+				coverage.Actions[e.Action] = &stats.Summary{}
 			}
-		default:
-			body.IncreaseHits(n)
+			coverage.Actions[e.Action].Add(e)
 		}
+		aggregate(coverage, e)
 	}
 
-	return nil
+	if coverage.ExpectedScore > 0 {
+		coverage.Percent = coverage.Score * 100 / coverage.ExpectedScore
+	} else {
+		coverage.Percent = 0
+	}
 }
 
-// calculateCoverage provides a total REST API and PATH:METHOD coverage number
-func calculateCoverage(coverage *stats.Coverage) {
-	for _, es := range coverage.Endpoints {
-		for _, e := range es {
-			e.UniqueHits = e.Query.UniqueHits + e.Body.UniqueHits
-
-			if e.MethodCalled {
-				e.UniqueHits++
-			}
-			// sometimes hit number is bigger than params number
-			// for instance it might be caused by missing models definition
-			// users have to make sure that their definitions are complete
-			if e.UniqueHits > e.ExpectedUniqueHits {
-				e.UniqueHits = e.ExpectedUniqueHits
-			}
-
-			if e.ExpectedUniqueHits > 0 {
-				coverage.UniqueHits += e.UniqueHits
-				e.Percent = float64(e.UniqueHits) * 100 / float64(e.ExpectedUniqueHits)
-			} else {
-				e.Percent = 0
-			}
+// summarizeFields includes leaves of the node into required and optional fields coverage, excluded fields are skipped
+func summarizeFields(coverage *stats.Coverage, node *stats.Node) {
+	if node.Excluded {
+		return
+	}
+	if node.IsLeaf {
+		if node.Required {
+			coverage.RequiredFields.Add(node)
+		} else {
+			coverage.OptionalFields.Add(node)
 		}
+		return
 	}
-
-	if coverage.ExpectedUniqueHits > 0 {
-		coverage.Percent = float64(coverage.UniqueHits) * 100 / float64(coverage.ExpectedUniqueHits)
-	} else {
-		coverage.Percent = 0
+	for _, child := range node.Children {
+		summarizeFields(coverage, child)
 	}
 }
 
-// Print shows a generated report, if detailed it will show coverage for each endpoint
-func Print(coverage *stats.Coverage, detailed bool) error {
-	fmt.Printf("\nREST API coverage report:\n\n")
-	if detailed {
-		for p, es := range coverage.Endpoints {
-			fmt.Println(p)
-			for _, e := range es {
-				fmt.Printf("%s:%.2f%%\t", strings.ToUpper(e.Method), e.Percent)
-			}
-			fmt.Print("\n\n")
+// aggregate includes endpoint coverage into API group, version, resource and kind summaries
+func aggregate(coverage *stats.Coverage, e *stats.Endpoint) {
+	if e.Kind != "" {
+		if _, ok := coverage.Kinds[e.Kind]; !ok {
+			coverage.Kinds[e.Kind] = &stats.Summary{}
 		}
+		coverage.Kinds[e.Kind].Add(e)
 	}
-	fmt.Printf("\nTotal coverage: %.2f%%\n\n", coverage.Percent)
-	return nil
+	if e.Resource == "" {
+		return
+	}
+
+	groupName := endpointGroup(e)
+	group, ok := coverage.Groups[groupName]
+	if !ok {
+		group = &stats.Group{Versions: make(map[string]*stats.Version)}
+		coverage.Groups[groupName] = group
+	}
+	version, ok := group.Versions[e.Version]
+	if !ok {
+		version = &stats.Version{Resources: make(map[string]*stats.Summary)}
+		group.Versions[e.Version] = version
+	}
+	resource, ok := version.Resources[e.Resource]
+	if !ok {
+		resource = &stats.Summary{}
+		version.Resources[e.Resource] = resource
+	}
+	group.Add(e)
+	version.Add(e)
+	resource.Add(e)
 }
 
 // Dump saves a generated report into a file in JSON format
@@ -198,73 +306,97 @@ func Dump(path string, coverage *stats.Coverage) error {
 	return ioutil.WriteFile(path, jsonCov, 0644)
 }
 
+// Load reads a report saved by Dump, for instance to compare it with a new report,
+// trie nodes are restored, so the report can be printed and analyzed as a generated one
+func Load(path string) (*stats.Coverage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	coverage := &stats.Coverage{}
+	if err := json.Unmarshal(data, coverage); err != nil {
+		return nil, fmt.Errorf("Invalid report '%s': %s", path, err)
+	}
+	coverage.Restore()
+	return coverage, nil
+}
+
 // Generate provides a full REST API coverage report based on k8s audit log and swagger definition,
 // by passing param "filter" you can limit the report to specific resources, as an example,
 // "/apis/kubevirt.io/v1alpha3/" limits to kubevirt v1alpha3; "" no limit
 func Generate(auditLogsPath string, swaggerPath string, filter string, ignoreResourceVersion bool) (*stats.Coverage, error) {
-	start := time.Now()
-	defer glog.Infof("REST API coverage execution time: %s", time.Since(start))
+	return GenerateFromFile(auditLogsPath, event.FormatAuditLog, Config{
+		SwaggerPath:           swaggerPath,
+		Filter:                reqfilter.Prefix(filter),
+		IgnoreResourceVersion: ignoreResourceVersion,
+	})
+}
 
-	auditLogs, err := os.Open(auditLogsPath)
-	if err != nil {
-		return nil, err
-	}
+// GenerateFromFile provides a full REST API coverage report based on requests log in the given format,
+// see event.OpenFile for supported formats
+func GenerateFromFile(inputPath string, format string, config Config) (*stats.Coverage, error) {
+	return GenerateContext(context.Background(), config.Options(FileSource(inputPath, format)))
+}
 
-	sDocument, err := loads.JSONSpec(swaggerPath)
-	if err != nil {
-		return nil, err
-	}
+// GenerateFromReader provides a full REST API coverage report based on events provided by reader and swagger definition
+func GenerateFromReader(reader event.Reader, config Config) (*stats.Coverage, error) {
+	return GenerateContext(context.Background(), config.Options(ReaderSource(reader)))
+}
 
-	coverage, err := analysis.AnalyzeSwagger(sDocument, filter, ignoreResourceVersion)
+// GenerateFromEvents provides a full REST API coverage report based on already collected events and swagger definition,
+// events are matched in the same way as audit log entries in Generate
+func GenerateFromEvents(events []*event.Event, config Config) (*stats.Coverage, error) {
+	return GenerateContext(context.Background(), config.Options(EventsSource(events)))
+}
+
+// GenerateContext provides a full REST API coverage report based on events from all sources and swagger definition,
+// it stops with the context error once the context is done
+func GenerateContext(ctx context.Context, options Options) (*stats.Coverage, error) {
+	start := time.Now()
+	m, err := newMatcher(options)
 	if err != nil {
 		return nil, err
 	}
+	defer func() { m.logger.Infof("REST API coverage execution time: %s", time.Since(start)) }()
 
-	reader := bufio.NewReader(auditLogs)
-	for {
-		var event auditv1.Event
-		b, err := reader.ReadBytes('\n')
-		if err == io.EOF {
-			break
-		}
-
-		err = json.Unmarshal(b, &event)
-		if err != nil {
-			return nil, err
+	interval := options.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	events := 0
+	progress := func(source int, done bool) {
+		if options.Progress != nil {
+			options.Progress(Progress{Source: source, Sources: len(options.Sources), Events: events, Matched: m.matched, Done: done})
 		}
+	}
 
-		uri, err := url.Parse(event.RequestURI)
-		if err != nil {
+	var p *pipeline
+	if options.Workers > 1 {
+		p = newPipeline(m, options.Workers)
+	}
+	for i, source := range options.Sources {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-
-		path := getSwaggerPath(uri.Path, event.ObjectRef, ignoreResourceVersion)
-		if _, ok := coverage.Endpoints[path]; !ok {
-			if filter == "" {
-				glog.Errorf("Path '%s' not found in swagger", path)
+		read := func() {
+			events++
+			if events%interval == 0 {
+				progress(i, false)
 			}
-			continue
 		}
-
-		method := getHTTPMethod(event.Verb)
-		if method == "" {
-			glog.Errorf("Method '%s' not found for '%s' path", method, path)
-			continue
-		}
-
-		if _, ok := coverage.Endpoints[path][method]; !ok {
-			glog.Errorf("Method '%s' not found for '%s' path", method, path)
-			continue
+		if p != nil {
+			err = p.run(ctx, source, read)
+		} else {
+			err = m.matchSource(ctx, source, read)
 		}
-
-		coverage.Endpoints[path][method].MethodCalled = true
-		matchQueryParams(uri.Query(), coverage.Endpoints[path][method])
-		err = matchBodyParams(event.RequestObject, coverage.Endpoints[path][method])
 		if err != nil {
-			glog.Errorf("%s", err)
+			return nil, err
 		}
+		progress(i, true)
+	}
+	if p != nil {
+		p.merge()
 	}
 
-	calculateCoverage(coverage)
-	return coverage, nil
+	return m.calculate(), nil
 }
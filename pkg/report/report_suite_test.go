@@ -11,6 +11,9 @@ import (
 
 var petStoreSwaggerPath string
 var auditLogPath string
+var kubernetesSwaggerPath string
+var kubernetesAuditLogPath string
+var kubernetesExclusionsPath string
 
 func TestCoverage(t *testing.T) {
 	_, p, _, ok := runtime.Caller(0)
@@ -20,6 +23,9 @@ func TestCoverage(t *testing.T) {
 	fixturesPath := path.Join(path.Dir(p), "../../fixtures")
 	petStoreSwaggerPath = path.Join(fixturesPath, "test_petstore.json")
 	auditLogPath = path.Join(fixturesPath, "test_audit.log")
+	kubernetesSwaggerPath = path.Join(fixturesPath, "test_kubernetes.json")
+	kubernetesAuditLogPath = path.Join(fixturesPath, "test_kubernetes_audit.log")
+	kubernetesExclusionsPath = path.Join(fixturesPath, "test_kubernetes_exclusions.json")
 
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Coverage Suite")
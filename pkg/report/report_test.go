@@ -1,16 +1,25 @@
 package report
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	_ "math"
+	"net/url"
+	"path"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
 
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
 	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
 )
 
@@ -66,6 +75,48 @@ var _ = Describe("REST API coverage report", func() {
 			table.Entry("With URI filter", "fixtures/test_filter_output.json", "/pets/{name}"),
 		)
 
+		table.DescribeTable("Should generate the same report from other input formats", func(inputFile string, format string) {
+			inputPath := path.Join(path.Dir(auditLogPath), inputFile)
+			expectedCoverage, err := Generate(auditLogPath, petStoreSwaggerPath, "", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			coverage, err := GenerateFromFile(inputPath, format, Config{SwaggerPath: petStoreSwaggerPath})
+			Expect(err).NotTo(HaveOccurred(), "coverage structure should be initialized")
+
+			Expect(coverage.Percent).To(Equal(expectedCoverage.Percent), "percent should be equal")
+			Expect(coverage.UniqueHits).To(Equal(expectedCoverage.UniqueHits), "uniqueHits should be equal")
+			for path, methods := range coverage.Endpoints {
+				for method, endpoint := range methods {
+					expectedEndpoint := expectedCoverage.Endpoints[path][method]
+					Expect(endpoint.MethodCalled).To(Equal(expectedEndpoint.MethodCalled), fmt.Sprintf("%s:%s should have the same methodCalled values", path, method))
+					Expect(endpoint.UniqueHits).To(Equal(expectedEndpoint.UniqueHits), fmt.Sprintf("%s:%s should have the same uniqueHits values", path, method))
+				}
+			}
+		},
+			table.Entry("With HAR file", "test_petstore.har", "har"),
+			table.Entry("With HTTP log", "test_http.log", "http-log"),
+		)
+
+		table.DescribeTable("Should match request path to swagger path", func(path string, swaggerPath string) {
+			coverage := &stats.Coverage{
+				Endpoints: map[string]map[string]*stats.Endpoint{
+					"/pets":                      {},
+					"/pets/{name}":               {},
+					"/pets/{name}/owner":         {},
+					"/pets/search/{name}":        {},
+					"/pets/{name}/{subresource}": {},
+				},
+			}
+			Expect(findSwaggerPath(coverage, path)).To(Equal(swaggerPath))
+		},
+			table.Entry("With static path", "/pets", "/pets"),
+			table.Entry("With path param", "/pets/bite", "/pets/{name}"),
+			table.Entry("With static subresource", "/pets/bite/owner", "/pets/{name}/owner"),
+			table.Entry("With more static segments preferred", "/pets/search/bite", "/pets/search/{name}"),
+			table.Entry("With two path params", "/pets/bite/kind", "/pets/{name}/{subresource}"),
+			table.Entry("With unknown path", "/owners/bite", ""),
+		)
+
 		table.DescribeTable("Should return correct swagger path based on audit URL", func(URI string, objRef *auditv1.ObjectReference, swaggerPath string) {
 			path := getSwaggerPath(URI, objRef, false)
 			Expect(path).To(Equal(swaggerPath))
@@ -125,4 +176,336 @@ var _ = Describe("REST API coverage report", func() {
 			table.Entry("With empty verb", "", ""),
 		)
 	})
+
+	Context("With kubernetes audit log", func() {
+
+		var coverage *stats.Coverage
+
+		BeforeEach(func() {
+			var err error
+			coverage, err = Generate(kubernetesAuditLogPath, kubernetesSwaggerPath, "", false)
+			Expect(err).NotTo(HaveOccurred(), "coverage structure should be initialized")
+		})
+
+		table.DescribeTable("Should map k8s verbs to swagger operations", func(path string, method string, action string, called bool) {
+			Expect(coverage.Endpoints).To(HaveKey(path))
+			Expect(coverage.Endpoints[path]).To(HaveKey(method))
+			endpoint := coverage.Endpoints[path][method]
+			Expect(endpoint.Action).To(Equal(action))
+			Expect(endpoint.MethodCalled).To(Equal(called), fmt.Sprintf("%s:%s methodCalled should be %t", path, method, called))
+		},
+			table.Entry("With list verb", "/apis/example.io/v1/namespaces/{namespace}/foos", "get", "list", true),
+			table.Entry("With watch verb", "/apis/example.io/v1/watch/namespaces/{namespace}/foos", "get", "watchlist", true),
+			table.Entry("With not watched collection", "/apis/example.io/v1/watch/foos", "get", "watchlist", false),
+			table.Entry("With create verb", "/apis/example.io/v1/namespaces/{namespace}/foos", "post", "post", true),
+			table.Entry("With deletecollection verb", "/apis/example.io/v1/namespaces/{namespace}/foos", "delete", "deletecollection", true),
+			table.Entry("With delete verb", "/apis/example.io/v1/namespaces/{namespace}/foos/{name}", "delete", "delete", true),
+			table.Entry("With update verb for subresource", "/apis/example.io/v1/namespaces/{namespace}/foos/{name}/status", "put", "put", true),
+			table.Entry("With not updated resource", "/apis/example.io/v1/namespaces/{namespace}/foos/{name}", "put", "put", false),
+			table.Entry("With connect subresource", "/apis/example.io/v1/namespaces/{namespace}/foos/{name}/console", "get", "connect", true),
+		)
+
+		It("Should count requests per endpoint", func() {
+			Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["get"].Hits).To(Equal(1))
+			Expect(coverage.Endpoints["/apis/example.io/v1/watch/namespaces/{namespace}/foos"]["get"].Hits).To(Equal(1))
+			Expect(coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}"]["put"].Hits).To(Equal(0))
+		})
+
+		It("Should calculate coverage per action", func() {
+			Expect(coverage.Actions).To(HaveKey("deletecollection"))
+			Expect(coverage.Actions["deletecollection"].Endpoints).To(Equal(1))
+			Expect(coverage.Actions["deletecollection"].CalledEndpoints).To(Equal(1))
+			Expect(coverage.Actions["watchlist"].Endpoints).To(Equal(2))
+			Expect(coverage.Actions["watchlist"].CalledEndpoints).To(Equal(1))
+			Expect(coverage.Actions["watch"].CalledEndpoints).To(Equal(0))
+			Expect(coverage.Actions["list"].CalledEndpoints).To(Equal(2))
+		})
+
+		It("Should aggregate coverage per API group, version, resource and kind", func() {
+			Expect(coverage.Groups).To(HaveLen(1))
+			group := coverage.Groups["example.io"]
+			Expect(group.Endpoints).To(Equal(15), "discovery endpoints should not be included")
+			Expect(group.Versions).To(HaveKey("v1"))
+			Expect(group.Versions["v1"].Resources).To(HaveLen(1))
+
+			foos := group.Versions["v1"].Resources["foos"]
+			Expect(*foos).To(Equal(group.Summary), "namespaced, cluster scoped and subresource paths should be merged")
+			Expect(foos.CalledEndpoints).To(Equal(10))
+
+			Expect(coverage.Kinds).To(HaveLen(1))
+			Expect(coverage.Kinds["example.io/v1/Foo"].Endpoints).To(Equal(14), "connect operation does not define kind")
+		})
+
+		It("Should aggregate core API group", func() {
+			endpoint := &stats.Endpoint{
+				Params:             stats.Params{Body: stats.NewTrie(), Query: stats.NewTrie()},
+				ExpectedUniqueHits: 1,
+				MethodCalled:       true,
+				Path:               "/api/v1/namespaces/{namespace}/pods",
+				Method:             "get",
+				Version:            "v1",
+				Resource:           "pods",
+				Kind:               "core/v1/Pod",
+			}
+			c := &stats.Coverage{
+				Endpoints: map[string]map[string]*stats.Endpoint{endpoint.Path: {"get": endpoint}},
+			}
+			calculateCoverage(c, false, nil)
+			Expect(c.Groups).To(HaveKey("core"))
+			Expect(c.Groups["core"].Versions["v1"].Resources["pods"].Percent).To(Equal(100.0))
+			Expect(c.Kinds["core/v1/Pod"].CalledEndpoints).To(Equal(1))
+		})
+
+		It("Should match non-resource URLs to swagger paths", func() {
+			Expect(coverage.Endpoints["/apis/"]["get"].MethodCalled).To(BeTrue())
+			Expect(coverage.Endpoints["/apis/"]["get"].Discovery).To(BeTrue())
+			Expect(coverage.Endpoints["/apis/example.io/v1/"]["get"].MethodCalled).To(BeTrue())
+			Expect(coverage.Endpoints["/apis/example.io/"]["get"].MethodCalled).To(BeFalse())
+			Expect(coverage.Endpoints["/version/"]["get"].MethodCalled).To(BeTrue())
+		})
+
+		It("Should report non-resource URLs which are not defined in swagger", func() {
+			Expect(coverage.NonResourceURLs).To(HaveLen(2))
+			Expect(coverage.NonResourceURLs["/healthz"].Hits).To(Equal(1))
+			Expect(coverage.NonResourceURLs["/healthz"].Methods).To(Equal(map[string]int{"get": 1}))
+			Expect(coverage.NonResourceURLs["/healthz"].Discovery).To(BeFalse())
+			Expect(coverage.NonResourceURLs["/openapi/v2"].Discovery).To(BeTrue())
+		})
+
+		It("Should apply discovery and non-resource rules only to k8s API", func() {
+			spec := `{"swagger": "2.0", "info": {"title": "store", "version": "1"}, "paths": {
+				"/api/{id}": {"get": {"parameters": [{"name": "id", "in": "path", "required": true, "type": "string"}],
+					"responses": {"200": {"description": "OK"}}}},
+				"/version": {"get": {"responses": {"200": {"description": "OK"}}}}}}`
+			events := []*event.Event{
+				{Method: "GET", RequestURI: "/api/123"},
+				{Method: "GET", RequestURI: "/version"},
+				{Method: "GET", RequestURI: "/healthz"},
+			}
+			store, err := GenerateContext(context.Background(), Options{
+				Config:  Config{ExcludeDiscovery: true},
+				Spec:    SpecBytes([]byte(spec)),
+				Sources: []EventSource{EventsSource(events)},
+				Logger:  NopLogger(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.Endpoints["/api/{id}"]["get"].MethodCalled).To(BeTrue())
+			Expect(store.Endpoints["/api/{id}"]["get"].Discovery).To(BeFalse())
+			Expect(store.Endpoints["/version"]["get"].Discovery).To(BeFalse())
+			Expect(store.Percent).To(Equal(100.0))
+			Expect(store.NonResourceURLs).To(BeEmpty())
+		})
+
+		It("Should exclude discovery endpoints from total coverage", func() {
+			withoutDiscovery, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath:      kubernetesSwaggerPath,
+				ExcludeDiscovery: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			uniqueHits, expectedUniqueHits := 0, 0
+			for _, methods := range coverage.Endpoints {
+				for _, endpoint := range methods {
+					if endpoint.Discovery {
+						uniqueHits += endpoint.UniqueHits
+						expectedUniqueHits += endpoint.ExpectedUniqueHits
+					}
+				}
+			}
+			Expect(expectedUniqueHits).To(BeNumerically(">", 0))
+			Expect(withoutDiscovery.DiscoveryExcluded).To(BeTrue())
+			Expect(withoutDiscovery.UniqueHits).To(Equal(coverage.UniqueHits - uniqueHits))
+			Expect(withoutDiscovery.ExpectedUniqueHits).To(Equal(coverage.ExpectedUniqueHits - expectedUniqueHits))
+			Expect(withoutDiscovery.Actions["get"].Endpoints).To(Equal(coverage.Actions["get"].Endpoints - 4))
+		})
+
+		It("Should apply filter rules to both swagger endpoints and requests", func() {
+			f, err := filter.New([]string{"resource:foos"}, []string{"verb:watch", "glob:/apis/*/*/foos"})
+			Expect(err).NotTo(HaveOccurred())
+
+			filtered, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath: kubernetesSwaggerPath,
+				Filter:      f,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filtered.Endpoints).To(HaveLen(4))
+			Expect(filtered.Endpoints).NotTo(HaveKey("/apis/example.io/v1/watch/namespaces/{namespace}/foos"))
+			Expect(filtered.Endpoints).NotTo(HaveKey("/apis/example.io/v1/foos"))
+			Expect(filtered.Endpoints).NotTo(HaveKey("/apis/"))
+			Expect(filtered.NonResourceURLs).To(BeEmpty())
+
+			list := filtered.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["get"]
+			Expect(list.MethodCalled).To(BeTrue())
+			Expect(list.Query.Root.GetChild("watch").Hits).To(Equal(0), "watch request should be excluded")
+			Expect(list.Query.Root.GetChild("limit").Hits).To(Equal(1))
+		})
+
+		It("Should calculate the same coverage if audit events are skipped before decoding", func() {
+			f, err := filter.New([]string{"resource:foos", "user:system:serviceaccount:e2e:*"}, []string{"verb:watch"})
+			Expect(err).NotTo(HaveOccurred())
+			reader, closer, err := event.OpenFile(kubernetesAuditLogPath, event.FormatAuditLog)
+			Expect(err).NotTo(HaveOccurred())
+			defer closer.Close()
+			var events []*event.Event
+			for e, err := reader.Next(); err != io.EOF; e, err = reader.Next() {
+				Expect(err).NotTo(HaveOccurred())
+				events = append(events, e)
+			}
+			expected, err := GenerateFromEvents(events, Config{SwaggerPath: kubernetesSwaggerPath, Filter: f})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, workers := range []int{1, 4} {
+				skipped, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+					SwaggerPath: kubernetesSwaggerPath,
+					Filter:      f,
+					Workers:     workers,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(skipped).To(Equal(expected))
+			}
+		})
+
+		It("Should calculate coverage only for requests sent by the given user", func() {
+			f, err := filter.New([]string{"user:system:serviceaccount:e2e:*"}, []string{"user-agent:kubectl/*"})
+			Expect(err).NotTo(HaveOccurred())
+
+			filtered, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath: kubernetesSwaggerPath,
+				Filter:      f,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filtered.Endpoints).To(HaveLen(len(coverage.Endpoints)), "swagger endpoints should not be filtered by requester")
+			Expect(filtered.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"].MethodCalled).To(BeTrue())
+			Expect(filtered.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}/status"]["put"].MethodCalled).To(BeFalse())
+			Expect(filtered.Endpoints["/apis/example.io/v1/watch/namespaces/{namespace}/foos"]["get"].MethodCalled).To(BeFalse())
+			Expect(filtered.Endpoints["/apis/example.io/v1/foos"]["get"].MethodCalled).To(BeFalse())
+			Expect(filtered.NonResourceURLs).To(BeEmpty())
+		})
+
+		It("Should exclude endpoints and fields from total coverage", func() {
+			exclusions, err := exclusion.Load(kubernetesExclusionsPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			excluded, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath: kubernetesSwaggerPath,
+				Exclusions:  exclusions,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deleteCollection := coverage.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["delete"]
+			watch := coverage.Endpoints["/apis/example.io/v1/watch/foos"]["get"]
+			// spec.legacyMode of post, put and put status plus timeoutSeconds of list
+			Expect(excluded.ExpectedUniqueHits).To(Equal(coverage.ExpectedUniqueHits - deleteCollection.ExpectedUniqueHits - watch.ExpectedUniqueHits - 4))
+			Expect(excluded.UniqueHits).To(Equal(coverage.UniqueHits - deleteCollection.UniqueHits))
+			Expect(excluded.Actions["deletecollection"]).To(BeNil())
+
+			Expect(excluded.Exclusions).To(HaveLen(4))
+			Expect(excluded.Exclusions[0].Hits).To(Equal(1), "called deletecollection should be flagged")
+			for _, e := range excluded.Exclusions[1:] {
+				Expect(e.Hits).To(Equal(0))
+			}
+		})
+
+		It("Should calculate coverage of required and optional fields", func() {
+			// spec.image and spec.ports.port of post, put and put status
+			Expect(coverage.RequiredFields.Fields).To(Equal(6))
+			Expect(coverage.RequiredFields.CoveredFields).To(Equal(2))
+			Expect(coverage.RequiredFields.Percent).To(BeNumerically("~", 100.0/3, 1e-9))
+
+			endpoints, called := 0, 0
+			for _, e := range coverage.SortedEndpoints() {
+				endpoints++
+				if e.MethodCalled {
+					called++
+				}
+			}
+			Expect(coverage.RequiredFields.Fields + coverage.OptionalFields.Fields).To(Equal(coverage.ExpectedUniqueHits - endpoints))
+			Expect(coverage.RequiredFields.CoveredFields + coverage.OptionalFields.CoveredFields).To(Equal(coverage.UniqueHits - called))
+		})
+
+		It("Should exclude read-only fields from total coverage", func() {
+			excluded, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath:     kubernetesSwaggerPath,
+				ExcludeReadOnly: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(excluded.ReadOnlyExcluded).To(BeTrue())
+			Expect(coverage.ReadOnlyExcluded).To(BeFalse())
+
+			create := excluded.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"]
+			// metadata.uid, metadata.resourceVersion, status.phase and status.readyReplicas
+			Expect(create.ExpectedUniqueHits).To(Equal(coverage.Endpoints[create.Path]["post"].ExpectedUniqueHits - 4))
+			Expect(excluded.UniqueHits).To(Equal(coverage.UniqueHits))
+			Expect(excluded.Percent).To(BeNumerically(">", coverage.Percent))
+
+			var out bytes.Buffer
+			Expect(Fprint(&out, excluded, PrintOptions{})).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("\nRead-only fields are excluded from total coverage\n"))
+			Expect(out.String()).To(ContainSubstring("\nRequired fields coverage: 33.33% (2/6)\n"))
+		})
+
+		table.DescribeTable("Should calculate coverage only for requests within a time window", func(start string, end string, requests int, from string, to string) {
+			window, err := filter.NewWindow(start, end, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			windowed, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath: kubernetesSwaggerPath,
+				Window:      window,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(windowed.Metadata).NotTo(BeNil())
+			w := windowed.Metadata.Window
+			Expect(w.Requests).To(Equal(requests))
+			Expect(w.StartBound).To(Equal(start))
+			Expect(w.Start.Format(time.RFC3339)).To(Equal(from))
+			Expect(w.End.Format(time.RFC3339)).To(Equal(to))
+		},
+			table.Entry("With absolute time", "2019-06-03T12:01:00Z", "2019-06-03T12:02:00Z", 6, "2019-06-03T12:01:00Z", "2019-06-03T12:02:00Z"),
+			table.Entry("With duration relative to the first request", "+30s", "", 12, "2019-06-03T12:00:30Z", "2019-06-03T12:02:20Z"),
+			table.Entry("With marker events", "marker:foos/foo", "marker:default/foos/foo@delete", 7, "2019-06-03T12:00:20Z", "2019-06-03T12:01:40Z"),
+		)
+
+		It("Should skip marker events and requests outside of marker window", func() {
+			window, err := filter.NewWindow("marker:foos/foo", "marker:foos/foo@delete", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			windowed, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+				SwaggerPath: kubernetesSwaggerPath,
+				Window:      window,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(windowed.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["post"].MethodCalled).To(BeFalse())
+			Expect(windowed.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}"]["get"].MethodCalled).To(BeTrue())
+			Expect(windowed.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos/{name}"]["delete"].MethodCalled).To(BeFalse())
+			Expect(windowed.Endpoints["/apis/example.io/v1/namespaces/{namespace}/foos"]["delete"].MethodCalled).To(BeFalse())
+			Expect(windowed.NonResourceURLs).To(BeEmpty())
+		})
+	})
+
+	table.DescribeTable("Should detect watch requests", func(verb string, method string, query string, watch bool) {
+		values, err := url.ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isWatch(verb, method, values)).To(Equal(watch))
+	},
+		table.Entry("With watch verb", "watch", "get", "", true),
+		table.Entry("With watchList verb", "watchList", "get", "", true),
+		table.Entry("With list verb", "list", "get", "limit=10", false),
+		table.Entry("With watch query param", "", "get", "watch=true", true),
+		table.Entry("With watch query param disabled", "", "get", "watch=false", false),
+	)
+
+	table.DescribeTable("Should translate list path to watch path", func(path string, watchPath string) {
+		Expect(getWatchPath(path)).To(Equal(watchPath))
+	},
+		table.Entry("With namespaced resource", "/apis/kubevirt.io/v1alpha3/namespaces/{namespace}/virtualmachineinstances",
+			"/apis/kubevirt.io/v1alpha3/watch/namespaces/{namespace}/virtualmachineinstances"),
+		table.Entry("With cluster scoped resource", "/apis/kubevirt.io/v1alpha3/virtualmachineinstances",
+			"/apis/kubevirt.io/v1alpha3/watch/virtualmachineinstances"),
+		table.Entry("With core resource", "/api/v1/namespaces/{namespace}/pods", "/api/v1/watch/namespaces/{namespace}/pods"),
+		table.Entry("With non k8s path", "/pets", ""),
+	)
 })
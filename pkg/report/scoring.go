@@ -0,0 +1,151 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+const (
+	// MetricWeighted scores method calls and fields by their weights
+	MetricWeighted = "weighted"
+	// MetricEndpoints scores only method calls, an endpoint is covered once it is called
+	MetricEndpoints = "endpoints"
+	// MetricFields scores only fields, endpoints without fields are not included in the total coverage
+	MetricFields = "fields"
+)
+
+// DefaultScoring returns a model which scores a method call and each field as one unique hit
+func DefaultScoring() *stats.Scoring {
+	return &stats.Scoring{Metric: MetricWeighted, Method: 1, Body: 1, Query: 1, Required: 1, Optional: 1, Decay: 1}
+}
+
+// ParseScoring parses model in format key=value[,key=value...] where keys are metric, method, body, query, required,
+// optional and decay, for instance metric=weighted,method=10,decay=0.5, a value without key is a metric,
+// keys which are not given keep values of DefaultScoring
+func ParseScoring(value string) (*stats.Scoring, error) {
+	s := DefaultScoring()
+	for _, part := range strings.Split(value, ",") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			s.Metric = part
+			continue
+		}
+		k, v := part[:i], part[i+1:]
+		var weight *float64
+		switch k {
+		case "metric":
+			s.Metric = v
+			continue
+		case "method":
+			weight = &s.Method
+		case "body":
+			weight = &s.Body
+		case "query":
+			weight = &s.Query
+		case "required":
+			weight = &s.Required
+		case "optional":
+			weight = &s.Optional
+		case "decay":
+			weight = &s.Decay
+		default:
+			return nil, fmt.Errorf("Invalid scoring '%s', unknown key '%s', expected metric, method, body, query, required, optional or decay", value, k)
+		}
+		w, err := strconv.ParseFloat(v, 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+			return nil, fmt.Errorf("Invalid scoring '%s', %s weight '%s' is not a non-negative number", value, k, v)
+		}
+		*weight = w
+	}
+	switch s.Metric {
+	case MetricWeighted, MetricEndpoints, MetricFields:
+	default:
+		return nil, fmt.Errorf("Invalid scoring '%s', metric '%s', expected weighted, endpoints or fields", value, s.Metric)
+	}
+	return s, nil
+}
+
+// FormatScoring formats model in the format parsed by ParseScoring
+func FormatScoring(s *stats.Scoring) string {
+	format := func(w float64) string {
+		return strconv.FormatFloat(w, 'g', -1, 64)
+	}
+	return fmt.Sprintf("metric=%s,method=%s,body=%s,query=%s,required=%s,optional=%s,decay=%s",
+		s.Metric, format(s.Method), format(s.Body), format(s.Query), format(s.Required), format(s.Optional), format(s.Decay))
+}
+
+// scoreEndpoint returns weighted unique hits and weighted expected unique hits of the endpoint,
+// excluded fields are not scored
+func scoreEndpoint(s *stats.Scoring, e *stats.Endpoint) (float64, float64) {
+	var score, expected float64
+	if s.Metric != MetricFields {
+		expected += s.Method
+		if e.MethodCalled {
+			score += s.Method
+		}
+		if s.Metric == MetricEndpoints {
+			return score, expected
+		}
+	}
+	for _, params := range []struct {
+		trie   *stats.Trie
+		weight float64
+	}{{e.Body, s.Body}, {e.Query, s.Query}} {
+		if params.trie != nil && params.weight > 0 {
+			fieldScore, fieldExpected := scoreNode(s, params.trie.Root, params.weight)
+			score += fieldScore
+			expected += fieldExpected
+		}
+	}
+	return score, expected
+}
+
+// leafWeight returns weight of a leaf field, weight is a weight of body or query fields
+func leafWeight(s *stats.Scoring, node *stats.Node, weight float64) float64 {
+	if node.Required {
+		weight *= s.Required
+	} else {
+		weight *= s.Optional
+	}
+	if node.Depth > 1 {
+		weight *= math.Pow(s.Decay, float64(node.Depth-1))
+	}
+	return weight
+}
+
+// formatScore formats a score rounded to two decimal places without trailing zeros
+func formatScore(score float64) string {
+	return strconv.FormatFloat(math.Round(score*100)/100, 'f', -1, 64)
+}
+
+// scoreNode scores leaves of the node, the root of a trie is a leaf if the body is an empty object,
+// children are scored in the order of keys, so sums of fractional weights do not depend on map order
+func scoreNode(s *stats.Scoring, node *stats.Node, weight float64) (float64, float64) {
+	if node.Excluded {
+		return 0, 0
+	}
+	if node.IsLeaf {
+		weight = leafWeight(s, node, weight)
+		if node.Hits > 0 {
+			return weight, weight
+		}
+		return 0, weight
+	}
+	var score, expected float64
+	keys := make([]string, 0, len(node.Children))
+	for k := range node.Children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		childScore, childExpected := scoreNode(s, node.Children[k], weight)
+		score += childScore
+		expected += childExpected
+	}
+	return score, expected
+}
@@ -0,0 +1,135 @@
+package report
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+var _ = Describe("Scoring", func() {
+
+	generate := func(scoring string) *stats.Coverage {
+		model, err := ParseScoring(scoring)
+		Expect(err).NotTo(HaveOccurred())
+		coverage, err := GenerateFromEvents([]*event.Event{
+			newFooEvent("create", fooListURI, `{"spec":{"image":"nginx","ports":[{"port":80}]}}`, ""),
+		}, Config{SwaggerPath: kubernetesSwaggerPath, Scoring: model})
+		Expect(err).NotTo(HaveOccurred())
+		return coverage
+	}
+
+	It("Should score unique hits with the default model", func() {
+		exclusions, err := exclusion.Load(kubernetesExclusionsPath)
+		Expect(err).NotTo(HaveOccurred())
+		coverage, err := GenerateFromFile(kubernetesAuditLogPath, event.FormatAuditLog, Config{
+			SwaggerPath: kubernetesSwaggerPath,
+			Exclusions:  exclusions,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage.Metadata.Scoring).To(Equal(DefaultScoring()))
+		Expect(coverage.Score).To(Equal(float64(coverage.UniqueHits)))
+		Expect(coverage.ExpectedScore).To(Equal(float64(coverage.ExpectedUniqueHits)))
+		for _, e := range coverage.SortedEndpoints() {
+			Expect(e.Score).To(Equal(float64(e.UniqueHits)), e.Method+" "+e.Path)
+			Expect(e.ExpectedScore).To(Equal(float64(e.ExpectedUniqueHits)), e.Method+" "+e.Path)
+		}
+	})
+
+	// the created foo has 1 method call, 14 body fields, 3 query params and hits required spec.image and spec.ports.port
+	table.DescribeTable("Should score endpoint with the model", func(scoring string, score float64, expected float64) {
+		coverage := generate(scoring)
+		e := coverage.Endpoints[fooPath]["post"]
+		Expect(e.Score).To(BeNumerically("~", score, 1e-9))
+		Expect(e.ExpectedScore).To(BeNumerically("~", expected, 1e-9))
+		Expect(e.Percent).To(BeNumerically("~", score*100/expected, 1e-9))
+		Expect(e.UniqueHits).To(Equal(3), "unique hits should not depend on the model")
+		model, err := ParseScoring(scoring)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coverage.Metadata.Scoring).To(Equal(model))
+	},
+		table.Entry("With default model", "weighted", 3.0, 18.0),
+		table.Entry("With method weight", "method=10", 12.0, 27.0),
+		table.Entry("Without body fields", "body=0", 1.0, 4.0),
+		table.Entry("With only required fields", "required=1,optional=0", 3.0, 3.0),
+		table.Entry("With required fields weight", "required=3", 7.0, 22.0),
+		// nested fields weigh 0.5 and 0.25
+		table.Entry("With depth decay", "decay=0.5", 1.75, 11.5),
+		table.Entry("With endpoints metric", "endpoints", 1.0, 1.0),
+		table.Entry("With fields metric", "metric=fields", 2.0, 17.0),
+	)
+
+	It("Should score the total coverage with endpoints metric", func() {
+		coverage := generate("endpoints")
+		endpoints := 0
+		for _, e := range coverage.SortedEndpoints() {
+			endpoints++
+			if e.MethodCalled {
+				Expect(e.Percent).To(Equal(100.0))
+			} else {
+				Expect(e.Percent).To(Equal(0.0))
+			}
+		}
+		Expect(coverage.ExpectedScore).To(Equal(float64(endpoints)))
+		Expect(coverage.Percent).To(Equal(100 / float64(endpoints)))
+	})
+
+	It("Should not score endpoints without fields with fields metric", func() {
+		coverage := generate("fields")
+		for _, e := range coverage.SortedEndpoints() {
+			if e.ExpectedUniqueHits == 1 {
+				Expect(e.ExpectedScore).To(BeZero(), e.Method+" "+e.Path)
+			}
+		}
+		Expect(coverage.Percent).To(BeNumerically("~", coverage.Score*100/coverage.ExpectedScore, 1e-9))
+		Expect(coverage.Score).To(Equal(2.0))
+	})
+
+	It("Should record the model in JSON reports and copies", func() {
+		coverage := generate("metric=fields,decay=0.5")
+		data, err := json.Marshal(coverage)
+		Expect(err).NotTo(HaveOccurred())
+		loaded := &stats.Coverage{}
+		Expect(json.Unmarshal(data, loaded)).To(Succeed())
+		Expect(loaded.Metadata.Scoring).To(Equal(coverage.Metadata.Scoring))
+		Expect(loaded.Endpoints[fooPath]["post"].Body.Root.Children["spec"].Required).To(BeTrue())
+
+		copied := coverage.Copy()
+		copied.Metadata.Scoring.Decay = 1
+		Expect(coverage.Metadata.Scoring.Decay).To(Equal(0.5))
+	})
+
+	It("Should not depend on number of workers", func() {
+		model, err := ParseScoring("decay=0.3,required=1.7")
+		Expect(err).NotTo(HaveOccurred())
+		var events []*event.Event
+		for i := 0; i < 100; i++ {
+			events = append(events,
+				newFooEvent("create", fooListURI, `{"spec":{"image":"nginx","ports":[{"port":80}]}}`, ""),
+				newFooEvent("create", fooListURI, `{"metadata":{"name":"foo"},"spec":{"replicas":1}}`, ""),
+				newFooEvent("list", fooListURI+"?limit=1", "", ""),
+			)
+		}
+		generate := func(workers int) *stats.Coverage {
+			coverage, err := GenerateFromEvents(events, Config{SwaggerPath: kubernetesSwaggerPath, Scoring: model, Workers: workers})
+			Expect(err).NotTo(HaveOccurred())
+			return coverage
+		}
+		Expect(generate(4)).To(Equal(generate(1)))
+	})
+
+	table.DescribeTable("Should not parse invalid models", func(scoring string) {
+		_, err := ParseScoring(scoring)
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With unknown metric", "paths"),
+		table.Entry("With unknown key", "header=1"),
+		table.Entry("With negative weight", "method=-1"),
+		table.Entry("With invalid weight", "decay=half"),
+	)
+})
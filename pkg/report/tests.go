@@ -0,0 +1,258 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/exclusion"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+// TestQuery selects endpoints and fields whose tests are listed by TestsCovering, globs are the same as in exclusions
+type TestQuery struct {
+	// Path is a glob of swagger path, '*' matches a single path segment and '**' matches any number of segments
+	Path string
+	// Method is a HTTP method, empty or '*' matches all methods
+	Method string
+	// Field is a glob of dotted field path, '*' matches a single field and '**' matches any number of fields,
+	// empty selects endpoints
+	Field string
+	// Location is a location of the field, body (default) or query
+	Location string
+
+	path  *regexp.Regexp
+	field *regexp.Regexp
+}
+
+// ParseTestQuery parses query in format key=value[,key=value...] where keys are path, method, field and location,
+// for instance path=/apis/kubevirt.io/**,field=spec.domain.devices, a value without key is a field glob
+// of all endpoints, for instance spec.domain.devices
+func ParseTestQuery(value string) (*TestQuery, error) {
+	q := &TestQuery{Path: "/**"}
+	for _, part := range strings.Split(value, ",") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			q.Field = part
+			continue
+		}
+		switch k, v := part[:i], part[i+1:]; k {
+		case "path":
+			q.Path = v
+		case "method":
+			q.Method = strings.ToLower(v)
+		case "field":
+			q.Field = v
+		case "location":
+			q.Location = v
+		default:
+			return nil, fmt.Errorf("Invalid test query '%s', unknown key '%s', expected path, method, field or location", value, k)
+		}
+	}
+	switch q.Location {
+	case "":
+		q.Location = exclusion.LocationBody
+	case exclusion.LocationBody, exclusion.LocationQuery:
+	default:
+		return nil, fmt.Errorf("Invalid test query '%s', location '%s', expected body or query", value, q.Location)
+	}
+
+	var err error
+	if q.path, err = filter.CompileGlob(q.Path, '/'); err != nil {
+		return nil, err
+	}
+	if q.Field != "" {
+		if q.field, err = filter.CompileGlob(q.Field, '.'); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// CoveredItem represents an endpoint or its field with tests which hit it
+type CoveredItem struct {
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	// Location and Field are empty for endpoints
+	Location string   `json:"location,omitempty"`
+	Field    string   `json:"field,omitempty"`
+	Hits     int      `json:"hits"`
+	Tests    []string `json:"tests"`
+}
+
+// TestsCovering answers which tests cover endpoints or fields matching the query, items are sorted by endpoints
+// and dotted field paths, items which have not been hit are included with no tests
+func TestsCovering(coverage *stats.Coverage, query *TestQuery) []*CoveredItem {
+	var items []*CoveredItem
+	for _, e := range coverage.SortedEndpoints() {
+		if (query.Method != "" && query.Method != "*" && query.Method != e.Method) || !query.path.MatchString(e.Path) {
+			continue
+		}
+		if query.field == nil {
+			items = append(items, &CoveredItem{Path: e.Path, Method: e.Method, Hits: e.Hits, Tests: nonNil(e.Tests)})
+			continue
+		}
+		trie := e.Body
+		if query.Location == exclusion.LocationQuery {
+			trie = e.Query
+		}
+		if trie == nil {
+			continue
+		}
+		for _, f := range fields(trie.Root, "") {
+			if query.field.MatchString(f.Path) {
+				items = append(items, &CoveredItem{
+					Path: e.Path, Method: e.Method, Location: query.Location, Field: f.Path, Hits: f.Node.Hits, Tests: nonNil(f.Node.Tests),
+				})
+			}
+		}
+	}
+	return items
+}
+
+// CoveredByTest answers what the test covers, it returns endpoints called by the test and the deepest fields hit by it,
+// items are sorted by endpoints and dotted field paths, hits are numbers of all requests, not only requests of the test
+func CoveredByTest(coverage *stats.Coverage, test string) []*CoveredItem {
+	var items []*CoveredItem
+	for _, e := range coverage.SortedEndpoints() {
+		if !hasTest(e.Tests, test) {
+			continue
+		}
+		items = append(items, &CoveredItem{Path: e.Path, Method: e.Method, Hits: e.Hits, Tests: []string{test}})
+		for _, location := range []string{exclusion.LocationBody, exclusion.LocationQuery} {
+			trie := e.Body
+			if location == exclusion.LocationQuery {
+				trie = e.Query
+			}
+			if trie == nil {
+				continue
+			}
+			for _, f := range fields(trie.Root, "") {
+				if hasTest(f.Node.Tests, test) && !childHasTest(f.Node, test) {
+					items = append(items, &CoveredItem{
+						Path: e.Path, Method: e.Method, Location: location, Field: f.Path, Hits: f.Node.Hits, Tests: []string{test},
+					})
+				}
+			}
+		}
+	}
+	return items
+}
+
+// CoverageOfTest returns coverage of requests attributed to the test, coverage has to be calculated with tests recorded,
+// endpoints and fields which are not hit by the test are reported as not covered, hits are numbers of all requests
+// as they are not recorded per test, test redundancy and non-resource URLs are not included
+func CoverageOfTest(coverage *stats.Coverage, test string) *stats.Coverage {
+	c := coverage.Copy()
+	c.NonResourceURLs = nil
+	c.Redundancy = nil
+	for _, methods := range c.Endpoints {
+		for _, e := range methods {
+			if !hasTest(e.Tests, test) {
+				e.MethodCalled, e.Hits = false, 0
+			}
+			e.Tests = onlyTest(e.Tests, test)
+			for _, trie := range []*stats.Trie{e.Body, e.Query} {
+				if trie != nil {
+					trie.UniqueHits = onlyTestHits(trie.Root, test)
+				}
+			}
+		}
+	}
+	var scoring *stats.Scoring
+	if c.Metadata != nil {
+		scoring = c.Metadata.Scoring
+	}
+	calculateCoverage(c, c.DiscoveryExcluded, scoring)
+	return c
+}
+
+// onlyTestHits resets hits of nodes which are not hit by the test, it returns number of hit leaves which are not excluded
+func onlyTestHits(node *stats.Node, test string) int {
+	if !hasTest(node.Tests, test) {
+		node.Hits = 0
+	}
+	node.Tests = onlyTest(node.Tests, test)
+	unique := 0
+	if node.IsLeaf && node.Hits > 0 && !node.Excluded {
+		unique++
+	}
+	for _, child := range node.Children {
+		unique += onlyTestHits(child, test)
+	}
+	return unique
+}
+
+// onlyTest returns the test if sorted tests contain it, otherwise nil
+func onlyTest(tests []string, test string) []string {
+	if hasTest(tests, test) {
+		return []string{test}
+	}
+	return nil
+}
+
+// FprintCoveredItems writes items in text format, one item per line with tab separated hits and tests
+func FprintCoveredItems(w io.Writer, items []*CoveredItem) error {
+	for _, item := range items {
+		name := strings.ToUpper(item.Method) + " " + item.Path
+		if item.Field != "" {
+			name += " " + item.Location + " " + item.Field
+		}
+		tests := strings.Join(item.Tests, ", ")
+		if tests == "" {
+			tests = "-"
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%d hits\t%s\n", name, item.Hits, tests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fields returns all descendants of the node with dotted paths sorted by keys, parents go before their children,
+// unlike stats.Node.Leaves it includes parents, so deepest fields hit by a test can be found
+func fields(node *stats.Node, prefix string) []stats.Field {
+	keys := make([]string, 0, len(node.Children))
+	for k := range node.Children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var result []stats.Field
+	for _, k := range keys {
+		child := node.Children[k]
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		result = append(result, stats.Field{Path: path, Node: child})
+		result = append(result, fields(child, path)...)
+	}
+	return result
+}
+
+// hasTest checks if sorted tests contain the test
+func hasTest(tests []string, test string) bool {
+	i := sort.SearchStrings(tests, test)
+	return i < len(tests) && tests[i] == test
+}
+
+func childHasTest(node *stats.Node, test string) bool {
+	for _, child := range node.Children {
+		if hasTest(child.Tests, test) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonNil returns an empty slice for nil tests, so JSON lists items without tests as empty arrays
+func nonNil(tests []string) []string {
+	if tests == nil {
+		return []string{}
+	}
+	return tests
+}
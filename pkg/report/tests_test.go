@@ -0,0 +1,156 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/mfranczy/crd-rest-coverage/pkg/event"
+	"github.com/mfranczy/crd-rest-coverage/pkg/filter"
+	"github.com/mfranczy/crd-rest-coverage/pkg/stats"
+)
+
+const fooListURI = "/apis/example.io/v1/namespaces/default/foos"
+
+// newFooEvent builds a request for foos of the kubernetes fixture sent with the user agent
+func newFooEvent(verb string, uri string, body string, userAgent string) *event.Event {
+	e := &event.Event{
+		Verb:       verb,
+		RequestURI: uri,
+		ObjectRef:  &auditv1.ObjectReference{Resource: "foos", Namespace: "default", APIGroup: "example.io", APIVersion: "v1"},
+		UserAgent:  userAgent,
+	}
+	if body != "" {
+		e.RequestBody = []byte(body)
+	}
+	return e
+}
+
+// generateAttributed generates a report of events attributed to tests by the user agent suffix
+func generateAttributed(events []*event.Event, config Config) *stats.Coverage {
+	attribution, err := filter.NewAttribution("user-agent")
+	Expect(err).NotTo(HaveOccurred())
+	config.SwaggerPath, config.Attribution = kubernetesSwaggerPath, attribution
+	coverage, err := GenerateFromEvents(events, config)
+	Expect(err).NotTo(HaveOccurred())
+	return coverage
+}
+
+var _ = Describe("Per-test coverage", func() {
+
+	newEvent := newFooEvent
+	events := func() []*event.Event {
+		return []*event.Event{
+			newEvent("create", fooListURI, `{"spec":{"replicas":1,"ports":[{"port":80}]}}`, "e2e.test/v1.18.0 -- TestReplicas"),
+			newEvent("create", fooListURI, `{"spec":{"image":"nginx","ports":[{"name":"http"}]}}`, "e2e.test/v1.18.0 -- TestImage"),
+			newEvent("list", fooListURI+"?pretty=true", "", "e2e.test/v1.18.0 -- TestImage"),
+			newEvent("list", fooListURI, "", "kubectl/v1.18.0"),
+		}
+	}
+
+	generate := func(workers int, events []*event.Event) *stats.Coverage {
+		return generateAttributed(events, Config{Workers: workers})
+	}
+
+	table.DescribeTable("Should answer which tests cover endpoints and fields", func(query string, expected []*CoveredItem) {
+		q, err := ParseTestQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(TestsCovering(generate(1, events()), q)).To(Equal(expected))
+	},
+		table.Entry("With a field", "path=/apis/example.io/v1/namespaces/*/foos,field=spec.ports", []*CoveredItem{
+			{Path: fooPath, Method: "post", Location: "body", Field: "spec.ports", Hits: 2, Tests: []string{"TestImage", "TestReplicas"}},
+		}),
+		table.Entry("With a field glob", "path=/apis/example.io/v1/namespaces/*/foos,field=spec.ports.*", []*CoveredItem{
+			{Path: fooPath, Method: "post", Location: "body", Field: "spec.ports.name", Hits: 1, Tests: []string{"TestImage"}},
+			{Path: fooPath, Method: "post", Location: "body", Field: "spec.ports.port", Hits: 1, Tests: []string{"TestReplicas"}},
+		}),
+		table.Entry("With endpoints", "path=/apis/example.io/v1/namespaces/*/foos", []*CoveredItem{
+			{Path: fooPath, Method: "delete", Hits: 0, Tests: []string{}},
+			{Path: fooPath, Method: "get", Hits: 2, Tests: []string{"TestImage"}},
+			{Path: fooPath, Method: "post", Hits: 2, Tests: []string{"TestImage", "TestReplicas"}},
+		}),
+		table.Entry("With a query param", "path=/apis/example.io/v1/namespaces/*/foos,method=GET,location=query,field=pretty", []*CoveredItem{
+			{Path: fooPath, Method: "get", Location: "query", Field: "pretty", Hits: 1, Tests: []string{"TestImage"}},
+		}),
+	)
+
+	It("Should answer what a test covers", func() {
+		Expect(CoveredByTest(generate(1, events()), "TestImage")).To(Equal([]*CoveredItem{
+			{Path: fooPath, Method: "get", Hits: 2, Tests: []string{"TestImage"}},
+			{Path: fooPath, Method: "get", Location: "query", Field: "pretty", Hits: 1, Tests: []string{"TestImage"}},
+			{Path: fooPath, Method: "post", Hits: 2, Tests: []string{"TestImage"}},
+			{Path: fooPath, Method: "post", Location: "body", Field: "spec.image", Hits: 1, Tests: []string{"TestImage"}},
+			{Path: fooPath, Method: "post", Location: "body", Field: "spec.ports.name", Hits: 1, Tests: []string{"TestImage"}},
+		}))
+		Expect(CoveredByTest(generate(1, events()), "TestUnknown")).To(BeEmpty())
+	})
+
+	It("Should calculate coverage of a single test", func() {
+		coverage := generate(1, events())
+		expected := generate(1, events()[1:3])
+		actual := CoverageOfTest(coverage, "TestImage")
+		Expect(actual.UniqueHits).To(Equal(expected.UniqueHits))
+		Expect(actual.ExpectedUniqueHits).To(Equal(expected.ExpectedUniqueHits))
+		Expect(actual.Percent).To(Equal(expected.Percent))
+		Expect(actual.Endpoints[fooPath]["post"].Body.UniqueHits).To(Equal(expected.Endpoints[fooPath]["post"].Body.UniqueHits))
+		Expect(actual.Endpoints[fooPath]["post"].Body.Root.Children["spec"].Children["replicas"].Hits).To(BeZero())
+		Expect(coverage.Endpoints[fooPath]["post"].Body.Root.Children["spec"].Children["replicas"].Hits).To(Equal(1),
+			"coverage should not be modified")
+		Expect(CoverageOfTest(coverage, "TestUnknown").UniqueHits).To(BeZero())
+	})
+
+	It("Should calculate coverage of a single test of a loaded report", func() {
+		coverage := generate(1, events())
+		dir, err := ioutil.TempDir("", "tests")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		reportPath := path.Join(dir, "report.json")
+		Expect(Dump(reportPath, coverage)).To(Succeed())
+		loaded, err := Load(reportPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		expected := CoverageOfTest(coverage, "TestImage")
+		actual := CoverageOfTest(loaded, "TestImage")
+		Expect(actual.Percent).To(BeNumerically(">", 0))
+		Expect(actual.Percent).To(Equal(expected.Percent))
+		Expect(actual.UniqueHits).To(Equal(expected.UniqueHits))
+		Expect(actual.RequiredFields).To(Equal(expected.RequiredFields))
+		Expect(actual.OptionalFields).To(Equal(expected.OptionalFields))
+		Expect(actual.Endpoints[fooPath]["post"].Body.UniqueHits).To(Equal(expected.Endpoints[fooPath]["post"].Body.UniqueHits))
+	})
+
+	It("Should keep tests in JSON reports and copies", func() {
+		coverage := generate(1, events())
+		data, err := json.Marshal(coverage)
+		Expect(err).NotTo(HaveOccurred())
+		loaded := &stats.Coverage{}
+		Expect(json.Unmarshal(data, loaded)).To(Succeed())
+		Expect(CoveredByTest(loaded, "TestReplicas")).To(Equal(CoveredByTest(coverage, "TestReplicas")))
+
+		copied := coverage.Copy()
+		copied.Endpoints[fooPath]["post"].Body.AddTest(copied.Endpoints[fooPath]["post"].Body.Root.Children["kind"], "TestKind")
+		Expect(CoveredByTest(coverage, "TestKind")).To(BeEmpty())
+	})
+
+	It("Should not depend on number of workers", func() {
+		var all []*event.Event
+		for i := 0; i < 200; i++ {
+			all = append(all, events()...)
+		}
+		Expect(generate(4, all)).To(Equal(generate(1, all)))
+	})
+
+	table.DescribeTable("Should not parse invalid queries", func(query string) {
+		_, err := ParseTestQuery(query)
+		Expect(err).To(HaveOccurred())
+	},
+		table.Entry("With unknown key", "resource=foos"),
+		table.Entry("With unknown location", "field=a,location=header"),
+	)
+})
@@ -0,0 +1,122 @@
+package stats
+
+// Copy returns a deep copy of the coverage, it can be modified without affecting the original
+func (c *Coverage) Copy() *Coverage {
+	copied := *c
+	if c.Endpoints != nil {
+		copied.Endpoints = make(map[string]map[string]*Endpoint, len(c.Endpoints))
+		for path, methods := range c.Endpoints {
+			copied.Endpoints[path] = make(map[string]*Endpoint, len(methods))
+			for method, e := range methods {
+				copied.Endpoints[path][method] = e.Copy()
+			}
+		}
+	}
+	if c.Actions != nil {
+		copied.Actions = copySummaries(c.Actions)
+	}
+	if c.Kinds != nil {
+		copied.Kinds = copySummaries(c.Kinds)
+	}
+	if c.NonResourceURLs != nil {
+		copied.NonResourceURLs = make(map[string]*NonResourceURL, len(c.NonResourceURLs))
+		for path, u := range c.NonResourceURLs {
+			copiedURL := *u
+			copiedURL.Methods = make(map[string]int, len(u.Methods))
+			for m, hits := range u.Methods {
+				copiedURL.Methods[m] = hits
+			}
+			copied.NonResourceURLs[path] = &copiedURL
+		}
+	}
+	if c.Groups != nil {
+		copied.Groups = make(map[string]*Group, len(c.Groups))
+		for name, g := range c.Groups {
+			copiedGroup := &Group{Summary: g.Summary, Versions: make(map[string]*Version, len(g.Versions))}
+			for v, version := range g.Versions {
+				copiedGroup.Versions[v] = &Version{Summary: version.Summary, Resources: copySummaries(version.Resources)}
+			}
+			copied.Groups[name] = copiedGroup
+		}
+	}
+	if c.Exclusions != nil {
+		copied.Exclusions = make([]*Exclusion, len(c.Exclusions))
+		for i, e := range c.Exclusions {
+			copiedExclusion := *e
+			copied.Exclusions[i] = &copiedExclusion
+		}
+	}
+	if c.Redundancy != nil {
+		redundancy := *c.Redundancy
+		redundancy.Tests = make([]*TestContribution, len(c.Redundancy.Tests))
+		for i, t := range c.Redundancy.Tests {
+			test := *t
+			redundancy.Tests[i] = &test
+		}
+		redundancy.Redundant = copyStrings(c.Redundancy.Redundant)
+		redundancy.Minimal = copyStrings(c.Redundancy.Minimal)
+		copied.Redundancy = &redundancy
+	}
+	if c.Metadata != nil {
+		metadata := *c.Metadata
+		if metadata.Window != nil {
+			window := *metadata.Window
+			metadata.Window = &window
+		}
+		if metadata.Scoring != nil {
+			scoring := *metadata.Scoring
+			metadata.Scoring = &scoring
+		}
+		copied.Metadata = &metadata
+	}
+	return &copied
+}
+
+// Copy returns a deep copy of the endpoint with its body and query tries
+func (e *Endpoint) Copy() *Endpoint {
+	copied := *e
+	copied.Tests = copyStrings(e.Tests)
+	if e.Body != nil {
+		copied.Body = e.Body.Copy()
+	}
+	if e.Query != nil {
+		copied.Query = e.Query.Copy()
+	}
+	return &copied
+}
+
+// Copy returns a deep copy of the trie, parents of copied nodes point to copied nodes
+func (t *Trie) Copy() *Trie {
+	copied := *t
+	if t.Root != nil {
+		copied.Root = t.Root.copy(nil)
+	}
+	return &copied
+}
+
+func (n *Node) copy(parent *Node) *Node {
+	copied := *n
+	copied.Parent = parent
+	copied.Tests = copyStrings(n.Tests)
+	copied.Children = make(map[string]*Node, len(n.Children))
+	for k, child := range n.Children {
+		copied.Children[k] = child.copy(&copied)
+	}
+	return &copied
+}
+
+func copySummaries(summaries map[string]*Summary) map[string]*Summary {
+	copied := make(map[string]*Summary, len(summaries))
+	for k, s := range summaries {
+		summary := *s
+		copied[k] = &summary
+	}
+	return copied
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
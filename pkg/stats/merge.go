@@ -0,0 +1,71 @@
+package stats
+
+// Merge adds hits of other into the coverage, both have to be built from the same swagger definition,
+// endpoints and non-resource URLs missing in the coverage are added
+func (c *Coverage) Merge(other *Coverage) {
+	for path, methods := range other.Endpoints {
+		if _, ok := c.Endpoints[path]; !ok {
+			if c.Endpoints == nil {
+				c.Endpoints = make(map[string]map[string]*Endpoint)
+			}
+			c.Endpoints[path] = make(map[string]*Endpoint)
+		}
+		for method, e := range methods {
+			if endpoint, ok := c.Endpoints[path][method]; ok {
+				endpoint.Merge(e)
+			} else {
+				c.Endpoints[path][method] = e.Copy()
+			}
+		}
+	}
+	for path, u := range other.NonResourceURLs {
+		if c.NonResourceURLs == nil {
+			c.NonResourceURLs = make(map[string]*NonResourceURL)
+		}
+		existing, ok := c.NonResourceURLs[path]
+		if !ok {
+			existing = &NonResourceURL{Path: u.Path, Methods: make(map[string]int), Discovery: u.Discovery}
+			c.NonResourceURLs[path] = existing
+		}
+		existing.Hits += u.Hits
+		for m, hits := range u.Methods {
+			existing.Methods[m] += hits
+		}
+	}
+}
+
+// Merge adds hits and tests of other into the endpoint, both have to be built from the same swagger definition
+func (e *Endpoint) Merge(other *Endpoint) {
+	e.MethodCalled = e.MethodCalled || other.MethodCalled
+	e.Hits += other.Hits
+	for _, test := range other.Tests {
+		e.AddTest(test)
+	}
+	if e.Body != nil && other.Body != nil {
+		e.Body.Merge(other.Body)
+	}
+	if e.Query != nil && other.Query != nil {
+		e.Query.Merge(other.Query)
+	}
+}
+
+// Merge adds hits and tests of other nodes into the trie nodes with the same keys, unique hits are updated,
+// nodes which do not exist in the trie are skipped
+func (t *Trie) Merge(other *Trie) {
+	var merge func(node *Node, otherNode *Node)
+	merge = func(node *Node, otherNode *Node) {
+		if otherNode.Hits > 0 && node.Hits == 0 && node.IsLeaf && !node.Excluded {
+			t.UniqueHits++
+		}
+		node.Hits += otherNode.Hits
+		for _, test := range otherNode.Tests {
+			node.Tests = addTest(node.Tests, test)
+		}
+		for k, otherChild := range otherNode.Children {
+			if child, ok := node.Children[k]; ok {
+				merge(child, otherChild)
+			}
+		}
+	}
+	merge(t.Root, other.Root)
+}
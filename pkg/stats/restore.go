@@ -0,0 +1,37 @@
+package stats
+
+// Restore rebuilds attributes of trie nodes which are not saved in reports, it is called for loaded reports
+func (c *Coverage) Restore() {
+	for _, methods := range c.Endpoints {
+		for _, e := range methods {
+			for _, trie := range []*Trie{e.Body, e.Query} {
+				if trie != nil {
+					trie.Restore()
+				}
+			}
+		}
+	}
+}
+
+// Restore rebuilds parents, keys, depths and leaves of trie nodes, a node is a leaf if it does not have children,
+// the root is a leaf only if the trie expects a hit of an empty object body
+func (t *Trie) Restore() {
+	if t.Root == nil {
+		return
+	}
+	t.Root.Key = "root"
+	t.Root.restore(nil, 0)
+	t.Root.IsLeaf = t.Size == 0 && t.ExpectedUniqueHits > 0
+}
+
+func (n *Node) restore(parent *Node, depth int) {
+	n.Parent, n.Depth = parent, depth
+	if n.Children == nil {
+		n.Children = make(map[string]*Node)
+	}
+	n.IsLeaf = len(n.Children) == 0
+	for k, child := range n.Children {
+		child.Key = k
+		child.restore(n, depth+1)
+	}
+}
@@ -1,11 +1,175 @@
 package stats
 
+import (
+	"sort"
+	"time"
+)
+
 // Coverage represents a REST API statistics
 type Coverage struct {
-	UniqueHits         int                             `json:"uniqueHits"`
-	ExpectedUniqueHits int                             `json:"expectedUniqueHits"`
-	Percent            float64                         `json:"percent"`
-	Endpoints          map[string]map[string]*Endpoint `json:"endpoints"`
+	UniqueHits         int `json:"uniqueHits"`
+	ExpectedUniqueHits int `json:"expectedUniqueHits"`
+	// Score and ExpectedScore are weighted unique hits of the scoring model, percent is their ratio
+	Score             float64                         `json:"score"`
+	ExpectedScore     float64                         `json:"expectedScore"`
+	Percent           float64                         `json:"percent"`
+	Endpoints         map[string]map[string]*Endpoint `json:"endpoints"`
+	Actions           map[string]*Summary             `json:"actions,omitempty"`
+	NonResourceURLs   map[string]*NonResourceURL      `json:"nonResourceURLs,omitempty"`
+	DiscoveryExcluded bool                            `json:"discoveryExcluded,omitempty"`
+	Metadata          *Metadata                       `json:"metadata,omitempty"`
+	Exclusions        []*Exclusion                    `json:"exclusions,omitempty"`
+	// Groups aggregates coverage per API group, core API group is named core
+	Groups map[string]*Group `json:"groups,omitempty"`
+	// Kinds aggregates coverage per kind defined by x-kubernetes-group-version-kind, for instance kubevirt.io/v1alpha3/VirtualMachineInstance
+	Kinds map[string]*Summary `json:"kinds,omitempty"`
+	// Redundancy shows contribution of tests to the coverage, nil if tests are not analyzed
+	Redundancy *Redundancy `json:"redundancy,omitempty"`
+	// RequiredFields and OptionalFields aggregate coverage of required and optional leaf fields included in the total coverage
+	RequiredFields FieldSummary `json:"requiredFields"`
+	OptionalFields FieldSummary `json:"optionalFields"`
+	// ReadOnlyExcluded is set if read-only fields are not included in the total coverage
+	ReadOnlyExcluded bool `json:"readOnlyExcluded,omitempty"`
+}
+
+// FieldSummary represents an aggregated coverage of leaf fields
+type FieldSummary struct {
+	Fields        int     `json:"fields"`
+	CoveredFields int     `json:"coveredFields"`
+	Percent       float64 `json:"percent"`
+}
+
+// Add includes the leaf field into summary
+func (s *FieldSummary) Add(node *Node) {
+	s.Fields++
+	if node.Hits > 0 {
+		s.CoveredFields++
+	}
+	s.Percent = float64(s.CoveredFields) * 100 / float64(s.Fields)
+}
+
+// Group represents an aggregated coverage of an API group
+type Group struct {
+	Summary
+	Versions map[string]*Version `json:"versions"`
+}
+
+// Version represents an aggregated coverage of an API group version,
+// resources include their subresources and both namespaced and cluster scoped paths
+type Version struct {
+	Summary
+	Resources map[string]*Summary `json:"resources"`
+}
+
+// Exclusion represents endpoints or fields which are intentionally not tested, they are not included in the total coverage
+type Exclusion struct {
+	Path     string `json:"path"`
+	Method   string `json:"method,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Location string `json:"location,omitempty"`
+	Reason   string `json:"reason"`
+	// Endpoints and Fields are numbers of excluded endpoints and fields matching the exclusion
+	Endpoints int `json:"endpoints"`
+	Fields    int `json:"fields"`
+	// Hits is a number of called excluded endpoints or a sum of excluded fields hits, excluded items should not be hit
+	Hits int `json:"hits"`
+}
+
+// Redundancy represents contribution of tests to the coverage, only requests attributed to tests are included,
+// covered items are called endpoints and hit fields counted by the total coverage
+type Redundancy struct {
+	// Covered is a number of items covered by all tests
+	Covered int `json:"covered"`
+	// Tests holds contribution of each test sorted by names
+	Tests []*TestContribution `json:"tests"`
+	// Redundant are sorted names of tests which do not cover any item which is not covered by other tests
+	Redundant []string `json:"redundant"`
+	// Minimal is a subset of tests which covers all items covered by all tests, tests are in the order of a greedy selection
+	Minimal []string `json:"minimal"`
+}
+
+// TestContribution represents items covered by a single test
+type TestContribution struct {
+	Name string `json:"name"`
+	// Covered is a number of items covered by the test
+	Covered int `json:"covered"`
+	// Unique is a number of items covered only by the test, they are not covered anymore if the test is removed
+	Unique int `json:"unique"`
+	// UniquePercent is a marginal contribution of the test to the total coverage, the total coverage drops by it
+	// if the test is removed
+	UniquePercent float64 `json:"uniquePercent"`
+	// Gain is a number of items added by the test to the minimal subset in the order of selection, 0 if it is not selected
+	Gain int `json:"gain"`
+}
+
+// Metadata describes how the coverage has been calculated
+type Metadata struct {
+	Window  *TimeWindow `json:"window,omitempty"`
+	Scoring *Scoring    `json:"scoring,omitempty"`
+}
+
+// Scoring describes a model of the coverage numbers, an endpoint is scored by weights of its method call
+// and its hit leaf fields, a weight of a field is a product of its location weight, its required or optional weight
+// and the decay raised to its depth minus one
+type Scoring struct {
+	// Metric is weighted, endpoints which scores only method calls or fields which scores only fields
+	Metric string `json:"metric"`
+	// Method, Body and Query are weights of a method call, a body field and a query param
+	Method float64 `json:"method"`
+	Body   float64 `json:"body"`
+	Query  float64 `json:"query"`
+	// Required and Optional are weights of required and optional fields
+	Required float64 `json:"required"`
+	Optional float64 `json:"optional"`
+	// Decay is a weight of a nested field relative to its parent, 1 does not decay
+	Decay float64 `json:"decay"`
+}
+
+// TimeWindow represents a time window of requests included in the coverage
+type TimeWindow struct {
+	// Start and End are effective bounds of the window, nil if unknown
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+	// StartBound and EndBound are bounds as configured, for instance +5m or marker:configmaps/e2e-phase-start
+	StartBound string `json:"startBound,omitempty"`
+	EndBound   string `json:"endBound,omitempty"`
+	// Requests is a number of requests within the window
+	Requests int `json:"requests"`
+}
+
+// NonResourceURL represents requests for URL which is not a k8s resource and is not defined in swagger, for instance /healthz
+type NonResourceURL struct {
+	Path string `json:"path"`
+	Hits int    `json:"hits"`
+	// Methods contains hits per HTTP method or per k8s verb if method is unknown
+	Methods   map[string]int `json:"methods"`
+	Discovery bool           `json:"discovery"`
+}
+
+// Summary represents an aggregated coverage of a group of endpoints
+type Summary struct {
+	Endpoints          int     `json:"endpoints"`
+	CalledEndpoints    int     `json:"calledEndpoints"`
+	UniqueHits         int     `json:"uniqueHits"`
+	ExpectedUniqueHits int     `json:"expectedUniqueHits"`
+	Score              float64 `json:"score"`
+	ExpectedScore      float64 `json:"expectedScore"`
+	Percent            float64 `json:"percent"`
+}
+
+// Add includes endpoint coverage into summary, endpoint coverage has to be already calculated
+func (s *Summary) Add(e *Endpoint) {
+	s.Endpoints++
+	if e.MethodCalled {
+		s.CalledEndpoints++
+	}
+	s.UniqueHits += e.UniqueHits
+	s.ExpectedUniqueHits += e.ExpectedUniqueHits
+	s.Score += e.Score
+	s.ExpectedScore += e.ExpectedScore
+	if s.ExpectedScore > 0 {
+		s.Percent = s.Score * 100 / s.ExpectedScore
+	}
 }
 
 // Endpoint represents a basic statistics structure which is used to calculate REST API coverage
@@ -13,10 +177,34 @@ type Endpoint struct {
 	Params             `json:"params"`
 	UniqueHits         int     `json:"uniqueHits"`
 	ExpectedUniqueHits int     `json:"expectedUniqueHits"`
+	Score              float64 `json:"score"`
+	ExpectedScore      float64 `json:"expectedScore"`
 	Percent            float64 `json:"percent"`
 	MethodCalled       bool    `json:"methodCalled"`
-	Path               string  `json:"path"`
-	Method             string  `json:"method"`
+	// Hits is a number of requests matched to the endpoint
+	Hits   int    `json:"hits"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	// Action is a k8s action defined by x-kubernetes-action, for instance, list or deletecollection
+	Action string `json:"action,omitempty"`
+	// Discovery is set for k8s API discovery endpoints, for instance /apis or /version
+	Discovery bool `json:"discovery,omitempty"`
+	// Excluded endpoints are not included in the total coverage
+	Excluded bool `json:"excluded,omitempty"`
+	// k8s resource served by the endpoint, group is empty for the core API group
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	// Kind is defined by x-kubernetes-group-version-kind in format group/version/kind, for instance kubevirt.io/v1alpha3/VirtualMachineInstance
+	Kind string `json:"kind,omitempty"`
+	// Tests are sorted names of tests which called the endpoint, requests which are not attributed to any test are not included
+	Tests []string `json:"tests,omitempty"`
+}
+
+// AddTest records that the test called the endpoint
+func (e *Endpoint) AddTest(test string) {
+	e.Tests = addTest(e.Tests, test)
 }
 
 // Params represents body and query parameters
@@ -74,7 +262,7 @@ func (t *Trie) Add(key string, node *Node, leaf bool) *Node {
 // IncreaseHits calculates hits for all nodes in given path
 func (t *Trie) IncreaseHits(node *Node) {
 	node.Hits++
-	if node.IsLeaf && node.Hits == 1 {
+	if node.IsLeaf && node.Hits == 1 && !node.Excluded {
 		t.UniqueHits++
 	}
 	if node.Parent == nil {
@@ -83,14 +271,49 @@ func (t *Trie) IncreaseHits(node *Node) {
 	t.IncreaseHits(node.Parent)
 }
 
+// AddTest records that the test hit the node and all nodes in its path, it is called together with IncreaseHits
+func (t *Trie) AddTest(node *Node, test string) {
+	for ; node != nil; node = node.Parent {
+		node.Tests = addTest(node.Tests, test)
+	}
+}
+
+// Exclude removes node and its children from expected hits, it returns number of excluded leaves
+func (t *Trie) Exclude(node *Node) int {
+	if node.Excluded {
+		return 0
+	}
+	node.Excluded = true
+	excluded := 0
+	if node.IsLeaf {
+		excluded++
+		t.ExpectedUniqueHits--
+		if node.Hits > 0 {
+			t.UniqueHits--
+		}
+	}
+	for _, child := range node.Children {
+		excluded += t.Exclude(child)
+	}
+	return excluded
+}
+
 // Node represents a single data unit for coverage report
 type Node struct {
 	Key      string           `json:"-"`
 	Hits     int              `json:"hits"`
 	Depth    int              `json:"-"`
 	IsLeaf   bool             `json:"-"`
+	Excluded bool             `json:"excluded,omitempty"`
 	Parent   *Node            `json:"-"`
 	Children map[string]*Node `json:"items,omitempty"`
+	// Required, Deprecated, ReadOnly and Type describe the field as defined in swagger, Type is empty if it is not defined
+	Required   bool   `json:"required,omitempty"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+	ReadOnly   bool   `json:"readOnly,omitempty"`
+	Type       string `json:"type,omitempty"`
+	// Tests are sorted names of tests which hit the node
+	Tests []string `json:"tests,omitempty"`
 }
 
 // GetChild returns child for a node
@@ -104,3 +327,67 @@ func (n *Node) GetChild(key string) *Node {
 func (n *Node) String() string {
 	return n.Key
 }
+
+// Field is a node with a dotted path of keys from the node it has been found by
+type Field struct {
+	Path string
+	Node *Node
+}
+
+// Leaves returns leaves of the node with dotted paths sorted by keys, if maxDepth is greater than 0,
+// nodes at max depth below the node are returned instead of their leaves
+func (n *Node) Leaves(maxDepth int) []Field {
+	return n.leaves("", 1, maxDepth)
+}
+
+func (n *Node) leaves(prefix string, depth int, maxDepth int) []Field {
+	keys := make([]string, 0, len(n.Children))
+	for k := range n.Children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var result []Field
+	for _, k := range keys {
+		child := n.Children[k]
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if child.IsLeaf || (maxDepth > 0 && depth >= maxDepth) {
+			result = append(result, Field{Path: path, Node: child})
+			continue
+		}
+		result = append(result, child.leaves(path, depth+1, maxDepth)...)
+	}
+	return result
+}
+
+// SortedEndpoints returns endpoints sorted by path and method
+func (c *Coverage) SortedEndpoints() []*Endpoint {
+	var endpoints []*Endpoint
+	for _, methods := range c.Endpoints {
+		for _, e := range methods {
+			endpoints = append(endpoints, e)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints
+}
+
+// addTest inserts the test into sorted tests if it is not there yet
+func addTest(tests []string, test string) []string {
+	i := sort.SearchStrings(tests, test)
+	if i < len(tests) && tests[i] == test {
+		return tests
+	}
+	tests = append(tests, "")
+	copy(tests[i+1:], tests[i:])
+	tests[i] = test
+	return tests
+}